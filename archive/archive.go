@@ -0,0 +1,101 @@
+// Package archive reads the archives MSX software is distributed in: ZIP,
+// LHA (.LZH) and PMarc (.PMA) files.
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Entry is a file in an archive.
+type Entry struct {
+	Name     string // path in the archive, directories separated by /
+	Size     int
+	Modified time.Time
+	Method   string // compression method
+}
+
+// Archive is an opened archive.
+type Archive struct {
+	Type    string // ZIP, LHA or PMA
+	Entries []Entry
+	read    []func() ([]byte, error)
+}
+
+// Open reads the directory of an archive. The type is taken from the
+// extension of name, or from the contents.
+func Open(name string, data []byte) (*Archive, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".zip":
+		return openZIP(data)
+	case ".lzh", ".lha":
+		return openLHA(data, "LHA")
+	case ".pma":
+		return openLHA(data, "PMA")
+	}
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return openZIP(data)
+	}
+	return openLHA(data, "LHA")
+}
+
+// ReadFile returns the contents of a file; names are not case sensitive and
+// directories are separated by / or \.
+func (a *Archive) ReadFile(name string) ([]byte, error) {
+	name = cleanName(name)
+	for i, entry := range a.Entries {
+		if strings.EqualFold(entry.Name, name) {
+			data, err := a.read[i]()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", entry.Name, err)
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+// cleanName makes a path relative and removes .. so that files cannot be
+// written outside of an output directory.
+func cleanName(name string) string {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	return strings.TrimPrefix(name, "/")
+}
+
+func openZIP(data []byte) (*Archive, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ZIP archive: %v", err)
+	}
+	a := &Archive{Type: "ZIP"}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		method := "stored"
+		if file.Method == zip.Deflate {
+			method = "deflated"
+		}
+		a.Entries = append(a.Entries, Entry{
+			Name:     cleanName(file.Name),
+			Size:     int(file.UncompressedSize64),
+			Modified: file.Modified,
+			Method:   method,
+		})
+		a.read = append(a.read, func() ([]byte, error) {
+			r, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		})
+	}
+	return a, nil
+}
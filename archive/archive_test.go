@@ -0,0 +1,165 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func le16(value int) []byte { return binary.LittleEndian.AppendUint16(nil, uint16(value)) }
+func le32(value int) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(value)) }
+
+// lhaEntry returns a header of the given level followed by the packed data.
+// Level 1 headers store the directory in an extended header, level 2
+// headers also the name.
+func lhaEntry(level int, method, directory, name string, packed, original []byte) []byte {
+	var header []byte
+	switch level {
+	case 0:
+		header = append([]byte{0, 0}, method...)
+		header = append(header, le32(len(packed))...)
+		header = append(header, le32(len(original))...)
+		header = append(header, le32(0x52A16000)...) // 1 May 2021 12:00
+		header = append(header, 0x20, 0, byte(len(name)))
+		header = append(header, name...)
+		header = append(header, le16(int(crc16(original)))...)
+		header[0] = byte(len(header) - 2)
+	case 1:
+		extended := append([]byte{extDirectoryName}, directory...)
+		extended = append(extended, le16(0)...)
+		header = append([]byte{0, 0}, method...)
+		header = append(header, le32(len(packed)+len(extended))...)
+		header = append(header, le32(len(original))...)
+		header = append(header, le32(0x52A16000)...)
+		header = append(header, 0x20, 1, byte(len(name)))
+		header = append(header, name...)
+		header = append(header, le16(int(crc16(original)))...)
+		header = append(header, 'M')
+		header = append(header, le16(len(extended))...)
+		header[0] = byte(len(header) - 2)
+		header = append(header, extended...)
+	case 2:
+		extended := append([]byte{extFileName}, name...)
+		extended = append(extended, le16(0)...)
+		header = append([]byte{0, 0}, method...)
+		header = append(header, le32(len(packed))...)
+		header = append(header, le32(len(original))...)
+		header = append(header, le32(int(time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC).Unix()))...)
+		header = append(header, 0x20, 2)
+		header = append(header, le16(int(crc16(original)))...)
+		header = append(header, 'M')
+		header = append(header, le16(len(extended))...)
+		header = append(header, extended...)
+		copy(header, le16(len(header)))
+	}
+	return append(header, packed...)
+}
+
+func TestOpen_LHA(t *testing.T) {
+	readme := []byte("Press SPACE to start\r\n")
+	code := bytes.Repeat([]byte{0xCD, 0x05, 0x00, 0xC9}, 200)
+	var data []byte
+	data = append(data, "MZ self-extractor"...)
+	data = append(data, lhaEntry(0, "-lh0-", "", "README.TXT", readme, readme)...)
+	data = append(data, lhaEntry(1, "-lh5-", "GAMES\xFF", "CODE.BIN", encodeLZH(code), code)...)
+	data = append(data, lhaEntry(2, "-lh5-", "", "MAIN.COM", encodeLZH(code), code)...)
+	data = append(data, 0)
+
+	a, err := Open("game.lzh", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "LHA", a.Type)
+	assert.Equal(t, []Entry{
+		{Name: "README.TXT", Size: len(readme), Modified: time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC), Method: "lh0"},
+		{Name: "GAMES/CODE.BIN", Size: len(code), Modified: time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC), Method: "lh5"},
+		{Name: "MAIN.COM", Size: len(code), Modified: time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC), Method: "lh5"},
+	}, a.Entries)
+
+	contents, err := a.ReadFile("readme.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, readme, contents)
+	contents, err = a.ReadFile("GAMES\\CODE.BIN")
+	assert.NoError(t, err)
+	assert.Equal(t, code, contents)
+	contents, err = a.ReadFile("MAIN.COM")
+	assert.NoError(t, err)
+	assert.Equal(t, code, contents)
+	_, err = a.ReadFile("NONE.TXT")
+	assert.Error(t, err)
+
+	_, err = Open("empty.lzh", []byte("not an archive"))
+	assert.Error(t, err)
+}
+
+func TestOpen_PMA(t *testing.T) {
+	screen := []byte{0xFE, 0x00, 0x00, 0xFF, 0x69, 0x00, 0x00}
+	var data []byte
+	data = append(data, lhaEntry(2, "-pm0-", "", "TITLE.SC5", screen, screen)...)
+	data = append(data, lhaEntry(2, "-pm2-", "", "GAME.BAS", []byte{1, 2, 3}, []byte("10 CLS"))...)
+	data = append(data, 0)
+
+	a, err := Open("game.pma", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "PMA", a.Type)
+	assert.Len(t, a.Entries, 2)
+
+	contents, err := a.ReadFile("TITLE.SC5")
+	assert.NoError(t, err)
+	assert.Equal(t, screen, contents)
+	_, err = a.ReadFile("GAME.BAS")
+	assert.ErrorContains(t, err, "-pm2- is not supported")
+}
+
+func TestOpen_UnsupportedMethod(t *testing.T) {
+	data := lhaEntry(2, "-lh1-", "", "GAME.BAS", []byte{1, 2, 3}, []byte("10 CLS"))
+	a, err := Open("game.lzh", append(data, 0))
+	assert.NoError(t, err)
+	_, err = a.ReadFile("GAME.BAS")
+	assert.ErrorContains(t, err, "-lh1- is not supported")
+}
+
+func TestOpen_CRCError(t *testing.T) {
+	data := lhaEntry(0, "-lh0-", "", "BAD.TXT", []byte("changed"), []byte("written"))
+	a, err := Open("bad.lzh", append(data, 0))
+	assert.NoError(t, err)
+	_, err = a.ReadFile("BAD.TXT")
+	assert.ErrorContains(t, err, "CRC error")
+}
+
+func TestOpen_ZIP(t *testing.T) {
+	var buffer bytes.Buffer
+	w := zip.NewWriter(&buffer)
+	for name, contents := range map[string]string{"DISK/GAME.DSK": "disk", "../escape.txt": "text"} {
+		f, err := w.Create(name)
+		assert.NoError(t, err)
+		f.Write([]byte(contents))
+	}
+	_, err := w.Create("EMPTY/")
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	a, err := Open("game.zip", buffer.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "ZIP", a.Type)
+	assert.Len(t, a.Entries, 2)
+
+	contents, err := a.ReadFile("disk/game.dsk")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("disk"), contents)
+	contents, err = a.ReadFile("escape.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("text"), contents)
+}
+
+func TestOpen_SizeError(t *testing.T) {
+	code := bytes.Repeat([]byte{0xC9}, 100)
+	data := lhaEntry(0, "-lh5-", "", "BIG.BIN", encodeLZH(code), code)
+	copy(data[11:], le32(0xFD400000)) // original size
+	a, err := Open("big.lzh", append(data, 0))
+	assert.NoError(t, err)
+	_, err = a.ReadFile("BIG.BIN")
+	assert.ErrorContains(t, err, "larger than 64 MB")
+}
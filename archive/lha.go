@@ -0,0 +1,222 @@
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LHA archives are a sequence of headers, each followed by the compressed
+// file. There are three header levels in use: level 0 and 1 headers start
+// with the header size in a byte, level 2 headers with the size in a word.
+// Level 1 and 2 headers are followed by extended headers holding the file
+// and directory name. PMarc archives use the same headers with their own
+// compression methods. The archive ends with a header size of 0.
+
+// Extended header types.
+const (
+	extFileName      = 0x01
+	extDirectoryName = 0x02
+)
+
+// maxSize is the largest file unpacked, far above the 32 MB of an MSX-DOS 2
+// hard disk partition, so a broken header cannot take all memory.
+const maxSize = 64 << 20
+
+// lhaHeader is a decoded header.
+type lhaHeader struct {
+	method   string
+	packed   int // compressed size
+	size     int
+	modified time.Time
+	name     string
+	crc      uint16
+	data     int // offset of the compressed data
+}
+
+// dictionaryBits of the LZ77 methods of LHA.
+var dictionaryBits = map[string]int{
+	"-lh4-": 12,
+	"-lh5-": 13,
+	"-lh6-": 15,
+	"-lh7-": 16,
+}
+
+// storedMethods do not compress.
+var storedMethods = map[string]bool{
+	"-lh0-": true,
+	"-lz4-": true,
+	"-pm0-": true,
+}
+
+func openLHA(data []byte, kind string) (*Archive, error) {
+	offset := findHeader(data)
+	if offset < 0 {
+		return nil, fmt.Errorf("no %s headers found", kind)
+	}
+
+	a := &Archive{Type: kind}
+	for offset < len(data) && data[offset] != 0 {
+		h, err := readHeader(data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("header at offset %d: %v", offset, err)
+		}
+		if h.data+h.packed > len(data) {
+			return nil, fmt.Errorf("%s is truncated", h.name)
+		}
+		packed := data[h.data : h.data+h.packed]
+		offset = h.data + h.packed
+		if h.method == "-lhd-" {
+			continue // directory
+		}
+
+		a.Entries = append(a.Entries, Entry{
+			Name:     cleanName(h.name),
+			Size:     h.size,
+			Modified: h.modified,
+			Method:   strings.Trim(h.method, "-"),
+		})
+		a.read = append(a.read, func() ([]byte, error) {
+			return decompress(h, packed)
+		})
+	}
+	return a, nil
+}
+
+// findHeader returns the offset of the first header, which is not at the
+// start of self-extracting archives.
+func findHeader(data []byte) int {
+	for i := 0; i+22 <= len(data); i++ {
+		method := data[i+2 : i+7]
+		if method[0] == '-' && method[4] == '-' && (method[1] == 'l' || method[1] == 'p') && data[i+20] <= 2 {
+			return i
+		}
+	}
+	return -1
+}
+
+func readHeader(data []byte, offset int) (lhaHeader, error) {
+	if offset+22 > len(data) {
+		return lhaHeader{}, errors.New("truncated header")
+	}
+	header := data[offset:]
+	h := lhaHeader{
+		method: string(header[2:7]),
+		packed: int(binary.LittleEndian.Uint32(header[7:])),
+		size:   int(binary.LittleEndian.Uint32(header[11:])),
+	}
+	stamp := binary.LittleEndian.Uint32(header[15:])
+
+	var next, position int
+	switch level := header[20]; level {
+	case 0, 1:
+		end := 2 + int(header[0])
+		nameLength := int(header[21])
+		if end > len(header) || 24+nameLength > end {
+			return h, errors.New("truncated header")
+		}
+		h.modified = dosTime(stamp)
+		h.name = strings.ReplaceAll(string(header[22:22+nameLength]), "\xFF", "/")
+		h.crc = binary.LittleEndian.Uint16(header[22+nameLength:])
+		if level == 0 {
+			h.data = offset + end
+			return h, nil
+		}
+		next = int(binary.LittleEndian.Uint16(header[end-2:]))
+		position = end
+	case 2:
+		if len(header) < 26 {
+			return h, errors.New("truncated header")
+		}
+		h.modified = time.Unix(int64(stamp), 0).UTC()
+		h.crc = binary.LittleEndian.Uint16(header[21:])
+		next = int(binary.LittleEndian.Uint16(header[24:]))
+		position = 26
+		h.data = offset + int(binary.LittleEndian.Uint16(header[0:]))
+	default:
+		return h, fmt.Errorf("unsupported header level %d", level)
+	}
+
+	// extended headers: a type, the data and the size of the next header
+	var directory string
+	for next != 0 {
+		if next < 3 || position+next > len(header) {
+			return h, errors.New("truncated extended header")
+		}
+		extended := header[position : position+next]
+		value := string(extended[1 : next-2])
+		switch extended[0] {
+		case extFileName:
+			h.name = value
+		case extDirectoryName:
+			directory = strings.TrimSuffix(strings.ReplaceAll(value, "\xFF", "/"), "/")
+		}
+		position += next
+		next = int(binary.LittleEndian.Uint16(extended[next-2:]))
+	}
+	if directory != "" {
+		h.name = directory + "/" + h.name
+	}
+	if header[20] == 1 {
+		// the compressed size of level 1 includes the extended headers
+		h.data = offset + position
+		h.packed -= position - 2 - int(header[0])
+		if h.packed < 0 {
+			return h, errors.New("invalid compressed size")
+		}
+	}
+	return h, nil
+}
+
+// dosTime decodes an MS-DOS time stamp with the date in the high word.
+func dosTime(stamp uint32) time.Time {
+	date, clock := stamp>>16, stamp&0xFFFF
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(date>>9), time.Month(date>>5&0x0F), int(date&0x1F),
+		int(clock>>11), int(clock>>5&0x3F), int(clock&0x1F)*2, 0, time.UTC)
+}
+
+// decompress unpacks a file and checks its CRC.
+func decompress(h lhaHeader, packed []byte) ([]byte, error) {
+	if h.size > maxSize {
+		return nil, fmt.Errorf("original size %d is larger than %d MB", h.size, maxSize>>20)
+	}
+	var data []byte
+	if storedMethods[h.method] {
+		if len(packed) < h.size {
+			return nil, errors.New("stored data is truncated")
+		}
+		data = packed[:h.size]
+	} else if bits, ok := dictionaryBits[h.method]; ok {
+		var err error
+		if data, err = decodeLZH(packed, h.size, bits); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("compression method %s is not supported", h.method)
+	}
+	if crc := crc16(data); crc != h.crc {
+		return nil, fmt.Errorf("CRC error, %04X instead of %04X", crc, h.crc)
+	}
+	return data, nil
+}
+
+// crc16 is the CRC-16 of LHA, with the reversed polynomial 0xA001.
+func crc16(data []byte) uint16 {
+	crc := uint16(0)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
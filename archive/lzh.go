@@ -0,0 +1,237 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The -lh4- to -lh7- methods of LHA compress with LZ77 and static Huffman
+// codes. The stream is a sequence of blocks, each starting with the number
+// of codes in the block and three code tables: one that codes the lengths of
+// the second, the literal and length codes, and the position codes. Codes
+// 0-255 are literal bytes, codes from 256 on copy code-253 bytes from a
+// position given by the number of bits of the distance, followed by the
+// distance without its highest bit.
+
+// Sizes of the code tables.
+const (
+	lengthCodes  = 19  // codes for the lengths of the literal table
+	lengthBits   = 5   // bits of the number of length codes
+	literalCodes = 510 // 256 bytes and 254 copy lengths
+	literalBits  = 9   // bits of the number of literal codes
+	minCopy      = 3   // shortest copy
+	maxCopy      = 256 // longest copy
+	maxCodeBits  = 16  // longest Huffman code
+)
+
+// bitReader reads a stream with the highest bit first.
+type bitReader struct {
+	data    []byte
+	bit     int
+	overrun bool
+}
+
+func (r *bitReader) bits(n int) int {
+	value := 0
+	for i := 0; i < n; i++ {
+		value <<= 1
+		if r.bit/8 < len(r.data) {
+			value |= int(r.data[r.bit/8]>>(7-r.bit%8)) & 1
+		} else {
+			r.overrun = true
+		}
+		r.bit++
+	}
+	return value
+}
+
+// huffman decodes canonical codes: shorter codes come first and codes of
+// the same length are ordered by symbol.
+type huffman struct {
+	counts  [maxCodeBits + 1]int
+	symbols []int
+	single  int // the only symbol of a table without codes, or -1
+}
+
+func newHuffman(lengths []int) (*huffman, error) {
+	h := &huffman{single: -1}
+	for _, length := range lengths {
+		if length > maxCodeBits {
+			return nil, fmt.Errorf("code length %d is too long", length)
+		}
+		h.counts[length]++
+	}
+	h.counts[0] = 0
+	for length := 1; length <= maxCodeBits; length++ {
+		for symbol, l := range lengths {
+			if l == length {
+				h.symbols = append(h.symbols, symbol)
+			}
+		}
+	}
+
+	// the codes must fill the code space exactly
+	left := 1
+	for length := 1; length <= maxCodeBits; length++ {
+		left = left<<1 - h.counts[length]
+		if left < 0 {
+			break
+		}
+	}
+	if left != 0 {
+		return nil, errors.New("invalid Huffman table")
+	}
+	return h, nil
+}
+
+func (h *huffman) decode(r *bitReader) int {
+	if h.single >= 0 {
+		return h.single
+	}
+	code, first, index := 0, 0, 0
+	for length := 1; length <= maxCodeBits; length++ {
+		code |= r.bits(1)
+		count := h.counts[length]
+		if code-first < count {
+			return h.symbols[index+code-first]
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0 // not reached for complete tables
+}
+
+// readLengths reads a table of code lengths stored as 3-bit values, where 7
+// is followed by a 1 bit for each further length. After the entry at skip
+// a 2-bit count of zero lengths follows.
+func readLengths(r *bitReader, size, countBits, skip int) (*huffman, error) {
+	n := r.bits(countBits)
+	if n == 0 {
+		return &huffman{single: r.bits(countBits)}, nil
+	}
+	if n > size {
+		return nil, fmt.Errorf("%d codes in a table of %d", n, size)
+	}
+	lengths := make([]int, size)
+	for i := 0; i < n; {
+		length := r.bits(3)
+		if length == 7 {
+			for r.bits(1) == 1 && length <= maxCodeBits {
+				length++
+			}
+		}
+		lengths[i] = length
+		i++
+		if i == skip {
+			for zeros := r.bits(2); zeros > 0 && i < size; zeros-- {
+				lengths[i] = 0
+				i++
+			}
+		}
+	}
+	return newHuffman(lengths)
+}
+
+// readLiteralLengths reads the lengths of the literal table, coded with the
+// length table: codes 0-2 are runs of zeros, higher codes lengths plus 2.
+func readLiteralLengths(r *bitReader, lengthTable *huffman) (*huffman, error) {
+	n := r.bits(literalBits)
+	if n == 0 {
+		return &huffman{single: r.bits(literalBits)}, nil
+	}
+	if n > literalCodes {
+		return nil, fmt.Errorf("%d codes in a table of %d", n, literalCodes)
+	}
+	lengths := make([]int, literalCodes)
+	for i := 0; i < n; {
+		code := lengthTable.decode(r)
+		if code > 2 {
+			lengths[i] = code - 2
+			i++
+			continue
+		}
+		zeros := 1
+		switch code {
+		case 1:
+			zeros = r.bits(4) + 3
+		case 2:
+			zeros = r.bits(literalBits) + 20
+		}
+		if i+zeros > literalCodes {
+			return nil, errors.New("invalid literal table")
+		}
+		i += zeros
+	}
+	return newHuffman(lengths)
+}
+
+// decodeLZH unpacks size bytes compressed with a dictionary of 1 << bits
+// bytes. The size comes from the header, so it is checked against the most
+// the packed data can hold before memory is taken for it.
+func decodeLZH(data []byte, size, bits int) ([]byte, error) {
+	if size > maxExpansion(len(data)) {
+		return nil, fmt.Errorf("original size %d is too large for %d packed bytes", size, len(data))
+	}
+	positionCodes := bits + 1
+	positionBits := 4
+	if positionCodes > 15 {
+		positionBits = 5
+	}
+
+	r := &bitReader{data: data}
+	out := make([]byte, 0, min(size, len(data)*8))
+	var literals, positions *huffman
+	remaining := 0
+	for len(out) < size {
+		if remaining == 0 {
+			if remaining = r.bits(16); remaining == 0 {
+				return nil, errors.New("empty block")
+			}
+			lengthTable, err := readLengths(r, lengthCodes, lengthBits, 3)
+			if err != nil {
+				return nil, err
+			}
+			if literals, err = readLiteralLengths(r, lengthTable); err != nil {
+				return nil, err
+			}
+			if positions, err = readLengths(r, positionCodes, positionBits, -1); err != nil {
+				return nil, err
+			}
+		}
+		if r.overrun {
+			return nil, errors.New("compressed data is truncated")
+		}
+		remaining--
+
+		code := literals.decode(r)
+		if code < 256 {
+			out = append(out, byte(code))
+			continue
+		}
+		length := code - 256 + minCopy
+		distance := positions.decode(r)
+		if distance > 1 {
+			distance = 1<<(distance-1) + r.bits(distance-1)
+		}
+		start := len(out) - distance - 1
+		for i := 0; i < length && len(out) < size; i++ {
+			if start+i < 0 {
+				out = append(out, ' ') // the dictionary starts filled with spaces
+			} else {
+				out = append(out, out[start+i])
+			}
+		}
+	}
+	if r.overrun {
+		return nil, errors.New("compressed data is truncated")
+	}
+	return out, nil
+}
+
+// maxExpansion returns the most bytes packed bytes can unpack to: every
+// block takes at least its 16-bit count, and holds up to 65535 copies of
+// maxCopy bytes.
+func maxExpansion(packed int) int {
+	return (packed*8/16 + 1) * 0xFFFF * maxCopy
+}
@@ -0,0 +1,222 @@
+package archive
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bitWriter struct {
+	data []byte
+	bit  int
+}
+
+func (w *bitWriter) bits(n, value int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bit%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>i&1 != 0 {
+			w.data[len(w.data)-1] |= 0x80 >> (w.bit % 8)
+		}
+		w.bit++
+	}
+}
+
+// codeLengths gives the used symbols lengths that fill the code space.
+func codeLengths(size int, used map[int]bool) []int {
+	lengths := make([]int, size)
+	n := len(used)
+	if n < 2 {
+		return lengths
+	}
+	m := bits.Len(uint(n - 1))
+	short := 1<<m - n
+	for symbol := 0; symbol < size; symbol++ {
+		if used[symbol] {
+			lengths[symbol] = m
+			if short > 0 {
+				lengths[symbol] = m - 1
+				short--
+			}
+		}
+	}
+	return lengths
+}
+
+func canonicalCodes(lengths []int) []int {
+	codes := make([]int, len(lengths))
+	code := 0
+	for length := 1; length <= maxCodeBits; length++ {
+		for symbol, l := range lengths {
+			if l == length {
+				codes[symbol] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+func singleSymbol(used map[int]bool) int {
+	for symbol := range used {
+		return symbol
+	}
+	return 0
+}
+
+func writeLengths(w *bitWriter, lengths []int, used map[int]bool, countBits, skip int) {
+	if len(used) < 2 {
+		w.bits(countBits, 0)
+		w.bits(countBits, singleSymbol(used))
+		return
+	}
+	n := len(lengths)
+	for lengths[n-1] == 0 {
+		n--
+	}
+	w.bits(countBits, n)
+	for i := 0; i < n; {
+		if l := lengths[i]; l < 7 {
+			w.bits(3, l)
+		} else {
+			w.bits(3, 7)
+			w.bits(l-7+1, (1<<(l-7)-1)<<1)
+		}
+		i++
+		if i == skip {
+			zeros := 0
+			for zeros < 3 && i+zeros < n && lengths[i+zeros] == 0 {
+				zeros++
+			}
+			w.bits(2, zeros)
+			i += zeros
+		}
+	}
+}
+
+// encodeLZH compresses data as one -lh5- block with a greedy match search.
+func encodeLZH(data []byte) []byte {
+	type token struct{ code, position, extra, extraBits int }
+	var tokens []token
+	literalsUsed, positionsUsed := map[int]bool{}, map[int]bool{}
+	for i := 0; i < len(data); {
+		best, distance := 0, 0
+		for start := max(0, i-8192); start < i; start++ {
+			n := 0
+			for n < 256 && i+n < len(data) && data[start+n] == data[i+n] {
+				n++
+			}
+			if n > best {
+				best, distance = n, i-start-1
+			}
+		}
+		if best < minCopy {
+			tokens = append(tokens, token{code: int(data[i])})
+			literalsUsed[int(data[i])] = true
+			i++
+			continue
+		}
+		t := token{code: best - minCopy + 256, position: bits.Len(uint(distance))}
+		if t.position > 1 {
+			t.extraBits = t.position - 1
+			t.extra = distance - 1<<t.extraBits
+		}
+		tokens = append(tokens, t)
+		literalsUsed[t.code] = true
+		positionsUsed[t.position] = true
+		i += best
+	}
+
+	// the literal lengths coded with the length table
+	literalLengths := codeLengths(literalCodes, literalsUsed)
+	type lengthCode struct{ code, extra, extraBits int }
+	var lengthCodesUsed []lengthCode
+	lengthsUsed := map[int]bool{}
+	n := len(literalLengths)
+	for n > 0 && literalLengths[n-1] == 0 {
+		n--
+	}
+	for i := 0; i < n; {
+		run := 0
+		for i+run < n && literalLengths[i+run] == 0 {
+			run++
+		}
+		var c lengthCode
+		switch {
+		case run == 0:
+			c = lengthCode{code: literalLengths[i] + 2}
+			run = 1
+		case run >= 20:
+			run = min(run, 531)
+			c = lengthCode{code: 2, extra: run - 20, extraBits: literalBits}
+		case run >= 3:
+			run = min(run, 18)
+			c = lengthCode{code: 1, extra: run - 3, extraBits: 4}
+		default:
+			run = 1
+		}
+		lengthCodesUsed = append(lengthCodesUsed, c)
+		lengthsUsed[c.code] = true
+		i += run
+	}
+
+	w := &bitWriter{}
+	w.bits(16, len(tokens))
+	lengthLengths := codeLengths(lengthCodes, lengthsUsed)
+	writeLengths(w, lengthLengths, lengthsUsed, lengthBits, 3)
+	if len(literalsUsed) < 2 {
+		w.bits(literalBits, 0)
+		w.bits(literalBits, singleSymbol(literalsUsed))
+	} else {
+		w.bits(literalBits, n)
+		codes := canonicalCodes(lengthLengths)
+		for _, c := range lengthCodesUsed {
+			w.bits(lengthLengths[c.code], codes[c.code])
+			w.bits(c.extraBits, c.extra)
+		}
+	}
+	positionLengths := codeLengths(14, positionsUsed)
+	writeLengths(w, positionLengths, positionsUsed, 4, -1)
+
+	literalCodes, positionCodes := canonicalCodes(literalLengths), canonicalCodes(positionLengths)
+	for _, t := range tokens {
+		w.bits(literalLengths[t.code], literalCodes[t.code])
+		if t.code >= 256 {
+			w.bits(positionLengths[t.position], positionCodes[t.position])
+			w.bits(t.extraBits, t.extra)
+		}
+	}
+	return w.data
+}
+
+func TestDecodeLZH(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	noise := make([]byte, 600)
+	random.Read(noise)
+	tests := map[string][]byte{
+		"text":   bytes.Repeat([]byte("10 PRINT \"HELLO MSX\"\r\n20 GOTO 10\r\n"), 100),
+		"noise":  append(append(noise, noise[:300]...), bytes.Repeat([]byte{0}, 2000)...),
+		"single": bytes.Repeat([]byte{0x55}, 700),
+	}
+	for name, data := range tests {
+		out, err := decodeLZH(encodeLZH(data), len(data), 13)
+		assert.NoError(t, err, name)
+		assert.Equal(t, data, out, name)
+	}
+
+	packed := encodeLZH(tests["text"])
+	_, err := decodeLZH(packed[:len(packed)/2], len(tests["text"]), 13)
+	assert.Error(t, err)
+}
+
+func TestDecodeLZH_Size(t *testing.T) {
+	// the original size of a broken header must not be allocated
+	packed := encodeLZH([]byte("10 PRINT"))
+	_, err := decodeLZH(packed, 0xFD400000, 13)
+	assert.ErrorContains(t, err, "too large")
+}
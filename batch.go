@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"msxconverter/decoders"
+	"msxconverter/fileutils"
+	"msxconverter/format"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// batchJob is a single file of a batch conversion. output is the output path
+// without extension; files found below a directory keep their relative path
+// so the output tree mirrors the input tree.
+type batchJob struct {
+	input  string
+	output string
+}
+
+type batchResult struct {
+	job     batchJob
+	format  string
+	output  string
+	skipped bool
+	err     error
+}
+
+// isBatch tells whether the command line asks for a batch conversion. Two
+// arguments are two inputs when the second is an existing file in a format
+// that can be converted, so that it is not overwritten as the output.
+func isBatch(args []string, root, outputDir string) bool {
+	return root != "" || outputDir != "" || len(args) > 2 ||
+		(len(args) > 0 && strings.ContainsAny(args[0], "*?[")) ||
+		(len(args) == 2 && isInput(args[1]))
+}
+
+// isInput tells whether name is an existing file with a detected format.
+func isInput(name string) bool {
+	if info, err := os.Stat(name); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	return lookupFormat(format.DetectFormat(data, name, "")) != nil
+}
+
+// collectJobs expands the glob patterns and walks the directories given on
+// the command line. Without an output directory the output is written next
+// to the input file. The files of disk and tape images and archives are
+// converted to a directory named after the image.
+func collectJobs(patterns []string, root, outputDir string) ([]batchJob, error) {
+	var files []batchJob
+	add := func(input, rel string) {
+		output := input
+		if outputDir != "" {
+			output = filepath.Join(outputDir, rel)
+		}
+		files = append(files, batchJob{input: input, output: strings.TrimSuffix(output, filepath.Ext(output))})
+	}
+	walk := func(dir, prefix string) error {
+		files, err := fileutils.CollectFiles(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			add(file, filepath.Join(prefix, rel))
+		}
+		return nil
+	}
+
+	if root != "" {
+		if err := walk(root, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if info.IsDir() {
+				err = walk(match, filepath.Base(match))
+			} else {
+				add(match, filepath.Base(match))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var jobs []batchJob
+	for _, file := range uniqueOutputs(files) {
+		// the files of disks, tapes and archives are converted to a directory
+		if expandable(file.input) {
+			found, _, _, err := imageJobs(file.input, file.output)
+			if err == nil {
+				jobs = append(jobs, found...)
+				continue
+			}
+			log.Printf("Warning: %v", err)
+		}
+		jobs = append(jobs, file)
+	}
+	return jobs, nil
+}
+
+// uniqueOutputs gives every job its own output. As in imageJobs, files that
+// only differ in their extension keep it in the output name, e.g.
+// TITLE_SC7.png. Files with the same name from different directories, which
+// globs with an output directory put together, are numbered.
+func uniqueOutputs(jobs []batchJob) []batchJob {
+	outputs := map[string]int{}
+	for _, job := range jobs {
+		outputs[strings.ToUpper(job.output)]++
+	}
+	for i, job := range jobs {
+		if ext := filepath.Ext(job.input); outputs[strings.ToUpper(job.output)] > 1 && ext != "" {
+			jobs[i].output += "_" + ext[1:]
+		}
+	}
+
+	seen := map[string]int{}
+	for i, job := range jobs {
+		key := strings.ToUpper(job.output)
+		seen[key]++
+		if seen[key] > 1 {
+			jobs[i].output = fmt.Sprintf("%s_%d", job.output, seen[key])
+			log.Printf("Warning: %s is written as %s", job.input, filepath.Base(jobs[i].output))
+		}
+	}
+	return jobs
+}
+
+// runBatch converts the jobs using the given number of worker goroutines.
+// The results are returned in the order of the jobs.
+func runBatch(jobs []batchJob, workers int, fileType string, config decoders.Config) []batchResult {
+	results := make([]batchResult, len(jobs))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = convertJob(jobs[i], fileType, config)
+				if config.VerboseOutput {
+					logResult(results[i])
+				}
+			}
+		}()
+	}
+
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+func convertJob(job batchJob, fileType string, config decoders.Config) (result batchResult) {
+	result.job = job
+
+	// a corrupted file must not stop the other conversions
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s: decoder failed: %v\n%s", job.input, r, debug.Stack())
+			result.err = fmt.Errorf("decoder failed: %v", r)
+		}
+	}()
+
+	data, err := fileutils.ReadInput(job.input)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	if config.VerboseOutput {
+		logDetections(job.input, data, fileType)
+	}
+	result.format = format.DetectFormat(data, job.input, fileType)
+	if lookupFormat(result.format) == nil {
+		result.skipped = true
+		return result
+	}
+
+	config.InputFileName = job.input
+	decoded, err := decodeData(data, result.format, config)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	for _, warning := range decoded.Warnings {
+		log.Printf("Warning: %s: %s", job.input, warning)
+	}
+
+	extension := decoded.Extension
+	if extension == "" && decoded.IsText {
+		extension = ".txt"
+	} else if extension == "" {
+		extension = ".png"
+	}
+	result.output = job.output + extension
+
+	if result.err = fileutils.EnsureDir(result.output); result.err != nil {
+		return result
+	}
+	if decoded.IsText {
+		result.err = fileutils.WriteOutput(result.output, decoded.Text)
+	} else {
+		result.err = fileutils.WriteOutputBytes(result.output, decoded.Buffer.Bytes())
+	}
+	return result
+}
+
+func logResult(result batchResult) {
+	switch {
+	case result.err != nil:
+		log.Printf("Failed %s: %v", result.job.input, result.err)
+	case result.skipped:
+		log.Printf("Skipped %s (format %s)", result.job.input, result.format)
+	default:
+		log.Printf("Converted %s (%s) to %s", result.job.input, result.format, result.output)
+	}
+}
+
+// printSummary prints the failed files and the totals, and returns false when
+// a file failed to convert.
+func printSummary(results []batchResult) bool {
+	var converted, skipped, failed int
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			failed++
+			fmt.Printf("Failed: %s: %v\n", result.job.input, result.err)
+		case result.skipped:
+			skipped++
+		default:
+			converted++
+		}
+	}
+
+	fmt.Printf("Converted: %d, skipped: %d, failed: %d\n", converted, skipped, failed)
+	return failed == 0
+}
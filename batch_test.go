@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBatch_TwoInputs(t *testing.T) {
+	dir := t.TempDir()
+	screen := append([]byte{0xFE, 0x00, 0x00, 0xFF, 0x69, 0x00, 0x00}, make([]byte, 0x6A00)...)
+	first := filepath.Join(dir, "A.SC5")
+	second := filepath.Join(dir, "B.SC5")
+	output := filepath.Join(dir, "A.png")
+	assert.NoError(t, os.WriteFile(first, screen, 0o644))
+	assert.NoError(t, os.WriteFile(second, screen, 0o644))
+
+	assert.True(t, isBatch([]string{first, second}, "", ""), "two screens are two inputs")
+	assert.False(t, isBatch([]string{first, output}, "", ""), "a new output file")
+
+	assert.NoError(t, os.WriteFile(output, []byte("\x89PNG\r\n\x1a\n"), 0o644))
+	assert.False(t, isBatch([]string{first, output}, "", ""), "an existing output file")
+}
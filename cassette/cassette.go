@@ -0,0 +1,233 @@
+// Package cassette reads MSX tape images: the blocks of a tape stored in a
+// .CAS file, each preceded by a header marker instead of the tone of the
+// tape.
+package cassette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Header is the marker in front of every block of a .CAS file.
+var Header = []byte{0x1F, 0xA6, 0xDE, 0xBA, 0xCC, 0x13, 0x7D, 0x74}
+
+// File types, given by the byte repeated 10 times in the file header block.
+const (
+	TypeBasic  = 0xD3 // tokenized BASIC, CSAVE
+	TypeASCII  = 0xEA // ASCII text, SAVE "CAS:"
+	TypeBinary = 0xD0 // machine code, BSAVE "CAS:"
+	TypeData   = 0x00 // block without a file header, read by the program itself
+)
+
+// typeNames are the names and extensions of the file types.
+var typeNames = map[byte]struct{ name, extension string }{
+	TypeBasic:  {"BASIC", ".BAS"},
+	TypeASCII:  {"ASCII", ".ASC"},
+	TypeBinary: {"BINARY", ".BIN"},
+	TypeData:   {"DATA", ".DAT"},
+}
+
+// ascii files are saved in blocks of this size, the last ends with eof
+const (
+	asciiBlockSize = 256
+	eof            = 0x1A
+)
+
+// File is a file of a tape. Data is stored as on disk: tokenized BASIC
+// starts with 0xFF, binaries have a BSAVE header and ASCII files end before
+// the end of file mark.
+type File struct {
+	Name   string // unique name on the tape with an extension for the type
+	Type   byte
+	Data   []byte
+	Offset int // position of the first block in the image
+}
+
+// TypeName returns the name of the file type.
+func (f File) TypeName() string {
+	return typeNames[f.Type].name
+}
+
+// Image is a tape image.
+type Image struct {
+	Files    []File
+	Warnings []string
+}
+
+// block is the data between two header markers.
+type block struct {
+	offset int
+	data   []byte
+}
+
+// blocks splits a tape image at the header markers. Data in front of the
+// first marker is ignored.
+func blocks(data []byte) []block {
+	var found []block
+	start := findMarker(data, 0)
+	for start >= 0 {
+		begin := start + len(Header)
+		next := findMarker(data, begin)
+		end := len(data)
+		if next >= 0 {
+			end = next
+		}
+		found = append(found, block{start, data[begin:end]})
+		start = next
+	}
+	return found
+}
+
+// findMarker returns the offset of the first header marker from offset on,
+// or -1. Blocks are padded to a multiple of 8 bytes, so markers elsewhere
+// are data that happens to contain the marker bytes.
+func findMarker(data []byte, offset int) int {
+	offset = (offset + 7) &^ 7
+	for offset+len(Header) <= len(data) {
+		next := bytes.Index(data[offset:], Header)
+		if next < 0 {
+			return -1
+		}
+		if (offset+next)%8 == 0 {
+			return offset + next
+		}
+		offset = (offset + next + 8) &^ 7
+	}
+	return -1
+}
+
+// fileType returns the type of a file header block: ten times the type
+// byte followed by six characters of name.
+func fileType(data []byte) (byte, bool) {
+	if len(data) < 16 {
+		return 0, false
+	}
+	t := data[0]
+	if t != TypeBasic && t != TypeASCII && t != TypeBinary {
+		return 0, false
+	}
+	for _, b := range data[1:10] {
+		if b != t {
+			return 0, false
+		}
+	}
+	return t, true
+}
+
+// Open splits a tape image into its files.
+func Open(data []byte) (*Image, error) {
+	found := blocks(data)
+	if len(found) == 0 {
+		return nil, errors.New("no block headers found, not a .CAS tape image")
+	}
+
+	tape := &Image{}
+	names := map[string]int{}
+	add := func(name string, t byte, contents []byte, offset int) {
+		name += typeNames[t].extension
+		names[name]++
+		if n := names[name]; n > 1 {
+			// tapes often hold several files with the same name
+			name = fmt.Sprintf("%s~%d%s", strings.TrimSuffix(name, typeNames[t].extension), n, typeNames[t].extension)
+		}
+		tape.Files = append(tape.Files, File{Name: name, Type: t, Data: contents, Offset: offset})
+	}
+	warn := func(format string, args ...any) {
+		tape.Warnings = append(tape.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	for i := 0; i < len(found); i++ {
+		header := found[i]
+		t, ok := fileType(header.data)
+		if !ok {
+			add(fmt.Sprintf("BLOCK%02d", i), TypeData, header.data, header.offset)
+			continue
+		}
+		name := fileName(header.data[10:16])
+		if i+1 >= len(found) {
+			warn("%s: file header without data at offset %d", name, header.offset)
+			break
+		}
+
+		i++
+		contents := found[i].data
+		switch t {
+		case TypeBasic:
+			// the program follows without the 0xFF of disk files
+			add(name, t, append([]byte{0xFF}, contents...), header.offset)
+
+		case TypeASCII:
+			// blocks of 256 bytes follow until one holds the end of file
+			var text []byte
+			for {
+				if end := bytes.IndexByte(contents, eof); end >= 0 {
+					text = append(text, contents[:end]...)
+					break
+				}
+				text = append(text, contents[:min(len(contents), asciiBlockSize)]...)
+				if i+1 >= len(found) {
+					warn("%s: ASCII file without end of file mark", name)
+					break
+				}
+				if _, ok := fileType(found[i+1].data); ok {
+					warn("%s: ASCII file ends without end of file mark", name)
+					break
+				}
+				i++
+				contents = found[i].data
+			}
+			add(name, t, text, header.offset)
+
+		case TypeBinary:
+			// begin, end and execution address are followed by the data
+			if len(contents) < 6 {
+				warn("%s: binary block of %d bytes is too short", name, len(contents))
+				continue
+			}
+			begin := binary.LittleEndian.Uint16(contents[0:])
+			end := binary.LittleEndian.Uint16(contents[2:])
+			length := int(end) - int(begin) + 1
+			if end < begin {
+				warn("%s: end address &H%04X is below the begin address &H%04X", name, end, begin)
+				length = 0
+			}
+			code := contents[6:]
+			if length > len(code) {
+				warn("%s: binary has %d bytes, the block only %d", name, length, len(code))
+				length = len(code)
+			}
+			bsave := append([]byte{0xFE}, contents[:6]...)
+			add(name, t, append(bsave, code[:length]...), header.offset)
+		}
+	}
+	return tape, nil
+}
+
+// fileName returns the name of a file header, with the characters that are
+// not allowed in file names replaced.
+func fileName(raw []byte) string {
+	name := []byte(strings.TrimRight(string(raw), " \x00"))
+	for i, c := range name {
+		if c < ' ' || c > '~' || strings.IndexByte(`/\:*?"<>|`, c) >= 0 {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		return "NONAME"
+	}
+	return string(name)
+}
+
+// ReadFile returns the contents of a file by its name, which is not case
+// sensitive.
+func (t *Image) ReadFile(name string) ([]byte, error) {
+	for _, file := range t.Files {
+		if strings.EqualFold(file.Name, name) {
+			return file.Data, nil
+		}
+	}
+	return nil, fmt.Errorf("%s not found on tape", name)
+}
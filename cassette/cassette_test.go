@@ -0,0 +1,109 @@
+package cassette
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tapeBlock returns a header marker followed by data, padded to a multiple
+// of 8 bytes as in real .CAS files.
+func tapeBlock(data []byte) []byte {
+	block := append(append([]byte{}, Header...), data...)
+	for len(block)%8 != 0 {
+		block = append(block, 0)
+	}
+	return block
+}
+
+func fileHeader(t byte, name string) []byte {
+	return append(bytes.Repeat([]byte{t}, 10), []byte(name)...)
+}
+
+func testTape() []byte {
+	var tape []byte
+	tape = append(tape, tapeBlock(fileHeader(TypeBasic, "GAME  "))...)
+	tape = append(tape, tapeBlock([]byte{0x09, 0x80, 0x0A, 0x00, 0x91, 0x00, 0x00, 0x00})...)
+
+	text := bytes.Repeat([]byte("A"), 300)
+	tape = append(tape, tapeBlock(fileHeader(TypeASCII, "LIST  "))...)
+	tape = append(tape, tapeBlock(text[:256])...)
+	tape = append(tape, tapeBlock(append(append([]byte{}, text[256:]...), eof, eof, eof))...)
+
+	tape = append(tape, tapeBlock(fileHeader(TypeBinary, "GAME  "))...)
+	tape = append(tape, tapeBlock([]byte{0x00, 0x90, 0x03, 0x90, 0x00, 0x90, 1, 2, 3, 4, 5})...)
+
+	tape = append(tape, tapeBlock([]byte{9, 8, 7})...)
+	return tape
+}
+
+func TestOpen(t *testing.T) {
+	tape, err := Open(testTape())
+	assert.NoError(t, err)
+	assert.Empty(t, tape.Warnings)
+	assert.Len(t, tape.Files, 4)
+
+	basic := tape.Files[0]
+	assert.Equal(t, "GAME.BAS", basic.Name)
+	assert.Equal(t, "BASIC", basic.TypeName())
+	assert.Equal(t, []byte{0xFF, 0x09, 0x80, 0x0A, 0x00, 0x91}, basic.Data[:6])
+
+	ascii := tape.Files[1]
+	assert.Equal(t, "LIST.ASC", ascii.Name)
+	assert.Equal(t, bytes.Repeat([]byte("A"), 300), ascii.Data)
+
+	binary := tape.Files[2]
+	assert.Equal(t, "GAME.BIN", binary.Name)
+	assert.Equal(t, []byte{0xFE, 0x00, 0x90, 0x03, 0x90, 0x00, 0x90, 1, 2, 3, 4}, binary.Data)
+
+	data := tape.Files[3]
+	assert.Equal(t, "BLOCK07.DAT", data.Name)
+	assert.Equal(t, []byte{9, 8, 7, 0, 0, 0, 0, 0}, data.Data)
+
+	contents, err := tape.ReadFile("game.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, binary.Data, contents)
+	_, err = tape.ReadFile("NONE.BAS")
+	assert.Error(t, err)
+
+	_, err = Open([]byte("no tape"))
+	assert.Error(t, err)
+}
+
+func TestOpen_DuplicateNames(t *testing.T) {
+	var data []byte
+	for i := 0; i < 2; i++ {
+		data = append(data, tapeBlock(fileHeader(TypeBinary, "      "))...)
+		data = append(data, tapeBlock([]byte{0x00, 0x90, 0x00, 0x90, 0x00, 0x90, 1})...)
+	}
+	tape, err := Open(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "NONAME.BIN", tape.Files[0].Name)
+	assert.Equal(t, "NONAME~2.BIN", tape.Files[1].Name)
+}
+
+func TestOpen_Truncated(t *testing.T) {
+	data := tapeBlock(fileHeader(TypeBinary, "CODE  "))
+	data = append(data, tapeBlock([]byte{0x00, 0x90, 0xFF, 0x90, 0x00, 0x90, 1, 2})...)
+	data = append(data, tapeBlock(fileHeader(TypeASCII, "TEXT  "))...)
+	tape, err := Open(data)
+	assert.NoError(t, err)
+	assert.Len(t, tape.Files, 1)
+	assert.Len(t, tape.Warnings, 2)
+	assert.Contains(t, tape.Warnings[0], "the block only 2")
+}
+
+// marker bytes in data that are not at a multiple of 8 do not split the block
+func TestOpen_MarkerInData(t *testing.T) {
+	code := append([]byte{0x00, 0x90, 0x09, 0x90, 0x00, 0x90, 1}, Header...)
+	data := tapeBlock(fileHeader(TypeBinary, "CODE  "))
+	data = append(data, tapeBlock(code)...)
+
+	tape, err := Open(data)
+	assert.NoError(t, err)
+	assert.Empty(t, tape.Warnings)
+	if assert.Len(t, tape.Files, 1) {
+		assert.Equal(t, append(append([]byte{0xFE}, code...), 0), tape.Files[0].Data)
+	}
+}
@@ -0,0 +1,410 @@
+package cassette
+
+import (
+	"fmt"
+	"math"
+)
+
+// The MSX tape format stores bits as frequency shift keying: at 1200 baud a
+// 0 is one cycle of 1200 Hz and a 1 two cycles of 2400 Hz, at 2400 baud the
+// frequencies are doubled. A byte is a 0 start bit, 8 data bits with the
+// lowest first and two 1 stop bits. Every block starts after a silence with
+// a tone of 1 bits, long in front of file headers and short in front of
+// the data.
+
+// Baud rates of the BIOS.
+const (
+	Baud1200 = 1200
+	Baud2400 = 2400
+)
+
+// WAVSampleRate is the sample rate of written WAV files.
+const WAVSampleRate = 44100
+
+// Lengths of the silence and the header tone written in front of blocks, in
+// seconds and in cycles of the high frequency at 1200 baud.
+const (
+	longSilence  = 2.0
+	shortSilence = 1.0
+	longHeader   = 16000
+	shortHeader  = 4000
+)
+
+// Demodulator settings.
+const (
+	minHeaderCycles = 200  // cycles of a header tone before data is read
+	maxStopCycles   = 40   // 1 bits between bytes before a tone ends a block
+	driftRate       = 0.05 // weight of each cycle in the cycle length
+)
+
+// modulator writes cycles of a sine wave.
+type modulator struct {
+	rate    float64
+	samples []int16
+	edge    float64 // start of the next cycle, in samples
+}
+
+func (m *modulator) cycles(frequency float64, count int) {
+	length := m.rate / frequency
+	for i := 0; i < count; i++ {
+		end := m.edge + length
+		for n := len(m.samples); float64(n) < end; n++ {
+			phase := (float64(n) - m.edge) / length
+			m.samples = append(m.samples, int16(24000*math.Sin(2*math.Pi*phase)))
+		}
+		m.edge = end
+	}
+}
+
+func (m *modulator) silence(seconds float64) {
+	m.samples = append(m.samples, make([]int16, int(seconds*m.rate))...)
+	m.edge = float64(len(m.samples))
+}
+
+// EncodeWAV writes the blocks of a .CAS image as tape audio, to be played
+// into the cassette port of an MSX.
+func EncodeWAV(cas []byte, baud int) ([]byte, error) {
+	if baud != Baud1200 && baud != Baud2400 {
+		return nil, fmt.Errorf("unsupported baud rate %d, use 1200 or 2400", baud)
+	}
+	found := blocks(cas)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no block headers found, not a .CAS tape image")
+	}
+
+	low, high := float64(baud), float64(baud*2)
+	m := &modulator{rate: WAVSampleRate}
+	for _, b := range found {
+		if _, ok := fileType(b.data); ok {
+			m.silence(longSilence)
+			m.cycles(high, longHeader*baud/Baud1200)
+		} else {
+			m.silence(shortSilence)
+			m.cycles(high, shortHeader*baud/Baud1200)
+		}
+		for _, value := range b.data {
+			m.cycles(low, 1) // start bit
+			for bit := 0; bit < 8; bit++ {
+				if value&(1<<bit) != 0 {
+					m.cycles(high, 2)
+				} else {
+					m.cycles(low, 1)
+				}
+			}
+			m.cycles(high, 4) // stop bits
+		}
+	}
+	m.silence(shortSilence)
+	return writeWAV(m.samples, WAVSampleRate), nil
+}
+
+// DecodeWAV demodulates a tape recording to a .CAS image. It returns the
+// baud rate that was found and warnings about bytes that could not be read
+// cleanly.
+func DecodeWAV(data []byte) (cas []byte, baud int, warnings []string, err error) {
+	samples, rate, err := readWAV(data)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	filtered := filter(samples, rate)
+
+	// the polarity of recordings differs, so both are tried
+	var best *demodulation
+	for _, inverted := range []bool{false, true} {
+		d := demodulate(cycleLengths(filtered, inverted), rate)
+		if best == nil || d.better(best) {
+			best = d
+		}
+	}
+	if len(best.blocks) == 0 {
+		return nil, 0, nil, fmt.Errorf("no MSX tape blocks found in %d seconds of audio", len(samples)/rate)
+	}
+
+	for _, block := range best.blocks {
+		for len(cas)%8 != 0 {
+			cas = append(cas, 0) // headers are aligned to 8 bytes
+		}
+		cas = append(cas, Header...)
+		cas = append(cas, block...)
+	}
+	return cas, best.baud(), best.warnings, nil
+}
+
+// filter removes the DC offset and hum with a high-pass filter and smooths
+// the signal against hiss.
+func filter(samples []float64, rate int) []float64 {
+	filtered := make([]float64, len(samples))
+	alpha := 1 / (1 + 2*math.Pi*300/float64(rate)) // high-pass at 300 Hz
+	var previous, highPass float64
+	for i, x := range samples {
+		highPass = alpha * (highPass + x - previous)
+		previous = x
+		filtered[i] = highPass
+	}
+
+	// a moving average of a fraction of the shortest cycle
+	window := max(1, rate/16000)
+	smoothed := make([]float64, len(filtered))
+	sum := 0.0
+	for i, x := range filtered {
+		sum += x
+		if i >= window {
+			sum -= filtered[i-window]
+		}
+		smoothed[i] = sum / float64(min(i+1, window))
+	}
+	return smoothed
+}
+
+// cycleLengths returns the lengths in samples of the cycles of a signal,
+// from rising edge to rising edge. Edges are found with a hysteresis that
+// follows the amplitude, so that noise in silent parts gives no cycles.
+func cycleLengths(samples []float64, inverted bool) []float64 {
+	peak := 0.0
+	for _, x := range samples {
+		peak = max(peak, math.Abs(x))
+	}
+	floor := 0.05 * peak
+	decay := math.Pow(0.5, 1/200.0) // the envelope halves in 200 samples
+
+	var lengths []float64
+	envelope, high, last := 0.0, false, -1.0
+	for i, x := range samples {
+		if inverted {
+			x = -x
+		}
+		envelope = max(math.Abs(x), envelope*decay)
+		threshold := max(0.25*envelope, floor)
+		switch {
+		case !high && x > threshold:
+			high = true
+			// interpolate the crossing for lengths below a sample
+			position := float64(i)
+			if i > 0 && samples[i] != samples[i-1] {
+				previous := samples[i-1]
+				if inverted {
+					previous = -previous
+				}
+				position -= (x - threshold) / (x - previous)
+			}
+			if last >= 0 {
+				lengths = append(lengths, position-last)
+			}
+			last = position
+		case high && x < -threshold:
+			high = false
+		}
+	}
+	return lengths
+}
+
+// demodulation is the result of decoding the cycles of one polarity.
+type demodulation struct {
+	blocks   [][]byte
+	cycle    float64 // length of a short cycle in samples
+	rate     int
+	errors   int
+	warnings []string
+}
+
+func (d *demodulation) bytes() int {
+	total := 0
+	for _, block := range d.blocks {
+		total += len(block)
+	}
+	return total
+}
+
+// better prefers the result with fewer errors, then the one with more data.
+func (d *demodulation) better(other *demodulation) bool {
+	if d.errors != other.errors {
+		return d.errors < other.errors
+	}
+	return d.bytes() > other.bytes()
+}
+
+// baud returns the baud rate from the cycle length of the last header.
+func (d *demodulation) baud() int {
+	if d.cycle == 0 {
+		return 0
+	}
+	if float64(d.rate)/d.cycle/2 > 1800 {
+		return Baud2400
+	}
+	return Baud1200
+}
+
+// demodulate decodes the blocks of a tape from its cycle lengths. The length
+// of a short cycle is measured on each header tone and then follows the
+// cycles, so slow changes in tape speed are tracked.
+func demodulate(lengths []float64, rate int) *demodulation {
+	d := &demodulation{rate: rate}
+	i := 0
+	for i < len(lengths) {
+		cycle, start, ok := findHeader(lengths, i, rate)
+		if !ok {
+			break
+		}
+		d.cycle = cycle
+		i = start
+
+		var block []byte
+		var errors []int
+		for {
+			value, next, status := readByte(lengths, i, &cycle)
+			if status == endOfBlock {
+				// noise after the end of the signal looks like a broken byte
+				if len(errors) > 0 && errors[len(errors)-1] == len(block)-1 {
+					block = block[:len(block)-1]
+					errors = errors[:len(errors)-1]
+				}
+				i = next
+				break
+			}
+			if status == framingError {
+				errors = append(errors, len(block))
+			}
+			block = append(block, value)
+			i = next
+		}
+		for _, position := range errors {
+			d.errors++
+			if d.errors <= 10 {
+				d.warnings = append(d.warnings, fmt.Sprintf("framing error in byte %d of block %d", position, len(d.blocks)+1))
+			}
+		}
+		if len(block) > 0 {
+			d.blocks = append(d.blocks, block)
+		}
+	}
+	if d.errors > 10 {
+		d.warnings = append(d.warnings, fmt.Sprintf("%d framing errors in total", d.errors))
+	}
+	return d
+}
+
+// findHeader looks for a header tone from cycle i on: a run of cycles of the
+// same length between 1500 and 6000 Hz. It returns the cycle length and the
+// first cycle after the tone.
+func findHeader(lengths []float64, i int, rate int) (float64, int, bool) {
+	shortest, longest := float64(rate)/6000, float64(rate)/1500
+	for i < len(lengths) {
+		run, sum := 0, 0.0
+		for j := i; j < len(lengths); j++ {
+			length := lengths[j]
+			if length < shortest || length > longest || (run > 0 && math.Abs(length-sum/float64(run)) > 0.25*sum/float64(run)) {
+				break
+			}
+			run++
+			sum += length
+		}
+		if run < minHeaderCycles {
+			i += max(run, 1)
+			continue
+		}
+
+		// follow the tone to its end, where the start bit of the first byte
+		// is a cycle of double length
+		cycle := sum / float64(run)
+		j := i + run
+		for j < len(lengths) && lengths[j] < 1.5*cycle {
+			cycle += driftRate * (lengths[j] - cycle)
+			j++
+		}
+		return cycle, j, true
+	}
+	return 0, len(lengths), false
+}
+
+type byteStatus int
+
+const (
+	byteOK byteStatus = iota
+	framingError
+	endOfBlock
+)
+
+// nextCycle returns the cycle at i, with short glitches merged into the
+// following cycles, and the index after it.
+func nextCycle(lengths []float64, i int, cycle float64) (float64, int) {
+	length := lengths[i]
+	i++
+	for length < 0.55*cycle && i < len(lengths) {
+		length += lengths[i]
+		i++
+	}
+	return length, i
+}
+
+// readByte reads a byte from cycle i on: 1 bits up to the start bit, 8 bits
+// of data and the stop bits. A silence or a new header tone ends the block.
+func readByte(lengths []float64, i int, cycle *float64) (byte, int, byteStatus) {
+	// find the start bit, a long cycle after the stop bits
+	ones := 0
+	for {
+		if i >= len(lengths) {
+			return 0, i, endOfBlock
+		}
+		length, next := nextCycle(lengths, i, *cycle)
+		if length > 3*(*cycle) {
+			return 0, i, endOfBlock // silence
+		}
+		if length >= 1.5*(*cycle) {
+			*cycle += driftRate * (length/2 - *cycle)
+			i = next
+			break
+		}
+		ones++
+		if ones > maxStopCycles {
+			return 0, i, endOfBlock // the tone of the next block
+		}
+		*cycle += driftRate * (length - *cycle)
+		i = next
+	}
+
+	status := byteOK
+	var value byte
+	for bit := 0; bit < 8; bit++ {
+		if i >= len(lengths) {
+			return value, i, framingError
+		}
+		length, next := nextCycle(lengths, i, *cycle)
+		if length > 3*(*cycle) {
+			return value, i, framingError // silence in the middle of a byte
+		}
+		if length >= 1.5*(*cycle) {
+			*cycle += driftRate * (length/2 - *cycle)
+			i = next
+			continue // 0 bit
+		}
+
+		// a 1 bit has a second short cycle
+		*cycle += driftRate * (length - *cycle)
+		i = next
+		if i < len(lengths) {
+			second, next := nextCycle(lengths, i, *cycle)
+			if second < 1.5*(*cycle) {
+				*cycle += driftRate * (second - *cycle)
+				i = next
+			} else {
+				status = framingError
+			}
+		}
+		value |= 1 << bit
+	}
+
+	// the two stop bits are skipped while looking for the next start bit,
+	// but they must be there
+	stop := 0
+	for j := i; j < len(lengths) && stop < 4; stop++ {
+		length, next := nextCycle(lengths, j, *cycle)
+		if length >= 1.5*(*cycle) {
+			break
+		}
+		j = next
+	}
+	if stop < 3 && i < len(lengths) {
+		status = framingError
+	}
+	return value, i, status
+}
@@ -0,0 +1,69 @@
+package cassette
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWAV_RoundTrip(t *testing.T) {
+	for _, baud := range []int{Baud1200, Baud2400} {
+		wav, err := EncodeWAV(testTape(), baud)
+		assert.NoError(t, err)
+
+		cas, detected, warnings, err := DecodeWAV(wav)
+		assert.NoError(t, err)
+		assert.Empty(t, warnings)
+		assert.Equal(t, baud, detected)
+		assert.Equal(t, testTape(), cas)
+	}
+
+	_, err := EncodeWAV(testTape(), 300)
+	assert.Error(t, err)
+	_, err = EncodeWAV([]byte("no tape"), Baud1200)
+	assert.Error(t, err)
+}
+
+// distort plays a recording at a speed that wanders by 3%, inverted and
+// with a DC offset, hum and noise.
+func distort(wav []byte) []byte {
+	samples, rate, _ := readWAV(wav)
+	random := rand.New(rand.NewSource(1))
+	var out []int16
+	for position := 0.0; int(position)+1 < len(samples); {
+		i := int(position)
+		fraction := position - float64(i)
+		x := samples[i]*(1-fraction) + samples[i+1]*fraction
+		seconds := float64(len(out)) / float64(rate)
+		x = -x + 0.1 + 0.05*math.Sin(2*math.Pi*50*seconds) + 0.08*random.NormFloat64()
+		out = append(out, int16(max(-1, min(1, x))*32767))
+		position += 1 + 0.03*math.Sin(2*math.Pi*seconds/3)
+	}
+	return writeWAV(out, rate)
+}
+
+func TestDecodeWAV_Distorted(t *testing.T) {
+	for _, baud := range []int{Baud1200, Baud2400} {
+		wav, err := EncodeWAV(testTape(), baud)
+		assert.NoError(t, err)
+
+		cas, _, warnings, err := DecodeWAV(distort(wav))
+		assert.NoError(t, err)
+		assert.Empty(t, warnings)
+		assert.Equal(t, testTape(), cas)
+	}
+}
+
+func TestReadWAV(t *testing.T) {
+	samples, rate, err := readWAV(writeWAV([]int16{0, 16384, -32768}, 22050))
+	assert.NoError(t, err)
+	assert.Equal(t, 22050, rate)
+	assert.Equal(t, []float64{0, 0.5, -1}, samples)
+
+	_, _, err = readWAV([]byte("RIFF....WAVE"))
+	assert.Error(t, err)
+	_, _, err = readWAV([]byte("not a wav file"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,103 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// WAV format codes
+const (
+	wavPCM        = 0x0001
+	wavFloat      = 0x0003
+	wavExtensible = 0xFFFE
+)
+
+// readWAV returns the samples of a WAV file between -1 and 1, with the
+// channels mixed, and the sample rate.
+func readWAV(data []byte) ([]float64, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var formatCode, channels, bits int
+	var rate int
+	var samples []byte
+	haveFormat := false
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		body := data[offset+8 : min(offset+8+size, len(data))]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, errors.New("WAV format chunk is too short")
+			}
+			formatCode = int(binary.LittleEndian.Uint16(body[0:]))
+			channels = int(binary.LittleEndian.Uint16(body[2:]))
+			rate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits = int(binary.LittleEndian.Uint16(body[14:]))
+			if formatCode == wavExtensible && len(body) >= 26 {
+				formatCode = int(binary.LittleEndian.Uint16(body[24:])) // sub format
+			}
+			haveFormat = true
+		case "data":
+			samples = body
+		}
+		offset += 8 + size + size%2 // chunks are padded to even sizes
+	}
+	if !haveFormat || samples == nil {
+		return nil, 0, errors.New("WAV file without format or data chunk")
+	}
+	if channels < 1 || rate < 8000 {
+		return nil, 0, fmt.Errorf("unsupported WAV file: %d channels at %d Hz", channels, rate)
+	}
+
+	var sample func(b []byte) float64
+	switch {
+	case formatCode == wavPCM && bits == 8:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case formatCode == wavPCM && bits == 16:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case formatCode == wavPCM && bits == 24:
+		sample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case formatCode == wavPCM && bits == 32:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case formatCode == wavFloat && bits == 32:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, 0, fmt.Errorf("unsupported WAV sample format %d with %d bits", formatCode, bits)
+	}
+
+	frameSize := channels * bits / 8
+	mixed := make([]float64, len(samples)/frameSize)
+	for i := range mixed {
+		frame := samples[i*frameSize:]
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += sample(frame[c*bits/8:])
+		}
+		mixed[i] = sum / float64(channels)
+	}
+	return mixed, rate, nil
+}
+
+// writeWAV writes 16-bit mono samples as a WAV file.
+func writeWAV(samples []int16, rate int) []byte {
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(36+len(samples)*2))
+	out.WriteString("WAVEfmt ")
+	binary.Write(&out, binary.LittleEndian, []uint32{16})
+	binary.Write(&out, binary.LittleEndian, []uint16{wavPCM, 1})
+	binary.Write(&out, binary.LittleEndian, []uint32{uint32(rate), uint32(rate * 2)})
+	binary.Write(&out, binary.LittleEndian, []uint16{2, 16})
+	out.WriteString("data")
+	binary.Write(&out, binary.LittleEndian, uint32(len(samples)*2))
+	binary.Write(&out, binary.LittleEndian, samples)
+	return out.Bytes()
+}
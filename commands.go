@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"msxconverter/archive"
+	"msxconverter/cassette"
+	"msxconverter/decoders"
+	"msxconverter/decoders/com"
+	"msxconverter/decoders/images"
+	"msxconverter/decoders/rom"
+	"msxconverter/decoders/wbass2"
+	"msxconverter/disk"
+	"msxconverter/fileutils"
+	"msxconverter/format"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// screen layout of the bitmap modes, used by info
+var screenLayouts = map[string]struct {
+	width, bytesPerLine, paletteOffset int
+}{
+	"SC5": {images.ScreenWidth, images.ScreenWidth / 2, images.PaletteOffset5},
+	"SC7": {images.ScreenWidth7, images.ScreenWidth7 / 2, images.PaletteOffset},
+	"SC8": {images.ScreenWidth, images.ScreenWidth, 0},
+	"S10": {images.ScreenWidth, images.ScreenWidth, images.PaletteOffset},
+	"S12": {images.ScreenWidth, images.ScreenWidth, 0},
+}
+
+func runInfo(arguments []string) {
+	flags := newFlagSet("info")
+	typeFlag := flags.String("t", "", "Specify the file type instead of detecting it")
+	vdpFlag := flags.String("vdp", "", "VDP registers of a VRAM snapshot, e.g. 0=0x06,1=0x60,23=10")
+	flags.Parse(arguments)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	for i, name := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		// a VRAM snapshot can be followed by its register file
+		name, registerFile, _ := strings.Cut(name, ",")
+		data, err := fileutils.ReadInput(name)
+		if err != nil {
+			log.Fatalf("Error reading input: %v", err)
+		}
+		var registers []byte
+		if registerFile != "" {
+			if registers, err = fileutils.ReadInput(registerFile); err != nil {
+				log.Fatalf("Error reading registers: %v", err)
+			}
+		}
+		fmt.Println(name + ":")
+		for _, line := range describe(data, name, *typeFlag) {
+			fmt.Println("  " + line)
+		}
+		if registers == nil && *vdpFlag == "" {
+			continue
+		}
+		lines, err := images.DescribeVDPRegisters(registers, *vdpFlag)
+		if err != nil {
+			lines = []string{"Error:    " + err.Error()}
+		}
+		for _, line := range lines {
+			fmt.Println("  " + line)
+		}
+	}
+}
+
+// describe returns the header fields and metadata of a file.
+func describe(data []byte, name, fileType string) []string {
+	detection := format.Detect(data, name, fileType)
+	info := []string{
+		fmt.Sprintf("Format:   %s (confidence %s)", detection.Format, detection.Confidence),
+		fmt.Sprintf("Size:     %d bytes", len(data)),
+	}
+
+	if len(data) >= 7 && data[0] == 0xFE {
+		begin := binary.LittleEndian.Uint16(data[1:3])
+		end := binary.LittleEndian.Uint16(data[3:5])
+		exec := binary.LittleEndian.Uint16(data[5:7])
+		info = append(info, fmt.Sprintf("BSAVE:    begin &H%04X, end &H%04X, exec &H%04X", begin, end, exec))
+
+		if layout, ok := screenLayouts[detection.Format]; ok {
+			height := images.ScreenHeight
+			if int(end)/layout.bytesPerLine <= images.Height192 {
+				height = images.Height192
+			}
+			info = append(info, fmt.Sprintf("Image:    %dx%d", layout.width, height))
+			if layout.paletteOffset > 0 {
+				included := "no, default or separate palette"
+				if int(end) >= layout.paletteOffset {
+					included = fmt.Sprintf("yes, at &H%04X", layout.paletteOffset)
+				}
+				info = append(info, "Palette:  "+included)
+			}
+		}
+	}
+
+	switch detection.Format {
+	case "BAS":
+		info = append(info, describeBasic(data)...)
+	case "WB2":
+		info = append(info, describeWBASS2(data, name)...)
+	case "FNT":
+		if _, source, _, err := images.FontPatterns(data); err != nil {
+			info = append(info, "Error:    "+err.Error())
+		} else {
+			info = append(info, "Font:     256 glyphs of 8x8, "+source)
+		}
+	case "ROM":
+		info = append(info, rom.Describe(data)...)
+	case "COM":
+		info = append(info, com.Describe(data)...)
+	case "STP":
+		if len(data) >= 4 {
+			info = append(info, fmt.Sprintf("Image:    %dx%d", binary.LittleEndian.Uint16(data[0:2]), binary.LittleEndian.Uint16(data[2:4])))
+		}
+	}
+
+	return info
+}
+
+// describeBasic follows the line links of a tokenized BASIC program.
+func describeBasic(data []byte) []string {
+	lines, first, last := 0, -1, -1
+	for offset := 1; offset+4 <= len(data); {
+		link := int(binary.LittleEndian.Uint16(data[offset:]))
+		if link == 0 {
+			break
+		}
+		number := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		if first < 0 {
+			first = number
+		}
+		last = number
+		lines++
+
+		next := link - 0x8000
+		if next <= offset {
+			return []string{fmt.Sprintf("Lines:    %d (broken line link)", lines)}
+		}
+		offset = next
+	}
+
+	if lines == 0 {
+		return []string{"Lines:    0"}
+	}
+	return []string{fmt.Sprintf("Lines:    %d (%d-%d)", lines, first, last)}
+}
+
+func describeWBASS2(data []byte, name string) []string {
+	symbols, err := wbass2.Symbols(data, name, nil)
+	if err != nil {
+		return []string{"Error:    " + err.Error()}
+	}
+	defined := 0
+	for _, s := range symbols {
+		if len(s.Defined) > 0 {
+			defined++
+		}
+	}
+
+	info := []string{fmt.Sprintf("Labels:   %d (%d defined in this file)", len(symbols), defined)}
+	if result, err := wbass2.DecodeWBASS2(data); err == nil {
+		info = append(info, fmt.Sprintf("Lines:    %d", strings.Count(result.Text, "\n")))
+		for _, warning := range result.Warnings {
+			info = append(info, "Warning:  "+warning)
+		}
+	}
+	return info
+}
+
+func runLs(arguments []string) {
+	flags := newFlagSet("ls")
+	flags.Parse(arguments)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	for i, name := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		image, dir := name, ""
+		if !fileutils.IsImage(name) {
+			image, dir, _ = fileutils.SplitImagePath(name)
+		}
+		data, err := fileutils.ReadInput(image)
+		if err != nil {
+			log.Fatalf("Error reading input: %v", err)
+		}
+		if fileutils.IsArchive(image) {
+			listArchive(image, data)
+			continue
+		}
+		if ext := strings.ToLower(filepath.Ext(image)); ext == ".cas" || ext == ".wav" {
+			listTape(image, data)
+			continue
+		}
+		d, err := disk.Open(data)
+		if err != nil {
+			log.Fatalf("Error opening disk image %s: %v", image, err)
+		}
+		entries, err := d.List(dir)
+		if err != nil {
+			log.Fatalf("Error reading directory: %v", err)
+		}
+
+		fmt.Println(name + ":")
+		files, used := 0, 0
+		for _, entry := range entries {
+			date := "                "
+			if !entry.Modified.IsZero() {
+				date = entry.Modified.Format("2006-01-02 15:04")
+			}
+			if entry.IsDir() {
+				fmt.Printf("  %-12s %8s  %s\n", entry.Name, "<DIR>", date)
+				continue
+			}
+
+			var fileFormat string
+			if contents, err := d.ReadFile(path.Join(dir, entry.Name)); err != nil {
+				fileFormat = "error: " + err.Error()
+			} else {
+				fileFormat = format.DetectFormat(contents, entry.Name, "")
+			}
+			fmt.Printf("  %-12s %8d  %s  %s\n", entry.Name, entry.Size, date, fileFormat)
+			files++
+			used += entry.Size
+		}
+		fmt.Printf("  %d files, %d bytes, %d bytes free\n", files, used, d.FreeSpace())
+	}
+}
+
+// listArchive prints the files of an archive with their date, compression
+// method and detected format.
+func listArchive(name string, data []byte) {
+	a, err := archive.Open(name, data)
+	if err != nil {
+		log.Fatalf("Error opening archive %s: %v", name, err)
+	}
+	fmt.Println(name + ":")
+	used := 0
+	for _, entry := range a.Entries {
+		date := "                "
+		if !entry.Modified.IsZero() {
+			date = entry.Modified.Format("2006-01-02 15:04")
+		}
+		var fileFormat string
+		if contents, err := a.ReadFile(entry.Name); err != nil {
+			fileFormat = "error: " + err.Error()
+		} else {
+			fileFormat = format.DetectFormat(contents, entry.Name, "")
+		}
+		fmt.Printf("  %-12s %8d  %s  %-8s  %s\n", entry.Name, entry.Size, date, entry.Method, fileFormat)
+		used += entry.Size
+	}
+	fmt.Printf("  %d files, %d bytes\n", len(a.Entries), used)
+}
+
+// listTape prints the files of a tape image with their type and detected
+// format.
+func listTape(name string, data []byte) {
+	tape, baud, warnings, err := fileutils.OpenTape(name, data)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if baud != 0 {
+		fmt.Printf("%s: (%d baud)\n", name, baud)
+	} else {
+		fmt.Println(name + ":")
+	}
+	used := 0
+	for _, file := range tape.Files {
+		fileFormat := format.DetectFormat(file.Data, file.Name, "")
+		fmt.Printf("  %-12s %8d  %-6s  %s\n", file.Name, len(file.Data), file.TypeName(), fileFormat)
+		used += len(file.Data)
+	}
+	fmt.Printf("  %d files, %d bytes\n", len(tape.Files), used)
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+}
+
+func runTape(arguments []string) {
+	flags := newFlagSet("tape")
+	baudFlag := flags.Int("baud", cassette.Baud1200, "Baud rate of written recordings (1200 or 2400)")
+	flags.Parse(arguments)
+	args := flags.Args()
+
+	if len(args) == 0 || (*baudFlag != cassette.Baud1200 && *baudFlag != cassette.Baud2400) {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	data, err := fileutils.ReadInput(args[0])
+	if err != nil {
+		log.Fatalf("Error reading input: %v", err)
+	}
+
+	// a recording is demodulated to a .CAS image, a .CAS image is played
+	var output []byte
+	var extension string
+	if strings.EqualFold(filepath.Ext(args[0]), ".wav") {
+		cas, baud, warnings, err := cassette.DecodeWAV(data)
+		if err != nil {
+			log.Fatalf("Error reading tape recording: %v", err)
+		}
+		for _, warning := range warnings {
+			log.Printf("Warning: %s", warning)
+		}
+		log.Printf("Read a recording at %d baud", baud)
+		output, extension = cas, ".cas"
+	} else {
+		if output, err = cassette.EncodeWAV(data, *baudFlag); err != nil {
+			log.Fatalf("Error writing tape recording: %v", err)
+		}
+		extension = ".wav"
+	}
+
+	outputFileName := fileutils.GenerateOutputFilename(args[0], extension)
+	if len(args) > 1 {
+		outputFileName = args[1]
+	}
+	if err := fileutils.WriteOutputBytes(outputFileName, output); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// dateLayouts are the accepted formats of -date and disk date.
+var dateLayouts = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD or YYYY-MM-DD HH:MM[:SS]", value)
+}
+
+func runDisk(arguments []string) {
+	flags := newFlagSet("disk")
+	sizeFlag := flags.Int("size", 720, "Size of a new disk in KB (360 or 720)")
+	forceFlag := flags.Bool("force", false, "Overwrite an existing image with create")
+	dateFlag := flags.String("date", "", "Date of added files (YYYY-MM-DD [HH:MM[:SS]]), instead of the modification time of the PC files")
+	if len(arguments) == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	action := arguments[0]
+	flags.Parse(arguments[1:])
+	args := flags.Args()
+
+	var date time.Time
+	if *dateFlag != "" {
+		var err error
+		if date, err = parseDate(*dateFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	switch {
+	case action == "create" && len(args) == 1:
+		if _, err := os.Stat(args[0]); err == nil && !*forceFlag {
+			log.Fatalf("Error: %s exists, use -force to overwrite it", args[0])
+		}
+		d, err := disk.New(*sizeFlag * 1024)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if err := os.WriteFile(args[0], d.Bytes(), 0o644); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+
+	case action == "add" && len(args) >= 2:
+		// files go to the root directory or to image.dsk:DIR, as NAME.EXT
+		// or under the name given with file=NAME.EXT
+		image, dir, _ := fileutils.SplitImagePath(args[0])
+		err := fileutils.UpdateDisk(image, true, func(d *disk.Image) error {
+			for _, file := range args[1:] {
+				name := strings.ToUpper(filepath.Base(file))
+				if i := strings.LastIndex(file, "="); i > 0 {
+					file, name = file[:i], file[i+1:]
+				}
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				modified := date
+				if modified.IsZero() {
+					if stat, err := os.Stat(file); err == nil {
+						modified = stat.ModTime()
+					}
+				}
+				if err := d.WriteFile(path.Join(dir, name), data, modified); err != nil {
+					return fmt.Errorf("%s: %v", file, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	case action == "rm" && len(args) >= 2:
+		err := fileutils.UpdateDisk(args[0], false, func(d *disk.Image) error {
+			for _, name := range args[1:] {
+				if err := d.Remove(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	case action == "date" && len(args) >= 2:
+		// the date comes from -date, or else it is the current time
+		if date.IsZero() {
+			date = time.Now()
+		}
+		err := fileutils.UpdateDisk(args[0], false, func(d *disk.Image) error {
+			for _, name := range args[1:] {
+				if err := d.SetTime(name, date); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+	default:
+		flags.Usage()
+		fmt.Println()
+		fmt.Println("Actions:")
+		fmt.Println("  create image.dsk                     Create a blank disk")
+		fmt.Println("  add image.dsk[:DIR] file[=NAME.EXT]  Add or replace files")
+		fmt.Println("  rm image.dsk NAME...                 Delete files")
+		fmt.Println("  date image.dsk NAME...               Set the date of files")
+		os.Exit(1)
+	}
+}
+
+func runDetect(arguments []string) {
+	flags := newFlagSet("detect")
+	verboseFlag := flags.Bool("verbose", false, "List every matching format with the reason")
+	flags.Parse(arguments)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	for _, name := range flags.Args() {
+		data, err := fileutils.ReadInput(name)
+		if err != nil {
+			log.Fatalf("Error reading input: %v", err)
+		}
+		detection := format.Detect(data, name, "")
+		fmt.Printf("%s: %s (confidence %s) - %s\n", name, detection.Format, detection.Confidence, detection.Reason)
+		if detections := format.DetectAll(data, name, ""); *verboseFlag && len(detections) > 1 {
+			for _, candidate := range detections[1:] {
+				fmt.Printf("  also %s (confidence %s) - %s\n", candidate.Format, candidate.Confidence, candidate.Reason)
+			}
+		}
+	}
+}
+
+// logDetections logs the formats that match a file, the chosen one first,
+// with the reasons.
+func logDetections(name string, data []byte, fileType string) {
+	detections := format.DetectAll(data, name, fileType)
+	if len(detections) == 0 {
+		detection := format.Detect(data, name, fileType)
+		log.Printf("%s: %s - %s", name, detection.Format, detection.Reason)
+	}
+	for i, detection := range detections {
+		log.Printf("%s: %d. %s (confidence %s) - %s", name, i+1, detection.Format, detection.Confidence, detection.Reason)
+	}
+}
+
+func runListFormats(arguments []string) {
+	flags := newFlagSet("list-formats")
+	flags.Parse(arguments)
+
+	fmt.Printf("%-5s %-14s %-15s %s\n", "TYPE", "DIRECTION", "EXTENSIONS", "DESCRIPTION")
+	for _, f := range fileFormats {
+		direction := "decode"
+		if f.Encode != nil {
+			direction = "decode, encode"
+		}
+		fmt.Printf("%-5s %-14s %-15s %s\n", f.Type, direction, strings.Join(f.Extensions, ","), f.Description)
+	}
+}
+
+func runEncode(arguments []string) {
+	flags := newFlagSet("encode")
+	typeFlag := flags.String("t", "", "MSX file type to create (e.g., SC5, SC7, SC8, S12, BAS, FNT)")
+	profileFlag := flags.String("profile", "", "Colour profile of the DAC levels ("+strings.Join(images.ColorProfiles(), ", ")+") or a JSON profile file")
+	verboseFlag := flags.Bool("verbose", false, "Verbose output")
+	flags.Parse(arguments)
+	args := flags.Args()
+
+	f := lookupFormat(strings.ToUpper(*typeFlag))
+	if len(args) == 0 || f == nil || f.Encode == nil {
+		flags.Usage()
+		if *typeFlag != "" && (f == nil || f.Encode == nil) {
+			fmt.Println()
+			fmt.Println("Error: cannot encode to type:", *typeFlag)
+		}
+		os.Exit(1)
+	}
+
+	setupLogging(*verboseFlag)
+
+	data, err := fileutils.ReadInput(args[0])
+	if err != nil {
+		log.Fatalf("Error reading input: %v", err)
+	}
+
+	config := decoders.Config{VerboseOutput: *verboseFlag, InputFileName: args[0], ColorProfile: *profileFlag}
+	encoded, err := f.Encode(data, config)
+	if err != nil {
+		log.Fatalf("Error encoding data: %v", err)
+	}
+
+	outputFileName := fileutils.GenerateOutputFilename(args[0], encoded.Extension)
+	if len(args) > 1 {
+		outputFileName = args[1]
+	}
+	if err := fileutils.WriteOutputBytes(outputFileName, encoded.Buffer.Bytes()); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+func runPalette(arguments []string) {
+	flags := newFlagSet("palette")
+	typeFlag := flags.String("t", "", "Specify the file type instead of detecting it")
+	formatFlag := flags.String("format", images.PaletteGIMP, "Palette format to write ("+strings.Join(images.PaletteFormats(), ", ")+")")
+	profileFlag := flags.String("profile", "", "Colour profile ("+strings.Join(images.ColorProfiles(), ", ")+") or a JSON profile file")
+	flags.Parse(arguments)
+	args := flags.Args()
+
+	validFormat := slices.Contains(images.PaletteFormats(), *formatFlag)
+	if len(args) == 0 || !validFormat {
+		flags.Usage()
+		if !validFormat {
+			fmt.Println()
+			fmt.Println("Error: unsupported palette format passed:", *formatFlag)
+		}
+		os.Exit(1)
+	}
+
+	profile, err := images.LoadColorProfile(*profileFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	data, err := fileutils.ReadInput(args[0])
+	if err != nil {
+		log.Fatalf("Error reading input: %v", err)
+	}
+	var outputFileName string
+	if len(args) > 1 {
+		outputFileName = args[1]
+	}
+
+	// PC palettes are converted to MSX palettes
+	if format := images.PaletteFormatOf(data, args[0]); format != "" && *typeFlag == "" {
+		msxPalette, err := images.ImportPalette(data, format, profile)
+		if err != nil {
+			log.Fatalf("Error reading palette: %v", err)
+		}
+		if outputFileName == "" {
+			outputFileName = fileutils.GenerateOutputFilename(args[0], ".PL5")
+		}
+		if err := fileutils.WriteOutputBytes(outputFileName, msxPalette); err != nil {
+			log.Fatalf("Error writing output: %v", err)
+		}
+		return
+	}
+
+	msxPalette, err := filePalette(data, args[0], *typeFlag)
+	if err != nil {
+		log.Fatalf("Error reading palette: %v", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	exported, err := images.ExportPalette(msxPalette, *formatFlag, name, profile)
+	if err != nil {
+		log.Fatalf("Error writing palette: %v", err)
+	}
+
+	if outputFileName == "" {
+		if *formatFlag != images.PaletteACT {
+			fmt.Print(string(exported))
+			return
+		}
+		outputFileName = fileutils.GenerateOutputFilename(args[0], ".act")
+	}
+	if err := fileutils.WriteOutputBytes(outputFileName, exported); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// filePalette returns the palette of a screen file, or the first 32 bytes of
+// a palette file such as a .PL5.
+func filePalette(data []byte, name, fileType string) ([]byte, error) {
+	detection := format.Detect(data, name, fileType)
+	if layout, ok := screenLayouts[detection.Format]; ok {
+		if layout.paletteOffset == 0 {
+			return nil, fmt.Errorf("%s files have no palette", detection.Format)
+		}
+		return images.ScreenPalette(data, layout.paletteOffset)
+	}
+	if len(data) < images.MSXPaletteSize {
+		return nil, fmt.Errorf("palette needs %d bytes, got %d", images.MSXPaletteSize, len(data))
+	}
+	return data[:images.MSXPaletteSize], nil
+}
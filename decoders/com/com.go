@@ -0,0 +1,124 @@
+// Package com disassembles MSX-DOS executables, which are loaded and started
+// at 0100h and call the BDOS at 0005h with the function number in C.
+package com
+
+import (
+	"errors"
+	"fmt"
+	"msxconverter/decoders"
+	"msxconverter/decoders/z80"
+	"slices"
+	"strings"
+)
+
+const (
+	origin = 0x0100
+	bdos   = 0x0005
+	// maxSize is the size of the TPA from 0100h up to the BDOS in page 3.
+	maxSize = 0xC000 - origin
+)
+
+// DecodeCOM disassembles an MSX-DOS executable from 0100h. Calls to the BDOS
+// are commented with the name of the function; the bytes that are not
+// reached as code are listed as data and text.
+func DecodeCOM(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	result := decoders.DecoderResult{IsText: true, Extension: ".asm"}
+	if len(data) == 0 {
+		return result, errors.New("empty COM file")
+	}
+	memory := data
+	if len(memory) > maxSize {
+		memory = memory[:maxSize]
+		result.Warnings = append(result.Warnings, fmt.Sprintf("only the first %d bytes fit in the TPA", maxSize))
+	}
+
+	code := z80.Trace(memory, origin, []int{origin})
+	listing := z80.Listing{
+		Memory:  memory,
+		Origin:  origin,
+		Code:    code,
+		Labels:  map[int]string{origin: "START"},
+		Comment: bdosComment(code),
+	}
+	result.Text = fmt.Sprintf("; MSX-DOS program, %d bytes\n%s", len(data), listing.String())
+	return result, nil
+}
+
+// Describe returns the size of a program, how much of it was traced as code
+// and the BDOS functions it calls.
+func Describe(data []byte) []string {
+	if len(data) == 0 {
+		return []string{"Error:    empty COM file"}
+	}
+	memory := data[:min(len(data), maxSize)]
+	code := z80.Trace(memory, origin, []int{origin})
+	comment := bdosComment(code)
+	size := 0
+	var functions []string
+	for _, address := range z80.Addresses(code) {
+		size += code[address].Length()
+		if name := strings.TrimPrefix(comment(code[address]), "BDOS"); name != "" && !slices.Contains(functions, name[1:]) {
+			functions = append(functions, name[1:])
+		}
+	}
+	calls := "none found"
+	if len(functions) > 0 {
+		calls = strings.Join(functions, ", ")
+	}
+	return []string{
+		fmt.Sprintf("Program:  %d bytes at &H%04X-&H%04X", len(data), origin, origin+len(data)-1),
+		fmt.Sprintf("Code:     %d bytes traced from &H%04X", size, origin),
+		"BDOS:     " + calls,
+	}
+}
+
+// bdosComment returns a Comment function that names the BDOS function of
+// CALL 0005h and JP 0005h. The function number is the last value loaded into
+// C in the instructions before, up to a label or an instruction that
+// changes C in another way.
+func bdosComment(code map[int]z80.Instruction) func(z80.Instruction) string {
+	addresses := z80.Addresses(code)
+	index := map[int]int{}
+	for i, address := range addresses {
+		index[address] = i
+	}
+	targets := map[int]bool{}
+	for _, instruction := range code {
+		targets[instruction.Target] = true
+	}
+
+	return func(instruction z80.Instruction) string {
+		if instruction.Target != bdos {
+			return ""
+		}
+		for i := index[instruction.Address]; i > 0 && !targets[addresses[i]]; i-- {
+			previous := code[addresses[i-1]]
+			if previous.Address+previous.Length() != addresses[i] {
+				break
+			}
+			if function, ok := loadsC(previous); ok {
+				if name, ok := bdosFunctions[function]; ok {
+					return fmt.Sprintf("BDOS %s %s", z80.Hex(function, 2), name)
+				}
+				return "BDOS " + z80.Hex(function, 2)
+			}
+			if changesC(previous) {
+				break
+			}
+		}
+		return "BDOS"
+	}
+}
+
+// loadsC returns the value an LD C,n or LD BC,nn instruction loads into C.
+func loadsC(instruction z80.Instruction) (int, bool) {
+	if instruction.Immediate < 0 || (instruction.Destination != "C" && instruction.Destination != "BC") {
+		return 0, false
+	}
+	return instruction.Immediate & 0xFF, true
+}
+
+// changesC reports whether an instruction may change C.
+func changesC(instruction z80.Instruction) bool {
+	return instruction.Call || instruction.Destination == "C" || instruction.Destination == "BC"
+}
@@ -0,0 +1,73 @@
+package com
+
+import (
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCOM prints a string with STROUT and ends with TERM0.
+var testCOM = append([]byte{
+	0x11, 0x0D, 0x01, // 0100 LD DE,010Dh
+	0x0E, 0x09, // 0103 LD C,09h
+	0xCD, 0x05, 0x00, // 0105 CALL 0005h
+	0x0E, 0x00, // 0108 LD C,00h
+	0xC3, 0x05, 0x00, // 010A JP 0005h
+}, []byte("Hello, MSX\r\n$")...)
+
+func TestDecodeCOM(t *testing.T) {
+	result, err := DecodeCOM(testCOM, decoders.Config{})
+	assert.NoError(t, err)
+	assert.True(t, result.IsText)
+	assert.Contains(t, result.Text, "START:\n0100  11 0D 01      LD DE,010Dh\n")
+	assert.Contains(t, result.Text, "CALL 0005h              ; BDOS 09h STROUT\n")
+	assert.Contains(t, result.Text, "JP 0005h                ; BDOS 00h TERM0\n")
+	assert.Contains(t, result.Text, "010D                DB \"Hello, MSX\",0Dh,0Ah,\"$\"\n")
+
+	_, err = DecodeCOM(nil, decoders.Config{})
+	assert.Error(t, err)
+}
+
+func TestDecodeCOM_UnknownFunction(t *testing.T) {
+	data := []byte{
+		0x4F,             // LD C,A
+		0xCD, 0x05, 0x00, // CALL 0005h
+		0x01, 0x6F, 0x00, // LD BC,006Fh
+		0x3E, 0x01, // LD A,01h
+		0xCD, 0x05, 0x00, // CALL 0005h
+		0x0E, 0x2F, // LD C,2Fh
+		0x18, 0x00, // JR 0110h
+		0xCD, 0x05, 0x00, // 0110 CALL 0005h, C unknown at a label
+		0xC9, // RET
+	}
+	result, err := DecodeCOM(data, decoders.Config{})
+	assert.NoError(t, err)
+	assert.Contains(t, result.Text, "0101  CD 05 00      CALL 0005h              ; BDOS\n")
+	assert.Contains(t, result.Text, "CALL 0005h              ; BDOS 6Fh DOSVER\n")
+	assert.Contains(t, result.Text, "0110  CD 05 00      CALL 0005h              ; BDOS\n")
+}
+
+func TestDecodeCOM_ChangedC(t *testing.T) {
+	data := []byte{
+		0x0E, 0x09, // LD C,09h
+		0xCD, 0x0F, 0x01, // CALL 010Fh, which may change C
+		0xCD, 0x05, 0x00, // CALL 0005h
+		0x0E, 0x02, // LD C,02h
+		0xCB, 0x41, // BIT 0,C
+		0xC3, 0x05, 0x00, // JP 0005h
+		0xC9, // 010F RET
+	}
+	result, err := DecodeCOM(data, decoders.Config{})
+	assert.NoError(t, err)
+	assert.Contains(t, result.Text, "0105  CD 05 00      CALL 0005h              ; BDOS\n")
+	assert.Contains(t, result.Text, "JP 0005h                ; BDOS 02h CONOUT\n")
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, []string{
+		"Program:  26 bytes at &H0100-&H0119",
+		"Code:     13 bytes traced from &H0100",
+		"BDOS:     09h STROUT, 00h TERM0",
+	}, Describe(testCOM))
+}
@@ -7,14 +7,29 @@ type Decoder interface {
 }
 
 type Config struct {
-	OutputFormat    string
-	DoubleImageSize bool
-	VerboseOutput   bool
-	ExtraData       []byte
+	OutputFormat  string
+	Quality       int
+	Scale         int
+	Aspect        string
+	Scaler        string
+	Filters       []string
+	Color0        string
+	ColorProfile  string
+	Sprites       string
+	SpriteSize    int
+	VDPRegisters  string
+	VerboseOutput bool
+	ExtraData     []byte
+	InputFileName string
+	Assemble      string
+	Symbols       string
+	Disassemble   bool
 }
 
 type DecoderResult struct {
-	Text   string
-	Buffer *bytes.Buffer
-	IsText bool
+	Text      string
+	Buffer    *bytes.Buffer
+	IsText    bool
+	Extension string
+	Warnings  []string
 }
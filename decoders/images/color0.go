@@ -0,0 +1,56 @@
+package images
+
+import (
+	"image/color"
+	"msxconverter/decoders"
+)
+
+// Ways to write colour 0, which the VDP shows as the backdrop colour unless
+// the TP bit of R#8 is set. A backdrop colour is given as three digits R, G
+// and B from 0 to 7, as in COLOR=(0,R,G,B).
+const (
+	Color0Opaque      = "opaque"      // the palette colour, as with TP set
+	Color0Transparent = "transparent" // an alpha of 0
+)
+
+// IsColor0Mode tells whether value is a valid colour 0 mode.
+func IsColor0Mode(value string) bool {
+	if value == "" || value == Color0Opaque || value == Color0Transparent {
+		return true
+	}
+	_, ok := parseColor0(value)
+	return ok
+}
+
+// parseColor0 parses a backdrop colour of three digits from 0 to 7.
+func parseColor0(value string) (color.RGBA, bool) {
+	if len(value) != 3 {
+		return color.RGBA{}, false
+	}
+	var levels [3]uint8
+	for i := range levels {
+		if value[i] < '0' || value[i] > '7' {
+			return color.RGBA{}, false
+		}
+		levels[i] = color3bitsLookupTable[value[i]-'0']
+	}
+	return color.RGBA{R: levels[0], G: levels[1], B: levels[2], A: 255}, true
+}
+
+// applyColor0 returns the palette with colour 0 changed as configured. A
+// transparent colour keeps its RGB value, so indexed PNG files still store it.
+func applyColor0(palette color.Palette, config decoders.Config) color.Palette {
+	if len(palette) == 0 || config.Color0 == "" || config.Color0 == Color0Opaque {
+		return palette
+	}
+
+	changed := make(color.Palette, len(palette))
+	copy(changed, palette)
+	if backdrop, ok := parseColor0(config.Color0); ok {
+		changed[0] = backdrop
+	} else if config.Color0 == Color0Transparent {
+		r, g, b, _ := palette[0].RGBA()
+		changed[0] = color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0}
+	}
+	return changed
+}
@@ -0,0 +1,249 @@
+package images
+
+import (
+	"image"
+	"math"
+)
+
+// Post-processing filters that make the output look like an MSX on a TV.
+const (
+	FilterScanlines = "scanlines" // dark gaps between the lines
+	FilterRGBMask   = "rgbmask"   // aperture grille of red, green and blue stripes
+	FilterBloom     = "bloom"     // glow around bright areas
+	FilterNTSC      = "ntsc"      // composite video blur and colour artefacts
+	FilterCRT       = "crt"       // scanlines, rgbmask and bloom
+)
+
+// Filter strengths.
+const (
+	scanlineDepth  = 0.45 // darkening in the middle of the gap
+	maskDim        = 0.7  // other channels of an RGB mask stripe
+	maskBoost      = 1.15 // brightness compensation of the mask
+	bloomThreshold = 0.6  // luma above which pixels glow
+	bloomStrength  = 0.35
+	ntscCrosstalk  = 0.35 // luma detail decoded as colour
+	ntscSubcarrier = 2 * math.Pi * 3.579545 / 5.369318
+	ntscLumaTaps   = 1 // luma blur radius in MSX pixels
+	ntscChromaTaps = 3 // chroma blur radius in MSX pixels
+)
+
+// Filters returns the supported post-processing filters.
+func Filters() []string {
+	return []string{FilterScanlines, FilterRGBMask, FilterBloom, FilterNTSC, FilterCRT}
+}
+
+// expandFilters resolves the presets and reports whether the NTSC filter,
+// which works on unscaled pixels, is among them.
+func expandFilters(filters []string) (after []string, ntsc bool) {
+	for _, filter := range filters {
+		switch filter {
+		case FilterNTSC:
+			ntsc = true
+		case FilterCRT:
+			after = append(after, FilterRGBMask, FilterScanlines, FilterBloom)
+		default:
+			after = append(after, filter)
+		}
+	}
+	return after, ntsc
+}
+
+// floatImage holds RGBA values from 0 to 1 for filtering.
+type floatImage struct {
+	width, height int
+	pix           []float64 // r, g, b, a per pixel
+}
+
+func newFloatImage(img image.Image) *floatImage {
+	bounds := img.Bounds()
+	f := &floatImage{width: bounds.Dx(), height: bounds.Dy()}
+	f.pix = make([]float64, f.width*f.height*4)
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			i := (y*f.width + x) * 4
+			f.pix[i+0] = float64(r) / 0xFFFF
+			f.pix[i+1] = float64(g) / 0xFFFF
+			f.pix[i+2] = float64(b) / 0xFFFF
+			f.pix[i+3] = float64(a) / 0xFFFF
+		}
+	}
+	return f
+}
+
+func (f *floatImage) toRGBA() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, f.width, f.height))
+	for i, value := range f.pix {
+		img.Pix[i] = uint8(math.Round(math.Max(0, math.Min(1, value)) * 255))
+	}
+	return img
+}
+
+// applyNTSC simulates a composite video signal: the colour is carried on a
+// 3.58 MHz subcarrier, so it is blurred more than the luma, and fine luma
+// detail shows up as colour fringes, as on MSX1 machines connected to a TV.
+// It expects one image pixel per MSX pixel; wide images have two pixels per
+// pixel clock of the 256 pixel wide modes.
+func applyNTSC(img image.Image, wide bool) image.Image {
+	pixelsPerClock := 1
+	if wide {
+		pixelsPerClock = 2
+	}
+	f := newFloatImage(img)
+	yiq := make([][3]float64, f.width)
+	hf := make([]float64, f.width)
+	for y := 0; y < f.height; y++ {
+		row := f.pix[y*f.width*4 : (y+1)*f.width*4]
+		for x := range yiq {
+			r, g, b := row[x*4], row[x*4+1], row[x*4+2]
+			yiq[x] = [3]float64{
+				0.299*r + 0.587*g + 0.114*b,
+				0.596*r - 0.274*g - 0.322*b,
+				0.211*r - 0.523*g + 0.312*b,
+			}
+		}
+
+		luma := blurRow(yiq, 0, ntscLumaTaps*pixelsPerClock)
+		i := blurRow(yiq, 1, ntscChromaTaps*pixelsPerClock)
+		q := blurRow(yiq, 2, ntscChromaTaps*pixelsPerClock)
+
+		// the subcarrier phase shifts by half a cycle every line
+		for x := range hf {
+			hf[x] = yiq[x][0] - luma[x]
+		}
+		for x := range yiq {
+			phase := float64(x)*ntscSubcarrier/float64(pixelsPerClock) + float64(y%2)*math.Pi
+			i[x] += ntscCrosstalk * hf[x] * math.Cos(phase)
+			q[x] += ntscCrosstalk * hf[x] * math.Sin(phase)
+
+			row[x*4+0] = luma[x] + 0.956*i[x] + 0.621*q[x]
+			row[x*4+1] = luma[x] - 0.272*i[x] - 0.647*q[x]
+			row[x*4+2] = luma[x] - 1.106*i[x] + 1.703*q[x]
+		}
+	}
+	return f.toRGBA()
+}
+
+// blurRow averages a channel of a row with a triangular kernel.
+func blurRow(row [][3]float64, channel, radius int) []float64 {
+	out := make([]float64, len(row))
+	for x := range row {
+		var sum, weight float64
+		for k := -radius; k <= radius; k++ {
+			w := float64(radius + 1 - abs(k))
+			sum += row[clamp(x+k, 0, len(row)-1)][channel] * w
+			weight += w
+		}
+		out[x] = sum / weight
+	}
+	return out
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// applyFilters runs the filters that work on the scaled image. lineHeight is
+// the number of output rows per MSX line.
+func applyFilters(img image.Image, filters []string, lineHeight float64) image.Image {
+	if len(filters) == 0 {
+		return img
+	}
+	f := newFloatImage(img)
+	for _, filter := range filters {
+		switch filter {
+		case FilterScanlines:
+			f.scanlines(lineHeight)
+		case FilterRGBMask:
+			f.rgbMask()
+		case FilterBloom:
+			f.bloom(max(1, int(math.Round(lineHeight))))
+		}
+	}
+	return f.toRGBA()
+}
+
+// scanlines darkens the rows towards the edges of each MSX line. Without
+// vertical scaling every other row is darkened.
+func (f *floatImage) scanlines(lineHeight float64) {
+	for y := 0; y < f.height; y++ {
+		var dark float64
+		if lineHeight < 2 {
+			dark = float64(y % 2)
+		} else {
+			position := math.Mod((float64(y)+0.5)/lineHeight, 1) // 0.5 is the middle of the line
+			dark = math.Pow(math.Abs(position-0.5)*2, 2)
+		}
+		factor := 1 - scanlineDepth*dark
+		row := f.pix[y*f.width*4 : (y+1)*f.width*4]
+		for x := 0; x < f.width; x++ {
+			row[x*4+0] *= factor
+			row[x*4+1] *= factor
+			row[x*4+2] *= factor
+		}
+	}
+}
+
+// rgbMask dims two of the three channels in repeating columns.
+func (f *floatImage) rgbMask() {
+	for y := 0; y < f.height; y++ {
+		for x := 0; x < f.width; x++ {
+			i := (y*f.width + x) * 4
+			for channel := 0; channel < 3; channel++ {
+				if channel != x%3 {
+					f.pix[i+channel] *= maskDim
+				}
+				f.pix[i+channel] *= maskBoost
+			}
+		}
+	}
+}
+
+// bloom adds a blurred copy of the bright areas.
+func (f *floatImage) bloom(radius int) {
+	glow := make([]float64, len(f.pix))
+	for i := 0; i < len(f.pix); i += 4 {
+		luma := 0.299*f.pix[i] + 0.587*f.pix[i+1] + 0.114*f.pix[i+2]
+		if luma > bloomThreshold {
+			copy(glow[i:i+3], f.pix[i:i+3])
+		}
+	}
+
+	// three box blurs approximate a gaussian blur
+	for pass := 0; pass < 3; pass++ {
+		glow = boxBlur(glow, f.width, f.height, radius, 1, 0)
+		glow = boxBlur(glow, f.width, f.height, radius, 0, 1)
+	}
+
+	for i := 0; i < len(f.pix); i += 4 {
+		for channel := 0; channel < 3; channel++ {
+			f.pix[i+channel] += bloomStrength * glow[i+channel]
+		}
+	}
+}
+
+// boxBlur blurs the colour channels along the direction (dx, dy).
+func boxBlur(pix []float64, width, height, radius, dx, dy int) []float64 {
+	out := make([]float64, len(pix))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var sum [3]float64
+			for k := -radius; k <= radius; k++ {
+				sx := clamp(x+k*dx, 0, width-1)
+				sy := clamp(y+k*dy, 0, height-1)
+				i := (sy*width + sx) * 4
+				sum[0] += pix[i]
+				sum[1] += pix[i+1]
+				sum[2] += pix[i+2]
+			}
+			i := (y*width + x) * 4
+			for channel := range sum {
+				out[i+channel] = sum[channel] / float64(2*radius+1)
+			}
+		}
+	}
+	return out
+}
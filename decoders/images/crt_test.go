@@ -0,0 +1,92 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func flatImage(width, height int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = []byte{c.R, c.G, c.B, c.A}[i%4]
+	}
+	return img
+}
+
+func TestExpandFilters(t *testing.T) {
+	after, ntsc := expandFilters([]string{FilterNTSC, FilterCRT})
+	assert.True(t, ntsc)
+	assert.Equal(t, []string{FilterRGBMask, FilterScanlines, FilterBloom}, after)
+
+	after, ntsc = expandFilters([]string{FilterScanlines})
+	assert.False(t, ntsc)
+	assert.Equal(t, []string{FilterScanlines}, after)
+}
+
+func TestScanlines(t *testing.T) {
+	gray := color.RGBA{200, 200, 200, 255}
+	img := applyFilters(flatImage(4, 8, gray), []string{FilterScanlines}, 4).(*image.RGBA)
+
+	// the middle of each line keeps most of its brightness, the edges are darker
+	middle := img.RGBAAt(0, 2).R
+	edge := img.RGBAAt(0, 0).R
+	assert.Greater(t, middle, edge)
+	assert.Equal(t, img.RGBAAt(0, 0), img.RGBAAt(0, 7))
+
+	img = applyFilters(flatImage(4, 4, gray), []string{FilterScanlines}, 1).(*image.RGBA)
+	assert.Equal(t, gray, img.RGBAAt(0, 0))
+	assert.Less(t, img.RGBAAt(0, 1).R, gray.R)
+}
+
+func TestRGBMask(t *testing.T) {
+	img := applyFilters(flatImage(3, 1, color.RGBA{100, 100, 100, 255}), []string{FilterRGBMask}, 1).(*image.RGBA)
+	red := img.RGBAAt(0, 0)
+	assert.Greater(t, red.R, red.G)
+	assert.Equal(t, red.G, red.B)
+	green := img.RGBAAt(1, 0)
+	assert.Greater(t, green.G, green.R)
+}
+
+func TestBloom(t *testing.T) {
+	img := flatImage(9, 9, color.RGBA{0, 0, 0, 255})
+	img.SetRGBA(4, 4, color.RGBA{255, 255, 255, 255})
+	bloomed := applyFilters(img, []string{FilterBloom}, 1).(*image.RGBA)
+	assert.NotZero(t, bloomed.RGBAAt(5, 4).R)
+	assert.Zero(t, bloomed.RGBAAt(0, 0).R)
+}
+
+func TestNTSC(t *testing.T) {
+	// flat areas keep their colour
+	c := color.RGBA{0x24, 0xdb, 0x24, 255}
+	flat := applyNTSC(flatImage(16, 2, c), false).(*image.RGBA)
+	got := flat.RGBAAt(8, 1)
+	assert.InDelta(t, c.R, got.R, 1)
+	assert.InDelta(t, c.G, got.G, 1)
+	assert.InDelta(t, c.B, got.B, 1)
+
+	// fine black and white stripes give colour artefacts
+	stripes := flatImage(16, 1, color.RGBA{0, 0, 0, 255})
+	for x := 0; x < 16; x += 2 {
+		stripes.SetRGBA(x, 0, color.RGBA{255, 255, 255, 255})
+	}
+	fringed := applyNTSC(stripes, false).(*image.RGBA)
+	colored := false
+	for x := 0; x < 16; x++ {
+		p := fringed.RGBAAt(x, 0)
+		if p.R != p.G || p.G != p.B {
+			colored = true
+		}
+	}
+	assert.True(t, colored)
+}
+
+func TestFinishImage_Filters(t *testing.T) {
+	result, err := DecodeScreen5(screen5(), decoders.Config{Scale: 2, Filters: []string{FilterNTSC, FilterCRT}})
+	assert.NoError(t, err)
+	img := decodePNG(t, result)
+	assert.Equal(t, image.Rect(0, 0, ScreenWidth*2, ScreenHeight*2), img.Bounds())
+}
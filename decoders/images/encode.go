@@ -0,0 +1,306 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // register GIF input for the encoders
+	_ "image/jpeg" // register JPEG input for the encoders
+	"msxconverter/decoders"
+	"sort"
+)
+
+// The encoders match colours against the DAC levels of the colour profile
+// of the configuration, so decoding and encoding with the same profile keeps
+// the colours.
+
+// EncodeScreen5 encodes a PC image to a screen 5 BSAVE file with palette.
+func EncodeScreen5(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	return encodeScreenNibbles(data, ScreenWidth, PaletteOffset5, ".SC5", config)
+}
+
+// EncodeScreen7 encodes a PC image to a screen 7 BSAVE file with palette.
+func EncodeScreen7(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	return encodeScreenNibbles(data, ScreenWidth7, PaletteOffset, ".SC7", config)
+}
+
+// EncodeScreen8 encodes a PC image to a screen 8 BSAVE file.
+func EncodeScreen8(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	img, err := decodeInputImage(data)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	pixels := make([]byte, ScreenWidth*ScreenHeight)
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			r, g, b := rgbAt(img, x, y)
+			pixels[y*ScreenWidth+x] = nearestLevel(g, profile.Levels3[:])<<5 |
+				nearestLevel(r, profile.Levels3[:])<<2 |
+				nearestLevel(b, profile.Levels2[:])
+		}
+	}
+
+	return bsaveResult(0, pixels, ".SC8"), nil
+}
+
+// EncodeScreen12 encodes a PC image to a screen 12 (YJK) BSAVE file. Each
+// group of 4 pixels shares the J and K chroma values.
+func EncodeScreen12(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	img, err := decodeInputImage(data)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	pixels := make([]byte, ScreenWidth*ScreenHeight)
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x += 4 {
+			var ys [4]int
+			j, k := 0, 0
+			for i := 0; i < 4; i++ {
+				r, g, b := rgbAt(img, x+i, y)
+				r5 := int(nearestLevel(r, profile.Levels5[:]))
+				g5 := int(nearestLevel(g, profile.Levels5[:]))
+				b5 := int(nearestLevel(b, profile.Levels5[:]))
+				ys[i] = clamp(b5/2+r5/4+g5/8, 0, 31)
+				j += r5 - ys[i]
+				k += g5 - ys[i]
+			}
+			j = clamp(j/4, -32, 31)
+			k = clamp(k/4, -32, 31)
+
+			idx := y*ScreenWidth + x
+			pixels[idx+0] = byte(ys[0]<<3) | byte(k&7)
+			pixels[idx+1] = byte(ys[1]<<3) | byte((k>>3)&7)
+			pixels[idx+2] = byte(ys[2]<<3) | byte(j&7)
+			pixels[idx+3] = byte(ys[3]<<3) | byte((j>>3)&7)
+		}
+	}
+
+	return bsaveResult(0, pixels, ".S12"), nil
+}
+
+// encodeScreenNibbles encodes an image to 16 colours, 2 pixels per byte, and
+// stores the palette at its VRAM location.
+func encodeScreenNibbles(data []byte, width, paletteOffset int, extension string, config decoders.Config) (decoders.DecoderResult, error) {
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	img, err := decodeInputImage(data)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	// indexed images with up to 16 colours keep their palette and indices
+	paletted, ok := img.(*image.Paletted)
+	if ok && len(paletted.Palette) > 16 {
+		ok = false
+	}
+	var palette color.Palette
+	if ok {
+		palette = paletted.Palette
+	} else {
+		palette = quantize(img, width, ScreenHeight, 16, profile.Levels3[:])
+	}
+
+	vram := make([]byte, paletteOffset+32)
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < width; x += 2 {
+			var left, right int
+			if ok {
+				left, right = indexAt(paletted, x, y), indexAt(paletted, x+1, y)
+			} else {
+				left = palette.Index(rgbaAt(img, x, y))
+				right = palette.Index(rgbaAt(img, x+1, y))
+			}
+			vram[y*width/2+x/2] = byte(left<<4 | right)
+		}
+	}
+	copy(vram[paletteOffset:], encodePaletteLevels(palette, profile.Levels3[:]))
+
+	return bsaveResult(0, vram, extension), nil
+}
+
+// decodeInputImage decodes a PNG, GIF or JPEG image.
+func decodeInputImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding input image: %v", err)
+	}
+	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return nil, errors.New("empty input image")
+	}
+	return img, nil
+}
+
+// rgbaAt returns the colour of a pixel relative to the image origin; pixels
+// outside the image are black.
+func rgbaAt(img image.Image, x, y int) color.Color {
+	bounds := img.Bounds()
+	if x >= bounds.Dx() || y >= bounds.Dy() {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return img.At(bounds.Min.X+x, bounds.Min.Y+y)
+}
+
+// indexAt returns the colour index of a pixel relative to the image origin;
+// pixels outside the image use colour 0.
+func indexAt(img *image.Paletted, x, y int) int {
+	bounds := img.Bounds()
+	if x >= bounds.Dx() || y >= bounds.Dy() {
+		return 0
+	}
+	return int(img.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y))
+}
+
+func rgbAt(img image.Image, x, y int) (uint8, uint8, uint8) {
+	r, g, b, _ := rgbaAt(img, x, y).RGBA()
+	return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+}
+
+// nearestLevel returns the index of the lookup table entry closest to value.
+func nearestLevel(value uint8, levels []uint8) byte {
+	best := 0
+	for i, level := range levels {
+		if absDiff(value, level) < absDiff(value, levels[best]) {
+			best = i
+		}
+	}
+	return byte(best)
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// quantize reduces the image to the given number of colours of the 9-bit
+// MSX palette with the given DAC levels, using median cut on the colour
+// histogram.
+func quantize(img image.Image, width, height, colors int, levels []uint8) color.Palette {
+	histogram := map[uint16]int{}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b := rgbAt(img, x, y)
+			key := uint16(nearestLevel(r, levels))<<6 |
+				uint16(nearestLevel(g, levels))<<3 |
+				uint16(nearestLevel(b, levels))
+			histogram[key]++
+		}
+	}
+
+	boxes := [][]uint16{make([]uint16, 0, len(histogram))}
+	for key := range histogram {
+		boxes[0] = append(boxes[0], key)
+	}
+	sort.Slice(boxes[0], func(i, j int) bool { return boxes[0][i] < boxes[0][j] })
+
+	for len(boxes) < colors {
+		// split the box with the widest channel range
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for channel := 0; channel < 3; channel++ {
+				low, high := 7, 0
+				for _, key := range box {
+					value := int(key>>(6-3*channel)) & 7
+					low, high = min(low, value), max(high, value)
+				}
+				if high-low > bestRange || best == -1 {
+					best, bestChannel, bestRange = i, channel, high-low
+				}
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		box := boxes[best]
+		shift := 6 - 3*bestChannel
+		sort.Slice(box, func(i, j int) bool { return box[i]>>shift&7 < box[j]>>shift&7 })
+
+		// split at the weighted median
+		total := 0
+		for _, key := range box {
+			total += histogram[key]
+		}
+		split, count := 1, 0
+		for i, key := range box[:len(box)-1] {
+			count += histogram[key]
+			split = i + 1
+			if count*2 >= total {
+				break
+			}
+		}
+		boxes = append(boxes, box[split:])
+		boxes[best] = box[:split]
+	}
+
+	palette := make(color.Palette, 0, colors)
+	for _, box := range boxes {
+		var r, g, b, weight int
+		for _, key := range box {
+			w := histogram[key]
+			r += int(key>>6&7) * w
+			g += int(key>>3&7) * w
+			b += int(key&7) * w
+			weight += w
+		}
+		if weight == 0 {
+			continue
+		}
+		palette = append(palette, color.RGBA{
+			R: levels[(r+weight/2)/weight],
+			G: levels[(g+weight/2)/weight],
+			B: levels[(b+weight/2)/weight],
+			A: 255,
+		})
+	}
+	for len(palette) < colors {
+		palette = append(palette, color.RGBA{0, 0, 0, 255})
+	}
+	return palette
+}
+
+// encodePaletteLevels converts a palette to the 32-byte V9938 palette
+// format with the nearest of the given DAC levels, the reverse of getPalette.
+func encodePaletteLevels(palette color.Palette, levels []uint8) []byte {
+	data := make([]byte, 32)
+	for i := 0; i < 16 && i < len(palette); i++ {
+		// unpremultiplied, so a transparent colour 0 keeps its colour
+		c := color.NRGBAModel.Convert(palette[i]).(color.NRGBA)
+		raw := uint16(nearestLevel(c.R, levels))<<4 |
+			uint16(nearestLevel(c.G, levels))<<8 |
+			uint16(nearestLevel(c.B, levels))
+		binary.LittleEndian.PutUint16(data[i*2:], raw)
+	}
+	return data
+}
+
+// bsaveResult wraps data in a BSAVE header.
+func bsaveResult(begin int, data []byte, extension string) decoders.DecoderResult {
+	header := make([]byte, 7)
+	header[0] = 0xFE
+	binary.LittleEndian.PutUint16(header[1:3], uint16(begin))
+	binary.LittleEndian.PutUint16(header[3:5], uint16(begin+len(data)-1))
+
+	buffer := bytes.NewBuffer(header)
+	buffer.Write(data)
+	return decoders.DecoderResult{Buffer: buffer, Extension: extension}
+}
@@ -0,0 +1,213 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Font export formats, selected with the output format.
+const (
+	FontBDF = "bdf" // X11 bitmap distribution format
+	FontPSF = "psf" // PC Screen Font 2, for the Linux console
+)
+
+// FontSize is the size of a font: 256 patterns of 8x8 pixels.
+const FontSize = 256 * 8
+
+// glyphs per row of a glyph sheet
+const fontColumns = 16
+
+// cgtabl is the address of the font address in the MSX BIOS.
+const cgtabl = 0x0004
+
+// msxUnicode maps the international MSX character set to Unicode. Codes 0x80
+// to 0xAF are the same as in code page 437; the graphic characters are left
+// out. The Japanese character set differs above 0x7F.
+var msxUnicode = func() map[byte]rune {
+	mapping := map[byte]rune{}
+	for c := byte(0x20); c < 0x7F; c++ {
+		mapping[c] = rune(c)
+	}
+	code := byte(0x80)
+	for _, r := range "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»" {
+		mapping[code] = r
+		code++
+	}
+	return mapping
+}()
+
+// FontFormats returns the formats a font can be exported to, besides images.
+func FontFormats() []string {
+	return []string{FontBDF, FontPSF}
+}
+
+// IsFontFormat tells whether name is a font export format.
+func IsFontFormat(name string) bool {
+	name = strings.ToLower(name)
+	return name == FontBDF || name == FontPSF
+}
+
+// FontPatterns returns the 2 KB pattern table of a font file, which is a
+// raw table, a BSAVE file or an MSX BIOS ROM, and tells where it was found.
+func FontPatterns(data []byte) (patterns []byte, source string, warnings []string, err error) {
+	switch {
+	case len(data) >= 7+FontSize && data[0] == 0xFE:
+		begin := binary.LittleEndian.Uint16(data[1:3])
+		return data[7 : 7+FontSize], fmt.Sprintf("BSAVE file at &H%04X", begin), nil, nil
+	case len(data) >= 0x4000 && data[0] == 0xF3 && data[1] == 0xC3:
+		// the BIOS starts with DI and JP and points to its font at CGTABL
+		address := int(binary.LittleEndian.Uint16(data[cgtabl:]))
+		if address+FontSize > len(data) {
+			return nil, "", nil, fmt.Errorf("BIOS font address &H%04X is outside the ROM", address)
+		}
+		return data[address : address+FontSize], fmt.Sprintf("MSX BIOS at &H%04X", address), nil, nil
+	case len(data) < FontSize:
+		return nil, "", nil, fmt.Errorf("a font has %d bytes, got %d", FontSize, len(data))
+	case len(data) > FontSize:
+		warnings = append(warnings, fmt.Sprintf("ignored %d bytes after the font", len(data)-FontSize))
+	}
+	return data[:FontSize], "raw pattern table", warnings, nil
+}
+
+// DecodeFont renders a font as a sheet of 16x16 glyphs, or exports it as a
+// BDF or PSF font.
+func DecodeFont(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	patterns, _, warnings, err := FontPatterns(data)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	var result decoders.DecoderResult
+	switch strings.ToLower(config.OutputFormat) {
+	case FontBDF:
+		result = decoders.DecoderResult{Text: exportBDF(patterns, fontName(config.InputFileName)), IsText: true, Extension: ".bdf"}
+	case FontPSF:
+		result = decoders.DecoderResult{Buffer: bytes.NewBuffer(exportPSF(patterns)), Extension: ".psf"}
+	default:
+		result, err = finishImage(fontSheet(patterns), config, false, AspectNone)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.Warnings = append(result.Warnings, warnings...)
+	return result, nil
+}
+
+// fontName returns the font name for a file name.
+func fontName(fileName string) string {
+	name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if name == "" || name == "." {
+		return "msx"
+	}
+	return name
+}
+
+// fontSheet draws the glyphs white on black, 16 per row, without spacing so
+// that the sheet can be encoded back.
+func fontSheet(patterns []byte) *image.Paletted {
+	palette := color.Palette{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}}
+	sheet := image.NewPaletted(image.Rect(0, 0, fontColumns*8, 256/fontColumns*8), palette)
+	for code := 0; code < 256; code++ {
+		left, top := code%fontColumns*8, code/fontColumns*8
+		for y := 0; y < 8; y++ {
+			row := patterns[code*8+y]
+			for x := 0; x < 8; x++ {
+				if row&(0x80>>x) != 0 {
+					sheet.SetColorIndex(left+x, top+y, 1)
+				}
+			}
+		}
+	}
+	return sheet
+}
+
+// exportBDF writes a BDF font. Glyphs with a Unicode mapping get that code
+// point, the others a code point in the private use area at U+F000.
+func exportBDF(patterns []byte, name string) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "STARTFONT 2.1\n")
+	fmt.Fprintf(&out, "FONT -MSX-%s-Medium-R-Normal--8-80-75-75-C-80-ISO10646-1\n", name)
+	fmt.Fprintf(&out, "SIZE 8 75 75\n")
+	fmt.Fprintf(&out, "FONTBOUNDINGBOX 8 8 0 -1\n")
+	fmt.Fprintf(&out, "STARTPROPERTIES 3\nFONT_ASCENT 7\nFONT_DESCENT 1\nDEFAULT_CHAR %d\nENDPROPERTIES\n", 0xF000)
+	fmt.Fprintf(&out, "CHARS 256\n")
+	for code := 0; code < 256; code++ {
+		encoding, ok := msxUnicode[byte(code)]
+		if !ok {
+			encoding = 0xF000 + rune(code)
+		}
+		fmt.Fprintf(&out, "STARTCHAR msx%02X\nENCODING %d\nSWIDTH 1000 0\nDWIDTH 8 0\nBBX 8 8 0 -1\nBITMAP\n", code, encoding)
+		for _, row := range patterns[code*8 : code*8+8] {
+			fmt.Fprintf(&out, "%02X\n", row)
+		}
+		out.WriteString("ENDCHAR\n")
+	}
+	out.WriteString("ENDFONT\n")
+	return out.String()
+}
+
+// exportPSF writes a PSF2 font with a Unicode table, so the console shows
+// the mapped characters.
+func exportPSF(patterns []byte) []byte {
+	header := []uint32{
+		0x864AB572, // magic
+		0,          // version
+		32,         // header size
+		1,          // flags: has a Unicode table
+		256,        // glyphs
+		8,          // bytes per glyph
+		8,          // height
+		8,          // width
+	}
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, header)
+	out.Write(patterns)
+	for code := 0; code < 256; code++ {
+		if r, ok := msxUnicode[byte(code)]; ok {
+			out.Write(utf8.AppendRune(nil, r))
+		}
+		out.WriteByte(0xFF)
+	}
+	return out.Bytes()
+}
+
+// EncodeFont encodes a glyph sheet of 16x16 glyphs, as written by DecodeFont
+// and possibly scaled by an integer factor, to a 2 KB font. Light, opaque
+// pixels are set.
+func EncodeFont(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	img, err := decodeInputImage(data)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	bounds := img.Bounds()
+	size := fontColumns * 8
+	if bounds.Dx() != bounds.Dy() || bounds.Dx()%size != 0 {
+		return decoders.DecoderResult{}, fmt.Errorf("a glyph sheet has %dx%d pixels or a multiple, got %dx%d",
+			size, size, bounds.Dx(), bounds.Dy())
+	}
+	scale := bounds.Dx() / size
+
+	patterns := make([]byte, FontSize)
+	for code := 0; code < 256; code++ {
+		left, top := code%fontColumns*8, code/fontColumns*8
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				// sample the middle of each scaled pixel
+				px := bounds.Min.X + (left+x)*scale + scale/2
+				py := bounds.Min.Y + (top+y)*scale + scale/2
+				r, g, b, a := img.At(px, py).RGBA()
+				if a >= 0x8000 && 299*r+587*g+114*b >= 1000*0x8000 {
+					patterns[code*8+y] |= 0x80 >> x
+				}
+			}
+		}
+	}
+	return decoders.DecoderResult{Buffer: bytes.NewBuffer(patterns), Extension: ".FNT"}, nil
+}
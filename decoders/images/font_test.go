@@ -0,0 +1,107 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"msxconverter/decoders"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFont returns a font where every glyph has its code as top row and a
+// diagonal line below it.
+func testFont() []byte {
+	font := make([]byte, FontSize)
+	for code := 0; code < 256; code++ {
+		font[code*8] = byte(code)
+		for y := 1; y < 8; y++ {
+			font[code*8+y] = 0x80 >> y
+		}
+	}
+	return font
+}
+
+func TestDecodeFont_Sheet(t *testing.T) {
+	result, err := DecodeFont(testFont(), decoders.Config{})
+	assert.NoError(t, err)
+	img := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, image.Rect(0, 0, 128, 128), img.Bounds())
+
+	// glyph 0x41 is in column 1 of row 4
+	assert.Equal(t, uint8(1), img.ColorIndexAt(8+1, 32))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(8+2, 32))
+	assert.Equal(t, uint8(1), img.ColorIndexAt(8+7, 32))
+	assert.Equal(t, uint8(1), img.ColorIndexAt(8+3, 32+3))
+}
+
+func TestFont_RoundTrip(t *testing.T) {
+	for _, scale := range []int{1, 3} {
+		result, err := DecodeFont(testFont(), decoders.Config{Scale: scale})
+		assert.NoError(t, err)
+		encoded, err := EncodeFont(result.Buffer.Bytes(), decoders.Config{})
+		assert.NoError(t, err)
+		assert.Equal(t, testFont(), encoded.Buffer.Bytes())
+	}
+
+	var sheet bytes.Buffer
+	assert.NoError(t, png.Encode(&sheet, image.NewGray(image.Rect(0, 0, 100, 100))))
+	_, err := EncodeFont(sheet.Bytes(), decoders.Config{})
+	assert.Error(t, err)
+}
+
+func TestFontPatterns(t *testing.T) {
+	font := testFont()
+
+	bsave := bsaveResult(0x9000, font, ".FNT").Buffer.Bytes()
+	patterns, source, _, err := FontPatterns(bsave)
+	assert.NoError(t, err)
+	assert.Equal(t, font, patterns)
+	assert.Equal(t, "BSAVE file at &H9000", source)
+
+	bios := make([]byte, 0x8000)
+	bios[0], bios[1] = 0xF3, 0xC3
+	binary.LittleEndian.PutUint16(bios[cgtabl:], 0x1BBF)
+	copy(bios[0x1BBF:], font)
+	patterns, source, _, err = FontPatterns(bios)
+	assert.NoError(t, err)
+	assert.Equal(t, font, patterns)
+	assert.Equal(t, "MSX BIOS at &H1BBF", source)
+
+	_, _, warnings, err := FontPatterns(append(font, 0, 0))
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+
+	_, _, _, err = FontPatterns(font[:100])
+	assert.Error(t, err)
+}
+
+func TestDecodeFont_BDF(t *testing.T) {
+	result, err := DecodeFont(testFont(), decoders.Config{OutputFormat: FontBDF, InputFileName: "dir/MSX.FNT"})
+	assert.NoError(t, err)
+	assert.True(t, result.IsText)
+	assert.True(t, strings.HasPrefix(result.Text, "STARTFONT 2.1\nFONT -MSX-MSX-"))
+	assert.Contains(t, result.Text, "STARTCHAR msx41\nENCODING 65\n")
+	assert.Contains(t, result.Text, "STARTCHAR msx80\nENCODING 199\n") // Ç
+	assert.Contains(t, result.Text, "STARTCHAR msx01\nENCODING 61441\n")
+	assert.Contains(t, result.Text, "BITMAP\n41\n40\n20\n")
+	assert.Equal(t, 256, strings.Count(result.Text, "ENDCHAR"))
+}
+
+func TestDecodeFont_PSF(t *testing.T) {
+	result, err := DecodeFont(testFont(), decoders.Config{OutputFormat: FontPSF})
+	assert.NoError(t, err)
+	data := result.Buffer.Bytes()
+	assert.Equal(t, []byte{0x72, 0xB5, 0x4A, 0x86}, data[:4])
+	assert.Equal(t, uint32(256), binary.LittleEndian.Uint32(data[16:]))
+	assert.Equal(t, testFont(), data[32:32+FontSize])
+
+	// glyph 0 has no mapping, glyph 0x20 is a space
+	table := data[32+FontSize:]
+	assert.Equal(t, byte(0xFF), table[0])
+	assert.Equal(t, 256, bytes.Count(table, []byte{0xFF}))
+	assert.Equal(t, []byte{0x20, 0xFF}, table[0x20:0x22])
+}
@@ -0,0 +1,119 @@
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"msxconverter/decoders"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// DefaultJPEGQuality is used when no JPEG quality is configured.
+const DefaultJPEGQuality = 90
+
+// imageEncoder writes decoded images in a PC image format.
+type imageEncoder struct {
+	extension string
+	encode    func(w io.Writer, img image.Image, config decoders.Config) error
+}
+
+var imageEncoders = map[string]imageEncoder{
+	"png": {".png", func(w io.Writer, img image.Image, config decoders.Config) error {
+		return png.Encode(w, img)
+	}},
+	"gif": {".gif", encodeGIF},
+	"bmp": {".bmp", func(w io.Writer, img image.Image, config decoders.Config) error {
+		return bmp.Encode(w, img)
+	}},
+	"tiff": {".tiff", func(w io.Writer, img image.Image, config decoders.Config) error {
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	}},
+	"jpg": {".jpg", encodeJPEG},
+	"webp": {".webp", func(w io.Writer, img image.Image, config decoders.Config) error {
+		return encodeWebPLossless(w, img)
+	}},
+	"rgba": {".rgba", encodeRawRGBA},
+}
+
+// aliases of the output format names
+var imageFormatAliases = map[string]string{
+	"jpeg": "jpg",
+	"tif":  "tiff",
+	"raw":  "rgba",
+}
+
+// OutputFormats returns the supported image output formats.
+func OutputFormats() []string {
+	return []string{"png", "gif", "bmp", "tiff", "jpg", "webp", "rgba"}
+}
+
+// IsOutputFormat tells whether name is a supported image output format.
+func IsOutputFormat(name string) bool {
+	_, ok := lookupEncoder(name)
+	return ok
+}
+
+func lookupEncoder(name string) (imageEncoder, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := imageFormatAliases[name]; ok {
+		name = alias
+	}
+	if name == "" {
+		name = "png"
+	}
+	encoder, ok := imageEncoders[name]
+	return encoder, ok
+}
+
+// encodeImage writes the image in the format set in config.OutputFormat,
+// PNG by default.
+func encodeImage(img image.Image, config decoders.Config) (decoders.DecoderResult, error) {
+	encoder, ok := lookupEncoder(config.OutputFormat)
+	if !ok {
+		return decoders.DecoderResult{}, fmt.Errorf("unsupported output format: %s", config.OutputFormat)
+	}
+
+	var buffer bytes.Buffer
+	if err := encoder.encode(&buffer, img, config); err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	return decoders.DecoderResult{
+		Buffer:    &buffer,
+		IsText:    false,
+		Extension: encoder.extension,
+	}, nil
+}
+
+// encodeGIF keeps the palette of paletted images, other images are reduced
+// to 256 colours.
+func encodeGIF(w io.Writer, img image.Image, config decoders.Config) error {
+	if paletted, ok := img.(*image.Paletted); ok && len(paletted.Palette) <= 256 {
+		return gif.Encode(w, paletted, nil)
+	}
+	return gif.Encode(w, img, &gif.Options{NumColors: 256, Drawer: draw.FloydSteinberg})
+}
+
+func encodeJPEG(w io.Writer, img image.Image, config decoders.Config) error {
+	quality := config.Quality
+	if quality <= 0 {
+		quality = DefaultJPEGQuality
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: min(quality, 100)})
+}
+
+// encodeRawRGBA writes 4 bytes per pixel, row by row, without a header.
+func encodeRawRGBA(w io.Writer, img image.Image, config decoders.Config) error {
+	bounds := img.Bounds()
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	_, err := w.Write(rgba.Pix)
+	return err
+}
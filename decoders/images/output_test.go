@@ -0,0 +1,86 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 11))
+	for y := 0; y < 11; y++ {
+		for x := 0; x < 37; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 7), uint8(y * 23), uint8(x ^ y), uint8(255 - x)})
+		}
+	}
+	return img
+}
+
+func TestEncodeImage_Extensions(t *testing.T) {
+	for format, extension := range map[string]string{
+		"":     ".png",
+		"png":  ".png",
+		"GIF":  ".gif",
+		"bmp":  ".bmp",
+		"tif":  ".tiff",
+		"jpeg": ".jpg",
+		"webp": ".webp",
+		"raw":  ".rgba",
+	} {
+		result, err := encodeImage(testImage(), decoders.Config{OutputFormat: format})
+		assert.NoError(t, err, format)
+		assert.Equal(t, extension, result.Extension, format)
+		assert.NotZero(t, result.Buffer.Len(), format)
+	}
+
+	_, err := encodeImage(testImage(), decoders.Config{OutputFormat: "xyz"})
+	assert.Error(t, err)
+}
+
+func TestEncodeWebPLossless_RoundTrip(t *testing.T) {
+	solid := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range solid.Pix {
+		solid.Pix[i] = []byte{1, 2, 3, 255}[i%4]
+	}
+
+	for _, img := range []image.Image{testImage(), solid} {
+		var buffer bytes.Buffer
+		assert.NoError(t, encodeWebPLossless(&buffer, img))
+
+		decoded, err := webp.Decode(&buffer)
+		assert.NoError(t, err)
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+				assert.Equal(t, color.NRGBAModel.Convert(img.At(x, y)), color.NRGBAModel.Convert(decoded.At(x, y)))
+			}
+		}
+	}
+}
+
+func TestEncodeGIF_KeepsPalette(t *testing.T) {
+	palette := color.Palette{color.RGBA{0, 0, 0, 255}, color.RGBA{0xDB, 0x24, 0x92, 255}}
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+	img.SetColorIndex(1, 1, 1)
+
+	result, err := encodeImage(img, decoders.Config{OutputFormat: "gif"})
+	assert.NoError(t, err)
+
+	decoded, err := gif.Decode(result.Buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, palette, decoded.(*image.Paletted).Palette)
+	assert.Equal(t, uint8(1), decoded.(*image.Paletted).ColorIndexAt(1, 1))
+}
+
+func TestEncodeRawRGBA(t *testing.T) {
+	img := testImage()
+	result, err := encodeImage(img, decoders.Config{OutputFormat: "rgba"})
+	assert.NoError(t, err)
+	assert.Equal(t, img.Pix, result.Buffer.Bytes())
+}
@@ -0,0 +1,222 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PC palette formats.
+const (
+	PaletteGIMP = "gpl" // GIMP palette
+	PaletteJASC = "pal" // JASC (Paint Shop Pro) palette
+	PaletteACT  = "act" // Adobe colour table
+	PaletteHex  = "hex" // one #RRGGBB colour per line
+)
+
+// MSXPaletteSize is the size of a V9938 palette: 16 colours of 2 bytes.
+const MSXPaletteSize = 32
+
+// actSize is an Adobe colour table of 256 colours with the colour count and
+// transparent index.
+const actSize = 256*3 + 4
+
+// PaletteFormats returns the supported PC palette formats.
+func PaletteFormats() []string {
+	return []string{PaletteGIMP, PaletteJASC, PaletteACT, PaletteHex}
+}
+
+// PaletteFormatOf returns the PC palette format of a file from its name, or
+// an empty string for other files. A .PAL file without JASC header is taken
+// to be an MSX palette.
+func PaletteFormatOf(data []byte, fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".gpl":
+		return PaletteGIMP
+	case ".pal":
+		if !bytes.HasPrefix(data, []byte("JASC-PAL")) {
+			return ""
+		}
+		return PaletteJASC
+	case ".act":
+		return PaletteACT
+	case ".hex", ".txt":
+		return PaletteHex
+	}
+	return ""
+}
+
+// ScreenPalette returns the palette stored at paletteOffset in a screen
+// BSAVE file.
+func ScreenPalette(data []byte, paletteOffset int) ([]byte, error) {
+	if len(data) < 7 || data[0] != 0xFE {
+		return nil, errors.New("not a BSAVE file")
+	}
+	begin := int(binary.LittleEndian.Uint16(data[1:3]))
+	end := int(binary.LittleEndian.Uint16(data[3:5]))
+	start := 7 + paletteOffset - begin
+	if end < paletteOffset+MSXPaletteSize-1 || start < 7 || start+MSXPaletteSize > len(data) {
+		return nil, errors.New("file contains no palette")
+	}
+	return data[start : start+MSXPaletteSize], nil
+}
+
+// ExportPalette writes a 32-byte MSX palette in a PC palette format, with the
+// colours of the profile.
+func ExportPalette(msxPalette []byte, format, name string, profile *ColorProfile) ([]byte, error) {
+	if len(msxPalette) < MSXPaletteSize {
+		return nil, fmt.Errorf("palette needs %d bytes, got %d", MSXPaletteSize, len(msxPalette))
+	}
+	palette := getPalette(msxPalette, 0, profile)
+
+	var out bytes.Buffer
+	switch format {
+	case PaletteGIMP:
+		fmt.Fprintf(&out, "GIMP Palette\nName: %s\nColumns: 16\n#\n", name)
+		for i, c := range palette {
+			r, g, b := rgb(c)
+			fmt.Fprintf(&out, "%3d %3d %3d\tColor %d\n", r, g, b, i)
+		}
+	case PaletteJASC:
+		fmt.Fprintf(&out, "JASC-PAL\r\n0100\r\n%d\r\n", len(palette))
+		for _, c := range palette {
+			r, g, b := rgb(c)
+			fmt.Fprintf(&out, "%d %d %d\r\n", r, g, b)
+		}
+	case PaletteACT:
+		table := make([]byte, actSize)
+		for i, c := range palette {
+			table[i*3], table[i*3+1], table[i*3+2] = rgb(c)
+		}
+		binary.BigEndian.PutUint16(table[768:], uint16(len(palette)))
+		binary.BigEndian.PutUint16(table[770:], 0xFFFF) // no transparent colour
+		out.Write(table)
+	case PaletteHex:
+		for _, c := range palette {
+			r, g, b := rgb(c)
+			fmt.Fprintf(&out, "#%02X%02X%02X\n", r, g, b)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported palette format: %s", format)
+	}
+	return out.Bytes(), nil
+}
+
+// ImportPalette reads a PC palette and converts its first 16 colours to a
+// 32-byte MSX palette, using the nearest levels of the profile.
+func ImportPalette(data []byte, format string, profile *ColorProfile) ([]byte, error) {
+	var palette color.Palette
+	var err error
+	switch format {
+	case PaletteGIMP:
+		palette, err = parseTextPalette(data, "GIMP Palette", 0, false)
+	case PaletteJASC:
+		palette, err = parseTextPalette(data, "JASC-PAL", 2, false)
+	case PaletteHex:
+		palette, err = parseTextPalette(data, "", 0, true)
+	case PaletteACT:
+		palette, err = parseACT(data)
+	default:
+		return nil, fmt.Errorf("unsupported palette format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(palette) == 0 {
+		return nil, errors.New("palette contains no colours")
+	}
+	if len(palette) > 16 {
+		palette = palette[:16]
+	}
+	return encodePaletteLevels(palette, profile.Levels3[:]), nil
+}
+
+// PaletteData returns the 32-byte MSX palette of a palette file: PC palette
+// formats are imported, other files are used as they are.
+func PaletteData(data []byte, fileName string, profile *ColorProfile) ([]byte, error) {
+	format := PaletteFormatOf(data, fileName)
+	if format == "" {
+		return data, nil
+	}
+	return ImportPalette(data, format, profile)
+}
+
+// parseTextPalette reads one colour per line after the header line and the
+// given number of extra header lines. Lines starting with # are comments;
+// in hex palettes only when the # is not followed by a hex colour.
+func parseTextPalette(data []byte, header string, skip int, hex bool) (color.Palette, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := skip
+	if header != "" {
+		line++
+		if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != header {
+			return nil, fmt.Errorf("missing %s header", header)
+		}
+	}
+	for i := 0; i < skip; i++ {
+		scanner.Scan() // JASC version and colour count
+	}
+
+	var palette color.Palette
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || (!hex && (strings.HasPrefix(text, "#") || strings.Contains(text, ":"))) {
+			continue // GIMP Name: and Columns: lines
+		}
+
+		if hex {
+			digits, comment := strings.CutPrefix(strings.Fields(text)[0], "#")
+			if comment && (digits == "" || strings.Trim(digits, "0123456789ABCDEFabcdef") != "") {
+				continue
+			}
+			value, err := strconv.ParseUint(digits, 16, 32)
+			if err != nil || value > 0xFFFFFF {
+				return nil, fmt.Errorf("line %d: invalid colour %q", line, text)
+			}
+			palette = append(palette, color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 255})
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: invalid colour %q", line, text)
+		}
+		var levels [3]uint8
+		for i := range levels {
+			value, err := strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid colour %q", line, text)
+			}
+			levels[i] = uint8(value)
+		}
+		palette = append(palette, color.RGBA{levels[0], levels[1], levels[2], 255})
+	}
+	return palette, scanner.Err()
+}
+
+func parseACT(data []byte) (color.Palette, error) {
+	if len(data) != 768 && len(data) != actSize {
+		return nil, fmt.Errorf("an ACT file has 768 or %d bytes, got %d", actSize, len(data))
+	}
+	count := 256
+	if len(data) == actSize {
+		count = clamp(int(binary.BigEndian.Uint16(data[768:])), 1, 256)
+	}
+	palette := make(color.Palette, count)
+	for i := range palette {
+		palette[i] = color.RGBA{data[i*3], data[i*3+1], data[i*3+2], 255}
+	}
+	return palette, nil
+}
+
+func rgb(c color.Color) (uint8, uint8, uint8) {
+	r, g, b, _ := c.RGBA()
+	return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+}
@@ -0,0 +1,80 @@
+package images
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPalette_RoundTrip(t *testing.T) {
+	for _, format := range PaletteFormats() {
+		exported, err := ExportPalette(defaultPalette, format, "test", &linearProfile)
+		assert.NoError(t, err, format)
+
+		imported, err := ImportPalette(exported, format, &linearProfile)
+		assert.NoError(t, err, format)
+		assert.Equal(t, defaultPalette, imported, format)
+	}
+}
+
+func TestExportPalette_Formats(t *testing.T) {
+	gpl, _ := ExportPalette(defaultPalette, PaletteGIMP, "title", &linearProfile)
+	assert.Contains(t, string(gpl), "GIMP Palette\nName: title\n")
+	assert.Contains(t, string(gpl), "255 255 255\tColor 15\n")
+
+	jasc, _ := ExportPalette(defaultPalette, PaletteJASC, "", &linearProfile)
+	assert.Contains(t, string(jasc), "JASC-PAL\r\n0100\r\n16\r\n0 0 0\r\n")
+
+	act, _ := ExportPalette(defaultPalette, PaletteACT, "", &linearProfile)
+	assert.Len(t, act, 772)
+	assert.Equal(t, []byte{0, 16, 0xFF, 0xFF}, act[768:])
+
+	hex, _ := ExportPalette(defaultPalette, PaletteHex, "", &linearProfile)
+	assert.Contains(t, string(hex), "#24DB24\n")
+
+	_, err := ExportPalette(defaultPalette[:10], PaletteHex, "", &linearProfile)
+	assert.Error(t, err)
+}
+
+func TestImportPalette_Errors(t *testing.T) {
+	_, err := ImportPalette([]byte("JASC-PAL\n0100\n1\n1 2\n"), PaletteJASC, &linearProfile)
+	assert.Error(t, err)
+	_, err = ImportPalette([]byte("not a palette"), PaletteGIMP, &linearProfile)
+	assert.Error(t, err)
+	_, err = ImportPalette(make([]byte, 100), PaletteACT, &linearProfile)
+	assert.Error(t, err)
+}
+
+func TestScreenPalette(t *testing.T) {
+	data := screen5()
+	palette, err := ScreenPalette(data, PaletteOffset5)
+	assert.NoError(t, err)
+	assert.Equal(t, data[7+PaletteOffset5:7+PaletteOffset5+32], palette)
+
+	_, err = ScreenPalette(bsaveResult(0, make([]byte, 0x6A00), ".SC5").Buffer.Bytes(), PaletteOffset5)
+	assert.Error(t, err)
+}
+
+func TestPaletteData(t *testing.T) {
+	raw := make([]byte, 32)
+	data, err := PaletteData(raw, "GAME.PAL", &linearProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, data)
+
+	data, err = PaletteData([]byte("#FFFFFF\n"), "colours.hex", &linearProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x77, 0x07}, data[:2])
+
+	data, err = PaletteData([]byte("# white\n#Palette\nFFFFFF\n"), "colours.hex", &linearProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x77, 0x07}, data[:2])
+
+	_, err = PaletteData([]byte("#1FFFFFF\n"), "colours.hex", &linearProfile)
+	assert.Error(t, err, "colour larger than FFFFFF")
+}
+
+func TestPaletteFormatOf(t *testing.T) {
+	assert.Equal(t, PaletteJASC, PaletteFormatOf([]byte("JASC-PAL\r\n0100\r\n"), "GAME.PAL"))
+	assert.Equal(t, "", PaletteFormatOf(make([]byte, 32), "GAME.PAL"))
+	assert.Equal(t, PaletteGIMP, PaletteFormatOf(nil, "colours.gpl"))
+}
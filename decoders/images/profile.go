@@ -0,0 +1,123 @@
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"msxconverter/decoders"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ColorProfile maps the DAC levels of the VDP to RGB values. Levels3 is used
+// for the 9-bit palette and the red and green of screen 8, Levels2 for the
+// blue of screen 8 and Levels5 for the YJK modes. Fixed replaces the default
+// palette with the 16 fixed colours of a TMS9918 when set.
+type ColorProfile struct {
+	Name    string     `json:"name"`
+	Levels3 [8]uint8   `json:"levels3"`
+	Levels2 [4]uint8   `json:"levels2"`
+	Levels5 [32]uint8  `json:"levels5"`
+	Fixed   [][3]uint8 `json:"fixed,omitempty"`
+}
+
+// DefaultColorProfile is used when no profile is configured.
+const DefaultColorProfile = "linear"
+
+// linearProfile uses linear ramps.
+var linearProfile = ColorProfile{
+	Name:    "linear",
+	Levels3: color3bitsLookupTable,
+	Levels2: color2bitsLookupTable,
+	Levels5: color5bitsLookupTable,
+}
+
+// The built-in profiles; measured DAC curves of a machine or the tables of
+// an emulator can be loaded from a profile file.
+var colorProfiles = map[string]*ColorProfile{
+	"linear": &linearProfile,
+	// TMS9918A (NTSC) colours, as commonly used by MSX1 emulators
+	"tms9918-ntsc": tmsProfile("tms9918-ntsc", [][3]uint8{
+		{0, 0, 0}, {0, 0, 0}, {33, 200, 66}, {94, 220, 120},
+		{84, 85, 237}, {125, 118, 252}, {212, 82, 77}, {66, 235, 245},
+		{252, 85, 84}, {255, 121, 120}, {212, 193, 84}, {230, 206, 128},
+		{33, 176, 59}, {201, 91, 186}, {204, 204, 204}, {255, 255, 255},
+	}),
+	// TMS9929A (PAL) colours, computed from the Y, R-Y and B-Y levels of
+	// the datasheet
+	"tms9929-pal": tmsProfile("tms9929-pal", [][3]uint8{
+		{0, 0, 0}, {0, 0, 0}, {0, 232, 13}, {64, 243, 80},
+		{77, 68, 255}, {121, 102, 255}, {249, 69, 43}, {18, 252, 255},
+		{255, 69, 45}, {255, 105, 80}, {222, 194, 51}, {240, 198, 141},
+		{0, 200, 9}, {228, 70, 226}, {204, 204, 204}, {255, 255, 255},
+	}),
+}
+
+func tmsProfile(name string, fixed [][3]uint8) *ColorProfile {
+	profile := linearProfile
+	profile.Name = name
+	profile.Fixed = fixed
+	return &profile
+}
+
+// ColorProfiles returns the names of the built-in profiles.
+func ColorProfiles() []string {
+	names := make([]string, 0, len(colorProfiles))
+	for name := range colorProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var loadedProfiles sync.Map
+
+// LoadColorProfile returns a built-in profile, or reads a profile from a
+// JSON file. Levels missing from the file are taken from the linear profile.
+// Loaded files are cached, as batch conversions decode many files.
+func LoadColorProfile(name string) (*ColorProfile, error) {
+	if name == "" {
+		name = DefaultColorProfile
+	}
+	if profile, ok := colorProfiles[name]; ok {
+		return profile, nil
+	}
+	if profile, ok := loadedProfiles.Load(name); ok {
+		return profile.(*ColorProfile), nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown colour profile %s: %v", name, err)
+	}
+	profile := linearProfile
+	profile.Name = name
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("error reading colour profile %s: %v", name, err)
+	}
+	if profile.Fixed != nil && len(profile.Fixed) != 16 {
+		return nil, fmt.Errorf("colour profile %s: fixed needs 16 colours, got %d", name, len(profile.Fixed))
+	}
+
+	loadedProfiles.Store(name, &profile)
+	return &profile, nil
+}
+
+// configProfile returns the colour profile of the configuration.
+func configProfile(config decoders.Config) (*ColorProfile, error) {
+	return LoadColorProfile(config.ColorProfile)
+}
+
+// defaultPalette returns the palette of images without one: the fixed
+// TMS9918 colours, or the power-on palette of the V9938.
+func (p *ColorProfile) defaultPalette() color.Palette {
+	if p.Fixed == nil {
+		return getPalette(defaultPalette, 0, p)
+	}
+	palette := make(color.Palette, len(p.Fixed))
+	for i, c := range p.Fixed {
+		palette[i] = color.RGBA{R: c[0], G: c[1], B: c[2], A: 255}
+	}
+	return palette
+}
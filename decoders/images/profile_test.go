@@ -0,0 +1,94 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadColorProfile_BuiltIn(t *testing.T) {
+	for _, name := range ColorProfiles() {
+		profile, err := LoadColorProfile(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, uint8(0), profile.Levels3[0], name)
+		assert.Equal(t, uint8(255), profile.Levels3[7], name)
+		assert.Equal(t, uint8(255), profile.Levels2[3], name)
+		assert.Equal(t, uint8(255), profile.Levels5[31], name)
+	}
+
+	profile, err := LoadColorProfile("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultColorProfile, profile.Name)
+
+	_, err = LoadColorProfile("no-such-profile")
+	assert.Error(t, err)
+}
+
+func TestLoadColorProfile_File(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "tv.json")
+	assert.NoError(t, os.WriteFile(name, []byte(`{"levels3": [0, 10, 20, 30, 40, 50, 60, 70]}`), 0o644))
+
+	profile, err := LoadColorProfile(name)
+	assert.NoError(t, err)
+	assert.Equal(t, [8]uint8{0, 10, 20, 30, 40, 50, 60, 70}, profile.Levels3)
+	assert.Equal(t, linearProfile.Levels5, profile.Levels5)
+
+	bad := filepath.Join(dir, "bad.json")
+	assert.NoError(t, os.WriteFile(bad, []byte(`{"fixed": [[1, 2, 3]]}`), 0o644))
+	_, err = LoadColorProfile(bad)
+	assert.Error(t, err)
+}
+
+func TestDecodeScreen5_TMSDefaultPalette(t *testing.T) {
+	// a screen without palette uses the fixed TMS9918 colours
+	vram := make([]byte, ScreenWidth/2*ScreenHeight)
+	vram[0] = 0x20
+	data := bsaveResult(0, vram, ".SC5").Buffer.Bytes()
+
+	result, err := DecodeScreen5(data, decoders.Config{ColorProfile: "tms9918-ntsc"})
+	assert.NoError(t, err)
+	img := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, color.RGBA{33, 200, 66, 255}, color.RGBAModel.Convert(img.At(0, 0)))
+
+	_, err = DecodeScreen5(data, decoders.Config{ColorProfile: "no-such-profile"})
+	assert.Error(t, err)
+}
+
+func TestEncode_Profile(t *testing.T) {
+	// decoding and encoding with the same non-linear profile keeps the colours
+	name := filepath.Join(t.TempDir(), "dark.json")
+	assert.NoError(t, os.WriteFile(name, []byte(`{"levels3": [0, 8, 20, 40, 70, 110, 170, 255], "levels2": [0, 40, 120, 255]}`), 0o644))
+	config := decoders.Config{ColorProfile: name}
+
+	vram := make([]byte, ScreenWidth*ScreenHeight)
+	for i := range vram {
+		vram[i] = byte(i)
+	}
+	data := bsaveResult(0, vram, ".SC8").Buffer.Bytes()
+	decoded, err := DecodeScreen8(data, config)
+	assert.NoError(t, err)
+	encoded, err := EncodeScreen8(decoded.Buffer.Bytes(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded.Buffer.Bytes())
+
+	vram = make([]byte, PaletteOffset5+MSXPaletteSize)
+	for i := 0; i < ScreenWidth/2*ScreenHeight; i++ {
+		vram[i] = byte(i)
+	}
+	for i := 0; i < 16; i++ {
+		vram[PaletteOffset5+2*i] = byte(i%8<<4 | (7 - i%8))
+		vram[PaletteOffset5+2*i+1] = byte(i / 2)
+	}
+	data = bsaveResult(0, vram, ".SC5").Buffer.Bytes()
+	decoded, err = DecodeScreen5(data, config)
+	assert.NoError(t, err)
+	encoded, err = EncodeScreen5(decoded.Buffer.Bytes(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded.Buffer.Bytes())
+}
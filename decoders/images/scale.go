@@ -0,0 +1,278 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"msxconverter/decoders"
+)
+
+// Aspect modes of the scaled output.
+const (
+	AspectAuto   = ""       // square pixels for STP stamps, none for the rest
+	AspectNone   = "none"   // every MSX pixel becomes a square pixel
+	AspectNTSC   = "ntsc"   // pixel aspect of a 60 Hz MSX on a TV
+	AspectPAL    = "pal"    // pixel aspect of a 50 Hz MSX on a TV
+	AspectSquare = "square" // 512 pixel wide modes get doubled lines
+)
+
+// Scalers used to enlarge the image.
+const (
+	ScalerNearest  = "nearest"
+	ScalerBilinear = "bilinear"
+	ScalerScale2x  = "scale2x" // Scale2x/Scale3x pixel art upscaler
+)
+
+// Width of a screen 5/8 pixel divided by its height on a TV, from the ratio
+// of the square pixel clock to the 5.37 MHz VDP pixel clock.
+const (
+	ntscPixelAspect = 6.136 / 5.369
+	palPixelAspect  = 7.375 / 5.369
+)
+
+// AspectModes returns the supported aspect modes.
+func AspectModes() []string {
+	return []string{AspectNone, AspectNTSC, AspectPAL, AspectSquare}
+}
+
+// Scalers returns the supported scalers.
+func Scalers() []string {
+	return []string{ScalerNearest, ScalerBilinear, ScalerScale2x}
+}
+
+// finishImage scales and filters the decoded image and encodes it in the
+// output format. It is called by every image decoder; wide tells whether the
+// image comes from a 512 pixel wide mode, which has pixels half as wide, and
+// defaultAspect is used when no aspect mode is configured.
+func finishImage(img image.Image, config decoders.Config, wide bool, defaultAspect string) (decoders.DecoderResult, error) {
+	filters, ntsc := expandFilters(config.Filters)
+	if ntsc {
+		img = applyNTSC(img, wide)
+	}
+	scaled := scaleImage(img, config, wide, defaultAspect)
+	lineHeight := float64(scaled.Bounds().Dy()) / float64(img.Bounds().Dy())
+	return encodeImage(applyFilters(scaled, filters, lineHeight), config)
+}
+
+// scaleImage applies the scale factor and aspect mode of the configuration.
+func scaleImage(img image.Image, config decoders.Config, wide bool, defaultAspect string) image.Image {
+	aspect := config.Aspect
+	if aspect == AspectAuto {
+		aspect = defaultAspect
+	}
+	scale := float64(max(config.Scale, 1))
+
+	pixelAspect := 1.0
+	switch aspect {
+	case AspectNTSC:
+		pixelAspect = ntscPixelAspect
+	case AspectPAL:
+		pixelAspect = palPixelAspect
+	}
+	if wide && aspect != AspectNone {
+		pixelAspect /= 2
+	}
+
+	// correct the aspect by enlarging one of the axes
+	scaleX, scaleY := scale, scale
+	if pixelAspect > 1 {
+		scaleX *= pixelAspect
+	} else {
+		scaleY /= pixelAspect
+	}
+
+	bounds := img.Bounds()
+	width := int(math.Round(float64(bounds.Dx()) * scaleX))
+	height := int(math.Round(float64(bounds.Dy()) * scaleY))
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+	return resize(img, width, height, config.Scaler)
+}
+
+// resize scales the image to the given size. Paletted images stay paletted
+// unless the bilinear scaler is used.
+func resize(img image.Image, width, height int, scaler string) image.Image {
+	switch scaler {
+	case ScalerBilinear:
+		return resizeBilinear(img, width, height)
+	case ScalerScale2x:
+		bounds := img.Bounds()
+		factor := min(width/bounds.Dx(), height/bounds.Dy())
+		for factor > 1 {
+			n := 2
+			if factor%3 == 0 {
+				n = 3
+			} else if factor%2 != 0 {
+				break
+			}
+			img = scaleNx(img, n)
+			factor /= n
+		}
+		if img.Bounds().Dx() == width && img.Bounds().Dy() == height {
+			return img
+		}
+	}
+	return resizeNearest(img, width, height)
+}
+
+func resizeNearest(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	if paletted, ok := img.(*image.Paletted); ok {
+		resized := image.NewPaletted(image.Rect(0, 0, width, height), paletted.Palette)
+		for y := 0; y < height; y++ {
+			sy := bounds.Min.Y + y*bounds.Dy()/height
+			for x := 0; x < width; x++ {
+				resized.SetColorIndex(x, y, paletted.ColorIndexAt(bounds.Min.X+x*bounds.Dx()/width, sy))
+			}
+		}
+		return resized
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			resized.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/width, sy))
+		}
+	}
+	return resized
+}
+
+func resizeBilinear(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	sample := func(x, y int) [4]float64 {
+		x = clamp(x, 0, bounds.Dx()-1)
+		y = clamp(y, 0, bounds.Dy()-1)
+		r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+		return [4]float64{float64(r), float64(g), float64(b), float64(a)}
+	}
+
+	for y := 0; y < height; y++ {
+		// sample at the pixel centres
+		fy := (float64(y)+0.5)*float64(bounds.Dy())/float64(height) - 0.5
+		y0 := int(math.Floor(fy))
+		wy := fy - float64(y0)
+		for x := 0; x < width; x++ {
+			fx := (float64(x)+0.5)*float64(bounds.Dx())/float64(width) - 0.5
+			x0 := int(math.Floor(fx))
+			wx := fx - float64(x0)
+
+			c00, c10 := sample(x0, y0), sample(x0+1, y0)
+			c01, c11 := sample(x0, y0+1), sample(x0+1, y0+1)
+			var c [4]uint16
+			for i := range c {
+				top := c00[i]*(1-wx) + c10[i]*wx
+				bottom := c01[i]*(1-wx) + c11[i]*wx
+				c[i] = uint16(math.Round(top*(1-wy) + bottom*wy))
+			}
+			resized.Set(x, y, color.RGBA64{c[0], c[1], c[2], c[3]})
+		}
+	}
+	return resized
+}
+
+// scaleNx enlarges the image 2 or 3 times with the Scale2x/Scale3x (AdvMAME)
+// rules, which round diagonal edges without adding colours.
+func scaleNx(img image.Image, n int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// compare palette indices or colours
+	var pixel func(x, y int) uint32
+	paletted, isPaletted := img.(*image.Paletted)
+	if isPaletted {
+		pixel = func(x, y int) uint32 {
+			return uint32(paletted.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	} else {
+		pixels := make([]uint32, width*height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+				key := uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+				pixels[y*width+x] = key
+			}
+		}
+		pixel = func(x, y int) uint32 { return pixels[y*width+x] }
+	}
+	at := func(x, y int) uint32 {
+		return pixel(clamp(x, 0, width-1), clamp(y, 0, height-1))
+	}
+
+	out := make([]uint32, width*n*height*n)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a, b, c := at(x-1, y-1), at(x, y-1), at(x+1, y-1)
+			d, e, f := at(x-1, y), at(x, y), at(x+1, y)
+			g, h, i := at(x-1, y+1), at(x, y+1), at(x+1, y+1)
+
+			block := make([]uint32, n*n)
+			for k := range block {
+				block[k] = e
+			}
+			if b != h && d != f {
+				if n == 2 {
+					if d == b {
+						block[0] = d
+					}
+					if b == f {
+						block[1] = f
+					}
+					if d == h {
+						block[2] = d
+					}
+					if h == f {
+						block[3] = f
+					}
+				} else {
+					if d == b {
+						block[0] = d
+					}
+					if (d == b && e != c) || (b == f && e != a) {
+						block[1] = b
+					}
+					if b == f {
+						block[2] = f
+					}
+					if (d == b && e != g) || (d == h && e != a) {
+						block[3] = d
+					}
+					if (b == f && e != i) || (h == f && e != c) {
+						block[5] = f
+					}
+					if d == h {
+						block[6] = d
+					}
+					if (d == h && e != i) || (h == f && e != g) {
+						block[7] = h
+					}
+					if h == f {
+						block[8] = f
+					}
+				}
+			}
+			for k, value := range block {
+				out[(y*n+k/n)*width*n+x*n+k%n] = value
+			}
+		}
+	}
+
+	rect := image.Rect(0, 0, width*n, height*n)
+	if isPaletted {
+		scaled := image.NewPaletted(rect, paletted.Palette)
+		for k, value := range out {
+			scaled.Pix[k] = uint8(value)
+		}
+		return scaled
+	}
+	scaled := image.NewNRGBA(rect)
+	for k, value := range out {
+		scaled.Pix[k*4+0] = uint8(value >> 24)
+		scaled.Pix[k*4+1] = uint8(value >> 16)
+		scaled.Pix[k*4+2] = uint8(value >> 8)
+		scaled.Pix[k*4+3] = uint8(value)
+	}
+	return scaled
+}
@@ -0,0 +1,95 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func palettedTestImage(width, height int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, width, height), linearProfile.defaultPalette())
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x > y {
+				img.SetColorIndex(x, y, 15)
+			}
+		}
+	}
+	return img
+}
+
+func TestScaleImage_Sizes(t *testing.T) {
+	tests := []struct {
+		config              decoders.Config
+		wide                bool
+		defaultAspect       string
+		width, height       int
+		outWidth, outHeight int
+	}{
+		{decoders.Config{}, false, AspectNone, 256, 212, 256, 212},
+		{decoders.Config{Scale: 3}, false, AspectNone, 256, 212, 768, 636},
+		{decoders.Config{Aspect: AspectSquare}, false, AspectNone, 256, 212, 256, 212},
+		{decoders.Config{Aspect: AspectSquare}, true, AspectNone, 512, 212, 512, 424},
+		{decoders.Config{Aspect: AspectNTSC}, false, AspectNone, 256, 212, 293, 212},
+		{decoders.Config{Aspect: AspectPAL, Scale: 2}, false, AspectNone, 256, 212, 703, 424},
+		{decoders.Config{Aspect: AspectNTSC}, true, AspectNone, 512, 212, 512, 371},
+		{decoders.Config{}, true, AspectSquare, 100, 50, 100, 100},
+		{decoders.Config{Aspect: AspectNone}, true, AspectSquare, 100, 50, 100, 50},
+	}
+
+	for _, test := range tests {
+		for _, scaler := range Scalers() {
+			test.config.Scaler = scaler
+			scaled := scaleImage(palettedTestImage(test.width, test.height), test.config, test.wide, test.defaultAspect)
+			assert.Equal(t, image.Rect(0, 0, test.outWidth, test.outHeight), scaled.Bounds(), "%+v", test.config)
+		}
+	}
+}
+
+func TestScaleImage_KeepsPalette(t *testing.T) {
+	for _, scaler := range []string{ScalerNearest, ScalerScale2x} {
+		src := palettedTestImage(8, 8)
+		scaled, ok := scaleImage(src, decoders.Config{Scale: 6, Scaler: scaler}, false, AspectNone).(*image.Paletted)
+		assert.True(t, ok, scaler)
+		assert.Equal(t, src.Palette, scaled.Palette, scaler)
+	}
+
+	_, ok := scaleImage(palettedTestImage(8, 8), decoders.Config{Scale: 2, Scaler: ScalerBilinear}, false, AspectNone).(*image.Paletted)
+	assert.False(t, ok)
+}
+
+func TestScaleNx_Diagonal(t *testing.T) {
+	// a diagonal edge is smoothed, flat areas stay flat
+	img := palettedTestImage(4, 4)
+	scaled := scaleNx(img, 2).(*image.Paletted)
+	assert.Equal(t, uint8(15), scaled.ColorIndexAt(3, 2))
+	assert.Equal(t, uint8(0), scaled.ColorIndexAt(2, 3))
+	assert.Equal(t, uint8(0), scaled.ColorIndexAt(0, 7))
+	assert.Equal(t, uint8(15), scaled.ColorIndexAt(7, 0))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			rgba.Set(x, y, img.At(x, y))
+		}
+	}
+	scaledRGBA := scaleNx(rgba, 3)
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			expected := color.RGBAModel.Convert(img.Palette[scaleNx(img, 3).(*image.Paletted).ColorIndexAt(x, y)])
+			assert.Equal(t, expected, color.RGBAModel.Convert(scaledRGBA.At(x, y)))
+		}
+	}
+}
+
+func TestResizeBilinear_Flat(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	for i := range src.Pix {
+		src.Pix[i] = []byte{10, 20, 30, 255}[i%4]
+	}
+	resized := resizeBilinear(src, 7, 5)
+	assert.Equal(t, color.RGBA{10, 20, 30, 255}, resized.At(6, 4))
+}
@@ -7,111 +7,92 @@ import (
 	"msxconverter/decoders"
 )
 
-// decodeScreenNibbles decodes screen data with nibbles to an image.
-func decodeScreenNibbles(data []byte, config decoders.Config, width, paletteOffset int) (decoders.DecoderResult, error) {
-	beginAddress := binary.LittleEndian.Uint16(data[1:3])
-	endAddress := binary.LittleEndian.Uint16(data[3:5])
-	height := calculateHeight(endAddress, width/2)
-
-	// Skip the file header and read palette
-	pixels := data[7:]
-	var palette color.Palette
-	if endAddress >= uint16(paletteOffset) {
-		palette = getPalette(pixels, paletteOffset-int(beginAddress))
-	} else if config.ExtraData != nil {
-		palette = getPalette(config.ExtraData, 0)
-	} else {
-		palette = getPalette(defaultPalette, 0)
-	}
-
-	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
-	if uint16(height*(width/2)) < endAddress {
-		endAddress = uint16(height * (width / 2))
-	}
-
-	for addr := beginAddress; addr < endAddress; addr++ {
-		y := int(addr / uint16(width/2))
-		x := int(addr % uint16(width/2))
-		byteVal := pixels[addr-beginAddress]
-
-		img.SetColorIndex(x*2, y, byteVal>>4)
-		img.SetColorIndex(x*2+1, y, byteVal&0x0F)
-	}
-
-	var outputImage image.Image
-	if config.DoubleImageSize {
-		outputImage = doubleSizePaletted(width, height, img)
-	} else {
-		outputImage = img
-	}
-	return encodePNG(outputImage)
+// bitmapView maps the visible pixels of a bitmap mode to VRAM addresses,
+// taking the page and the scroll registers into account.
+type bitmapView struct {
+	vram     vram
+	page     int // address of the page shown
+	pageSize int
+	width    int
+	height   int
+	bits     int  // bits per pixel
+	scrollX  int  // pixels, from R#26 and R#27
+	scrollY  int  // lines, from R#23
+	twoPages bool // R#25 SP2: scroll over two pages side by side
 }
 
-// decodeScreen decodes screen data to an image.
-func decodeScreen(data []byte, config decoders.Config, width int) (decoders.DecoderResult, error) {
-	beginAddress := binary.LittleEndian.Uint16(data[1:3])
+// bsaveView returns the view of the screen in a BSAVE file.
+func bsaveView(data []byte, width, bits int) bitmapView {
 	endAddress := binary.LittleEndian.Uint16(data[3:5])
-	height := calculateHeight(endAddress, width)
-
-	// Skip the file header and read pixels
-	pixels := data[7:]
+	height := calculateHeight(endAddress, width*bits/8)
+	return bitmapView{vram: bsaveVRAM(data), width: width, height: height, bits: bits}
+}
 
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	if uint16(height*width) < endAddress {
-		endAddress = uint16(height * width)
+// pixel returns the value of a visible pixel. Lines wrap around within the
+// 256 lines of a page.
+func (b bitmapView) pixel(x, y int) byte {
+	line := (y + b.scrollY) & 0xFF
+	x += b.scrollX
+	page := b.page
+	if b.twoPages {
+		x &= 2*b.width - 1
+		page = b.page&^b.pageSize + x/b.width*b.pageSize
+		x %= b.width
+	} else {
+		x &= b.width - 1
 	}
+	bit := x * b.bits
+	value := b.vram.at(page + line*b.width*b.bits/8 + bit/8)
+	return value >> (8 - b.bits - bit%8) & (1<<b.bits - 1)
+}
 
-	for addr := beginAddress; addr < endAddress; addr++ {
-		y := int(addr / uint16(width))
-		x := int(addr % uint16(width))
-		byteVal := pixels[addr-beginAddress]
-
-		r := color3bitsLookupTable[(byteVal>>2)&0b111]
-		g := color3bitsLookupTable[(byteVal>>5)&0b111]
-		b := color2bitsLookupTable[byteVal&0b11]
-
-		img.Set(x, y, color.RGBA{R: r, G: g, B: b, A: 255})
+// vramPalette reads the 32-byte palette at address.
+func vramPalette(v vram, address int, profile *ColorProfile) color.Palette {
+	data := make([]byte, MSXPaletteSize)
+	for i := range data {
+		data[i] = v.at(address + i)
 	}
+	return getPalette(data, 0, profile)
+}
 
-	var outputImage image.Image
-	if config.DoubleImageSize {
-		outputImage = doubleSize(width, height, img)
-	} else {
-		outputImage = img
+// bsavePalette returns the palette stored in a BSAVE file, the separate
+// palette, or the default palette.
+func bsavePalette(data []byte, config decoders.Config, paletteOffset int, profile *ColorProfile) color.Palette {
+	endAddress := int(binary.LittleEndian.Uint16(data[3:5]))
+	switch {
+	case endAddress >= paletteOffset:
+		return vramPalette(bsaveVRAM(data), paletteOffset, profile)
+	case config.ExtraData != nil:
+		return getPalette(config.ExtraData, 0, profile)
 	}
-	return encodePNG(outputImage)
+	return profile.defaultPalette()
 }
 
-// decodeYaeYjk decodes YAE or YJK encoded data to an image.
-func decodeYaeYjk(data []byte, config decoders.Config, width, paletteOffset int, isYae bool) (decoders.DecoderResult, error) {
-	beginAddress := binary.LittleEndian.Uint16(data[1:3])
-	endAddress := binary.LittleEndian.Uint16(data[3:5])
-	height := calculateHeight(endAddress, width)
-
-	pixels := data[7:]
-	var palette color.Palette
-	if paletteOffset > 0 {
-		if endAddress >= uint16(paletteOffset) {
-			palette = getPalette(pixels, paletteOffset-int(beginAddress))
-		} else if config.ExtraData != nil {
-			palette = getPalette(config.ExtraData, 0)
-		} else {
-			palette = getPalette(defaultPalette, 0)
+// renderPaletted draws a bitmap mode with palette indices as pixels.
+func renderPaletted(view bitmapView, palette color.Palette) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, view.width, view.height), palette)
+	for y := 0; y < view.height; y++ {
+		for x := 0; x < view.width; x++ {
+			img.SetColorIndex(x, y, view.pixel(x, y))
 		}
 	}
+	return img
+}
 
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+// renderYJK draws a YJK mode, where 4 pixels share their colour. In YAE
+// mode pixels with an odd Y value show palette colour Y/2.
+func renderYJK(view bitmapView, profile *ColorProfile, palette color.Palette, isYae bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, view.width, view.height))
 
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x += 4 {
-			idx := y*width + x
-			b1 := pixels[idx+0]
-			b2 := pixels[idx+1]
-			b3 := pixels[idx+2]
-			b4 := pixels[idx+3]
+	for y := 0; y < view.height; y++ {
+		for x := 0; x < view.width; x += 4 {
+			var pixels [4]byte
+			for i := range pixels {
+				pixels[i] = view.pixel(x+i, y)
+			}
 
-			k := int(b1&7 + (b2&7)<<3)
-			j := int(b3&7 + (b4&7)<<3)
+			k := int(pixels[0]&7 + (pixels[1]&7)<<3)
+			j := int(pixels[2]&7 + (pixels[3]&7)<<3)
 
 			if k > 31 {
 				k -= 64
@@ -121,26 +102,68 @@ func decodeYaeYjk(data []byte, config decoders.Config, width, paletteOffset int,
 			}
 
 			for i := 0; i < 4; i++ {
-				yVal := int(pixels[idx+i] >> 3)
+				yVal := int(pixels[i] >> 3)
 				if isYae && (yVal&1) == 1 {
 					img.Set(x+i, y, palette[yVal>>1])
 				} else {
 					r := clamp(yVal+j, 0, 31)
 					g := clamp(yVal+k, 0, 31)
 					b := clamp(5*yVal/4-j/2-k/4, 0, 31)
-					img.Set(x+i, y, color.RGBA{color5bitsLookupTable[r], color5bitsLookupTable[g], color5bitsLookupTable[b], 255})
+					img.Set(x+i, y, color.RGBA{profile.Levels5[r], profile.Levels5[g], profile.Levels5[b], 255})
 				}
 			}
 		}
 	}
+	return img
+}
 
-	var outputImage image.Image
-	if config.DoubleImageSize {
-		outputImage = doubleSize(width, height, img)
-	} else {
-		outputImage = img
+// decodeScreenNibbles decodes screen data with nibbles to an image.
+func decodeScreenNibbles(data []byte, config decoders.Config, width, paletteOffset int) (decoders.DecoderResult, error) {
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	palette := applyColor0(bsavePalette(data, config, paletteOffset, profile), config)
+	img := renderPaletted(bsaveView(data, width, 4), palette)
+
+	if width == ScreenWidth7 {
+		return finishScreen(img, bsaveVRAM(data), config, spriteLayout7, true)
+	}
+	return finishScreen(img, bsaveVRAM(data), config, spriteLayout5, false)
+}
+
+// decodeScreen decodes screen data to an image.
+func decodeScreen(data []byte, config decoders.Config, width int) (decoders.DecoderResult, error) {
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	img := renderPaletted(bsaveView(data, width, 8), applyColor0(screen8Palette(profile), config))
+
+	layout := spriteLayout7
+	layout.palette = graphic7SpritePalette(profile)
+	return finishScreen(img, bsaveVRAM(data), config, layout, false)
+}
+
+// decodeYaeYjk decodes YAE or YJK encoded data to an image.
+func decodeYaeYjk(data []byte, config decoders.Config, width, paletteOffset int, isYae bool) (decoders.DecoderResult, error) {
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
 	}
-	return encodePNG(outputImage)
+
+	var palette color.Palette
+	if paletteOffset > 0 {
+		palette = bsavePalette(data, config, paletteOffset, profile)
+	}
+	img := renderYJK(bsaveView(data, width, 8), profile, palette, isYae)
+
+	// pictures with few colours are written as indexed images
+	layout := spriteLayout7
+	layout.palette = graphic7SpritePalette(profile)
+	return finishScreen(toPaletted(img), bsaveVRAM(data), config, layout, false)
 }
 
 // DecodeScreen5 decodes screen 5 data.
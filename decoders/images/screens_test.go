@@ -0,0 +1,189 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// screen5 returns a 212 line screen 5 BSAVE file with palette, where colours
+// 1 and 2 are the same.
+func screen5() []byte {
+	vram := make([]byte, PaletteOffset5+32)
+	for i := 0; i < ScreenWidth/2*ScreenHeight; i++ {
+		vram[i] = byte(i%16)<<4 | 2
+	}
+	palette := make([]byte, 32)
+	copy(palette, defaultPalette)
+	copy(palette[4:6], palette[2:4])
+	copy(vram[PaletteOffset5:], palette)
+	return bsaveResult(0, vram, ".SC5").Buffer.Bytes()
+}
+
+func decodePNG(t *testing.T, result decoders.DecoderResult) image.Image {
+	img, err := png.Decode(result.Buffer)
+	assert.NoError(t, err)
+	return img
+}
+
+func TestDecodeScreen5_KeepsIndices(t *testing.T) {
+	for _, scale := range []int{1, 2} {
+		result, err := DecodeScreen5(screen5(), decoders.Config{Scale: scale})
+		assert.NoError(t, err)
+
+		paletted, ok := decodePNG(t, result).(*image.Paletted)
+		assert.True(t, ok)
+		assert.Len(t, paletted.Palette, 16)
+		assert.Equal(t, paletted.Palette[1], paletted.Palette[2])
+
+		assert.Equal(t, uint8(1), paletted.ColorIndexAt(2*scale, 0))
+		assert.Equal(t, uint8(2), paletted.ColorIndexAt(3*scale+scale-1, scale-1))
+	}
+}
+
+func TestDecodeScreen5_Color0(t *testing.T) {
+	result, err := DecodeScreen5(screen5(), decoders.Config{Color0: Color0Transparent})
+	assert.NoError(t, err)
+
+	paletted := decodePNG(t, result).(*image.Paletted)
+	_, _, _, a := paletted.Palette[0].RGBA()
+	assert.Zero(t, a)
+	_, _, _, a = paletted.Palette[1].RGBA()
+	assert.Equal(t, uint32(0xFFFF), a)
+}
+
+func TestDecodeScreen8_Paletted(t *testing.T) {
+	pixels := make([]byte, ScreenWidth*ScreenHeight)
+	for i := range pixels {
+		pixels[i] = byte(i)
+	}
+	result, err := DecodeScreen8(bsaveResult(0, pixels, ".SC8").Buffer.Bytes(), decoders.Config{})
+	assert.NoError(t, err)
+
+	paletted := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, uint8(0b01001110), paletted.ColorIndexAt(0b01001110, 0))
+	assert.Equal(t, color.RGBA{0x6d, 0x49, 0xaa, 255}, color.RGBAModel.Convert(paletted.At(0b01001110, 0)))
+}
+
+func TestDecodeScreen12_FewColoursPaletted(t *testing.T) {
+	pixels := bytes.Repeat([]byte{0x80}, ScreenWidth*ScreenHeight)
+	result, err := DecodeScreen12(bsaveResult(0, pixels, ".S12").Buffer.Bytes(), decoders.Config{Scale: 2})
+	assert.NoError(t, err)
+
+	paletted, ok := decodePNG(t, result).(*image.Paletted)
+	assert.True(t, ok)
+	assert.Len(t, paletted.Palette, 1)
+	assert.Equal(t, image.Rect(0, 0, ScreenWidth*2, ScreenHeight*2), paletted.Bounds())
+}
+
+func TestEncodeScreen5_PalettedRoundTrip(t *testing.T) {
+	data := screen5()
+	decoded, err := DecodeScreen5(data, decoders.Config{Color0: Color0Transparent})
+	assert.NoError(t, err)
+
+	encoded, err := EncodeScreen5(decoded.Buffer.Bytes(), decoders.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded.Buffer.Bytes())
+}
+
+func TestDecodeScreen5_Backdrop(t *testing.T) {
+	result, err := DecodeScreen5(screen5(), decoders.Config{Color0: "007"})
+	assert.NoError(t, err)
+
+	paletted := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, color.RGBA{0, 0, 0xff, 0xff}, color.RGBAModel.Convert(paletted.Palette[0]))
+	assert.Equal(t, uint8(0), paletted.ColorIndexAt(0, 0))
+}
+
+func TestIsColor0Mode(t *testing.T) {
+	for _, valid := range []string{"", "opaque", "transparent", "000", "707"} {
+		assert.True(t, IsColor0Mode(valid), valid)
+	}
+	for _, invalid := range []string{"backdrop", "08", "800", "7777"} {
+		assert.False(t, IsColor0Mode(invalid), invalid)
+	}
+}
+
+func TestEncodeScreen7_PalettedRoundTrip(t *testing.T) {
+	vram := make([]byte, PaletteOffset+32)
+	for i := 0; i < ScreenWidth7/2*ScreenHeight; i++ {
+		vram[i] = byte(i%16)<<4 | byte(i/256%16)
+	}
+	copy(vram[PaletteOffset:], defaultPalette)
+	data := bsaveResult(0, vram, ".SC7").Buffer.Bytes()
+
+	decoded, err := DecodeScreen7(data, decoders.Config{})
+	assert.NoError(t, err)
+	pngData := decoded.Buffer.Bytes()
+	assert.Equal(t, image.Rect(0, 0, ScreenWidth7, ScreenHeight), decodePNG(t, decoded).Bounds())
+
+	encoded, err := EncodeScreen7(pngData, decoders.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, data, encoded.Buffer.Bytes())
+}
+
+func TestEncodeScreen7_Layout(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, ScreenWidth7, ScreenHeight))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	blue := color.RGBA{0, 0, 0xFF, 0xFF}
+	img.Set(257, 0, red)
+	img.Set(ScreenWidth7-2, ScreenHeight-1, blue)
+	var input bytes.Buffer
+	assert.NoError(t, png.Encode(&input, img))
+
+	encoded, err := EncodeScreen7(input.Bytes(), decoders.Config{})
+	assert.NoError(t, err)
+	decoded, err := DecodeScreen7(encoded.Buffer.Bytes(), decoders.Config{})
+	assert.NoError(t, err)
+
+	result := decodePNG(t, decoded)
+	assert.Equal(t, image.Rect(0, 0, ScreenWidth7, ScreenHeight), result.Bounds())
+	assert.Equal(t, red, color.RGBAModel.Convert(result.At(257, 0)))
+	assert.Equal(t, blue, color.RGBAModel.Convert(result.At(ScreenWidth7-2, ScreenHeight-1)))
+	assert.Equal(t, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, color.RGBAModel.Convert(result.At(256, 0)))
+	assert.Equal(t, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, color.RGBAModel.Convert(result.At(ScreenWidth7-1, ScreenHeight-1)))
+}
+
+// TestEncodeScreen12_RoundTrip encodes groups of 4 pixels with one colour.
+// Red and green are stored exactly; blue is derived from Y, J and K and is
+// off by at most 5 of the 32 levels.
+func TestEncodeScreen12_RoundTrip(t *testing.T) {
+	profile, err := LoadColorProfile("")
+	assert.NoError(t, err)
+	levels := profile.Levels5[:]
+
+	img := image.NewRGBA(image.Rect(0, 0, ScreenWidth, ScreenHeight))
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			group := y*ScreenWidth/4 + x/4
+			img.Set(x, y, color.RGBA{levels[group%32], levels[group/32%32], levels[group/1024%32], 0xFF})
+		}
+	}
+	var input bytes.Buffer
+	assert.NoError(t, png.Encode(&input, img))
+
+	encoded, err := EncodeScreen12(input.Bytes(), decoders.Config{})
+	assert.NoError(t, err)
+	decoded, err := DecodeScreen12(encoded.Buffer.Bytes(), decoders.Config{})
+	assert.NoError(t, err)
+	result := decodePNG(t, decoded)
+	assert.Equal(t, img.Bounds(), result.Bounds())
+
+	for y := 0; y < ScreenHeight; y++ {
+		for x := 0; x < ScreenWidth; x++ {
+			r, g, b := rgbAt(img, x, y)
+			r2, g2, b2 := rgbAt(result, x, y)
+			assert.Equal(t, nearestLevel(r, levels), nearestLevel(r2, levels), "red at %d,%d", x, y)
+			assert.Equal(t, nearestLevel(g, levels), nearestLevel(g2, levels), "green at %d,%d", x, y)
+			assert.LessOrEqual(t, absDiff(nearestLevel(b, levels), nearestLevel(b2, levels)), 5, "blue at %d,%d", x, y)
+		}
+	}
+}
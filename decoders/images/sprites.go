@@ -0,0 +1,294 @@
+package images
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+)
+
+// Sprite output modes.
+const (
+	SpritesSheet   = "sheet"   // all patterns in a grid
+	SpritesOverlay = "overlay" // the screen with the active sprites
+)
+
+// SpriteModes returns the supported sprite output modes.
+func SpriteModes() []string {
+	return []string{SpritesSheet, SpritesOverlay}
+}
+
+// spriteLayout gives the sprite mode and the VRAM addresses of the sprite
+// tables of a screen mode.
+type spriteLayout struct {
+	mode       int // 1 for MSX1 screens, 2 for the V9938 bitmap modes
+	attributes int
+	colors     int // colour table of sprite mode 2, 512 bytes below the attributes
+	patterns   int
+	palette    color.Palette // fixed sprite colours, nil to use the paletted screen image
+	size       int           // 8 or 16 from R#1, 0 to use the configured size
+	magnify    bool          // R#1 MAG: sprite pixels are doubled
+	scroll     int           // vertical scroll of R#23, which also moves the sprites
+}
+
+// sprite tables of the bitmap modes as set up by SCREEN in MSX BASIC
+var (
+	spriteLayout5 = spriteLayout{mode: 2, attributes: 0x7600, colors: 0x7400, patterns: 0x7800}
+	spriteLayout7 = spriteLayout{mode: 2, attributes: 0xFA00, colors: 0xF800, patterns: 0xF000}
+)
+
+// In screen 8 and the YJK modes sprites have 16 fixed colours, given in the
+// 0xGRB format of the palette.
+var graphic7SpriteColors = [16]uint16{
+	0x000, 0x002, 0x030, 0x032, 0x300, 0x302, 0x330, 0x332,
+	0x472, 0x007, 0x070, 0x077, 0x700, 0x707, 0x770, 0x777,
+}
+
+func graphic7SpritePalette(profile *ColorProfile) color.Palette {
+	palette := make(color.Palette, len(graphic7SpriteColors))
+	for i, grb := range graphic7SpriteColors {
+		palette[i] = color.RGBA{
+			R: profile.Levels3[grb>>4&7],
+			G: profile.Levels3[grb>>8&7],
+			B: profile.Levels3[grb&7],
+			A: 255,
+		}
+	}
+	return palette
+}
+
+// sprite attribute values that end the attribute table
+const (
+	spriteEnd1 = 208
+	spriteEnd2 = 216
+)
+
+// vram gives access to the VRAM contents of a BSAVE file; addresses outside
+// the file read as 0.
+type vram struct {
+	data  []byte
+	begin int
+}
+
+func (v vram) at(address int) byte {
+	offset := address - v.begin
+	if offset < 0 || offset >= len(v.data) {
+		return 0
+	}
+	return v.data[offset]
+}
+
+// bsaveVRAM returns the VRAM contents of a BSAVE file.
+func bsaveVRAM(data []byte) vram {
+	begin := int(data[1]) | int(data[2])<<8
+	end := int(data[3]) | int(data[4])<<8
+	contents := data[7:]
+	if size := end - begin + 1; size >= 0 && size < len(contents) {
+		contents = contents[:size]
+	}
+	return vram{data: contents, begin: begin}
+}
+
+// finishScreen renders the sprites when configured, then scales, filters and
+// encodes the image.
+func finishScreen(img image.Image, v vram, config decoders.Config, layout spriteLayout, wide bool) (decoders.DecoderResult, error) {
+	if config.Sprites != "" {
+		var err error
+		if img, err = renderSprites(img, v, layout, config); err != nil {
+			return decoders.DecoderResult{}, err
+		}
+		if config.Sprites == SpritesSheet {
+			wide = false
+		}
+	}
+	return finishImage(img, config, wide, AspectNone)
+}
+
+// renderSprites draws the sprite sheet, or the active sprites over the screen
+// image, as configured.
+func renderSprites(img image.Image, v vram, layout spriteLayout, config decoders.Config) (image.Image, error) {
+	size := layout.size
+	if size == 0 {
+		size = config.SpriteSize
+	}
+	if size == 0 {
+		size = 16
+	}
+	if size != 8 && size != 16 {
+		return nil, errors.New("sprite size must be 8 or 16")
+	}
+
+	switch config.Sprites {
+	case SpritesSheet:
+		return spriteSheet(v, layout, size), nil
+	case SpritesOverlay:
+		return drawSprites(img, v, layout, size, config.Color0 == Color0Opaque), nil
+	}
+	return img, nil
+}
+
+// spriteSheet draws all patterns in white on black, separated by a grey grid.
+func spriteSheet(v vram, layout spriteLayout, size int) image.Image {
+	count, columns := 256, 16
+	if size == 16 {
+		count, columns = 64, 8
+	}
+	cell := size + 1
+	rows := count / columns
+
+	palette := color.Palette{
+		color.RGBA{0, 0, 0, 255},
+		color.RGBA{255, 255, 255, 255},
+		color.RGBA{0x49, 0x49, 0x49, 255},
+	}
+	sheet := image.NewPaletted(image.Rect(0, 0, columns*cell+1, rows*cell+1), palette)
+	for i := range sheet.Pix {
+		sheet.Pix[i] = 2
+	}
+
+	for n := 0; n < count; n++ {
+		left, top := n%columns*cell+1, n/columns*cell+1
+		pattern := n * size * size / 8
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if patternBit(v, layout, pattern, size, x, y) {
+					sheet.SetColorIndex(left+x, top+y, 1)
+				} else {
+					sheet.SetColorIndex(left+x, top+y, 0)
+				}
+			}
+		}
+	}
+	return sheet
+}
+
+// patternBit tells whether a pixel of a pattern is set. A 16x16 pattern is
+// stored as four 8x8 blocks: top left, bottom left, top right, bottom right.
+func patternBit(v vram, layout spriteLayout, pattern, size, x, y int) bool {
+	offset := pattern + y
+	if size == 16 {
+		offset = pattern + x/8*16 + y
+	}
+	return v.at(layout.patterns+offset)&(0x80>>(x%8)) != 0
+}
+
+// drawSprites draws the active sprites over the screen image. Sprites with
+// colour 0 are transparent unless colour0 is set, as with the TP bit. In
+// sprite mode 2 every line has its own colour and CC bit; a sprite with CC
+// set is only shown when a sprite with a lower number and CC reset is on the
+// same line, and their colours are ORed where they overlap. The IC bit only
+// affects collision detection and is ignored.
+func drawSprites(img image.Image, v vram, layout spriteLayout, size int, colour0 bool) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	xScale := max(1, width/256) // 512 pixel wide modes have 2 pixels per sprite pixel
+
+	// without fixed sprite colours the screen and sprites share a palette,
+	// so the image can stay paletted
+	var out func(x, y int, c uint8)
+	if paletted, ok := img.(*image.Paletted); ok && layout.palette == nil {
+		overlay := image.NewPaletted(image.Rect(0, 0, width, height), paletted.Palette)
+		copy(overlay.Pix, paletted.Pix)
+		out = func(x, y int, c uint8) { overlay.SetColorIndex(x, y, c) }
+		img = overlay
+	} else {
+		rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				rgba.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		}
+		out = func(x, y int, c uint8) { rgba.Set(x, y, layout.palette[c]) }
+		img = rgba
+	}
+
+	magnify := 1
+	if layout.magnify {
+		magnify = 2
+	}
+
+	end, perLine := spriteEnd2, 8
+	if layout.mode == 1 {
+		end, perLine = spriteEnd1, 4
+	}
+
+	// the active sprites are those before the end marker
+	var active []int
+	for n := 0; n < 32; n++ {
+		if int(v.at(layout.attributes+n*4)) == end {
+			break
+		}
+		active = append(active, n)
+	}
+
+	for line := 0; line < height; line++ {
+		pixels := make([]int, 256) // colour per pixel, -1 for none
+		groups := make([]int, 256) // the CC=0 sprite a pixel belongs to
+		for x := range pixels {
+			pixels[x] = -1
+		}
+
+		shown, group := 0, -1
+		for _, n := range active {
+			attribute := layout.attributes + n*4
+			// lines wrap around, so sprites can be partly above the screen
+			top := int(v.at(attribute)) + 1
+			row := (line + layout.scroll - top) & 0xFF
+			if row >= size*magnify {
+				continue
+			}
+			row /= magnify
+			shown++
+			if shown > perLine {
+				break // the VDP shows a limited number of sprites per line
+			}
+
+			left := int(v.at(attribute + 1))
+			pattern := int(v.at(attribute + 2))
+			if size == 16 {
+				pattern &= 0xFC
+			}
+			var colorByte byte
+			if layout.mode == 1 {
+				colorByte = v.at(attribute + 3)
+			} else {
+				colorByte = v.at(layout.colors + n*16 + row)
+			}
+			if colorByte&0x80 != 0 {
+				left -= 32 // early clock
+			}
+			colour := int(colorByte & 0x0F)
+
+			cc := layout.mode == 2 && colorByte&0x40 != 0
+			if cc && group < 0 {
+				continue
+			}
+			if !cc {
+				group = n
+			}
+
+			for x := 0; x < size*magnify; x++ {
+				px := left + x
+				if px < 0 || px >= 256 || !patternBit(v, layout, pattern*8, size, x/magnify, row) {
+					continue
+				}
+				switch {
+				case pixels[px] < 0:
+					pixels[px], groups[px] = colour, group
+				case cc && groups[px] == group:
+					pixels[px] |= colour
+				}
+			}
+		}
+
+		for x, c := range pixels {
+			if c < 0 || (c == 0 && !colour0) {
+				continue
+			}
+			for i := 0; i < xScale; i++ {
+				out(x*xScale+i, line, uint8(c))
+			}
+		}
+	}
+	return img
+}
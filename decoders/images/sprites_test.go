@@ -0,0 +1,143 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spriteVRAM returns VRAM with the screen 5 sprite tables, all sprites ended
+// by the end marker, and a solid 16x16 pattern 0.
+func spriteVRAM() []byte {
+	data := make([]byte, 0x8000)
+	data[spriteLayout5.attributes] = spriteEnd2
+	for i := 0; i < 32; i++ {
+		data[spriteLayout5.patterns+i] = 0xFF
+	}
+	return data
+}
+
+// setSprite sets the attributes of sprite n and the colour of all its lines.
+func setSprite(data []byte, n, x, y int, colour byte) {
+	attribute := spriteLayout5.attributes + n*4
+	data[attribute], data[attribute+1], data[attribute+2] = byte(y), byte(x), 0
+	data[attribute+4] = spriteEnd2
+	for row := 0; row < 16; row++ {
+		data[spriteLayout5.colors+n*16+row] = colour
+	}
+}
+
+func blankScreen(width, height int) *image.Paletted {
+	return image.NewPaletted(image.Rect(0, 0, width, height), linearProfile.defaultPalette())
+}
+
+func TestSpriteSheet_Size(t *testing.T) {
+	v := vram{data: spriteVRAM()}
+	assert.Equal(t, image.Rect(0, 0, 8*17+1, 8*17+1), spriteSheet(v, spriteLayout5, 16).Bounds())
+	assert.Equal(t, image.Rect(0, 0, 16*9+1, 16*9+1), spriteSheet(v, spriteLayout5, 8).Bounds())
+
+	sheet := spriteSheet(v, spriteLayout5, 16).(*image.Paletted)
+	assert.Equal(t, uint8(2), sheet.ColorIndexAt(0, 0))
+	assert.Equal(t, uint8(1), sheet.ColorIndexAt(1, 1))
+	assert.Equal(t, uint8(1), sheet.ColorIndexAt(16, 16))
+	assert.Equal(t, uint8(0), sheet.ColorIndexAt(18, 1))
+}
+
+func TestDrawSprites_Position(t *testing.T) {
+	data := spriteVRAM()
+	setSprite(data, 0, 10, 20, 7)
+	img := drawSprites(blankScreen(256, 212), vram{data: data}, spriteLayout5, 16, false).(*image.Paletted)
+
+	// sprites are shown one line below their Y coordinate
+	assert.Equal(t, uint8(0), img.ColorIndexAt(10, 20))
+	assert.Equal(t, uint8(7), img.ColorIndexAt(10, 21))
+	assert.Equal(t, uint8(7), img.ColorIndexAt(25, 36))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(26, 21))
+}
+
+func TestDrawSprites_EndMarker(t *testing.T) {
+	data := spriteVRAM()
+	setSprite(data, 0, 10, 20, 7)
+	setSprite(data, 2, 100, 20, 5)
+	img := drawSprites(blankScreen(256, 212), vram{data: data}, spriteLayout5, 16, false).(*image.Paletted)
+	assert.Equal(t, uint8(7), img.ColorIndexAt(10, 21))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(100, 21))
+}
+
+func TestDrawSprites_EarlyClock(t *testing.T) {
+	data := spriteVRAM()
+	setSprite(data, 0, 40, 0, 0x80|3)
+	img := drawSprites(blankScreen(256, 212), vram{data: data}, spriteLayout5, 16, false).(*image.Paletted)
+	assert.Equal(t, uint8(3), img.ColorIndexAt(8, 1))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(40, 1))
+}
+
+func TestDrawSprites_CC(t *testing.T) {
+	data := spriteVRAM()
+	setSprite(data, 0, 0, 0, 0x04)
+	setSprite(data, 1, 8, 0, 0x40|0x03)
+	img := drawSprites(blankScreen(256, 212), vram{data: data}, spriteLayout5, 16, false).(*image.Paletted)
+	assert.Equal(t, uint8(4), img.ColorIndexAt(0, 1))
+	assert.Equal(t, uint8(7), img.ColorIndexAt(8, 1))  // ORed where they overlap
+	assert.Equal(t, uint8(3), img.ColorIndexAt(20, 1)) // alone, but on a line of sprite 0
+
+	// without a CC=0 sprite on the line a CC sprite is not shown
+	data = spriteVRAM()
+	setSprite(data, 0, 8, 0, 0x40|0x03)
+	img = drawSprites(blankScreen(256, 212), vram{data: data}, spriteLayout5, 16, false).(*image.Paletted)
+	assert.Equal(t, uint8(0), img.ColorIndexAt(8, 1))
+}
+
+func TestDrawSprites_Mode1(t *testing.T) {
+	data := make([]byte, 0x4000)
+	layout := spriteLayout{mode: 1, attributes: 0x1B00, patterns: 0x3800}
+	data[0x3800] = 0x80
+	for n := 0; n < 5; n++ {
+		attribute := layout.attributes + n*4
+		data[attribute], data[attribute+1], data[attribute+3] = 9, byte(n*10), 0x40|byte(n+1)
+	}
+	data[layout.attributes+20] = spriteEnd1
+
+	img := drawSprites(blankScreen(256, 192), vram{data: data}, layout, 8, false).(*image.Paletted)
+	assert.Equal(t, uint8(1), img.ColorIndexAt(0, 10)) // CC is not used in mode 1
+	assert.Equal(t, uint8(4), img.ColorIndexAt(30, 10))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(40, 10)) // fifth sprite on the line
+}
+
+func TestDrawSprites_Wide(t *testing.T) {
+	data := spriteVRAM()
+	setSprite(data, 0, 10, 20, 7)
+	img := drawSprites(blankScreen(512, 212), vram{data: data}, spriteLayout5, 16, false).(*image.Paletted)
+	assert.Equal(t, uint8(7), img.ColorIndexAt(20, 21))
+	assert.Equal(t, uint8(7), img.ColorIndexAt(51, 21))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(52, 21))
+}
+
+func TestDecodeScreen8_SpriteColors(t *testing.T) {
+	vramData := make([]byte, 0xFB00)
+	vramData[spriteLayout7.attributes] = 0
+	vramData[spriteLayout7.attributes+4] = spriteEnd2
+	for i := 0; i < 32; i++ {
+		vramData[spriteLayout7.patterns+i] = 0xFF
+	}
+	for row := 0; row < 16; row++ {
+		vramData[spriteLayout7.colors+row] = 8
+	}
+	data := bsaveResult(0, vramData, ".SC8").Buffer.Bytes()
+
+	result, err := DecodeScreen8(data, decoders.Config{Sprites: SpritesOverlay})
+	assert.NoError(t, err)
+	img := decodePNG(t, result)
+	r, g, b, _ := img.At(0, 1).RGBA()
+	expected := color.RGBA{R: linearProfile.Levels3[7], G: linearProfile.Levels3[4], B: linearProfile.Levels3[2], A: 255}
+	assert.Equal(t, expected, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255})
+}
+
+func TestRenderSprites_Size(t *testing.T) {
+	_, err := renderSprites(blankScreen(256, 212), vram{data: spriteVRAM()}, spriteLayout5,
+		decoders.Config{Sprites: SpritesSheet, SpriteSize: 12})
+	assert.Error(t, err)
+}
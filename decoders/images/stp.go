@@ -46,16 +46,7 @@ func DecodeSTP(data []byte, config decoders.Config) (decoders.DecoderResult, err
 
 	// STP pixels are intended for a 512x212 screen where the horizontal
 	// resolution is twice the vertical one.  To preserve the correct
-	// aspect ratio, each line needs to be duplicated.
-	doubledHeightImg := doubleHeightPaletted(width, height, img)
-
-	var output image.Image
-	if config.DoubleImageSize {
-		// Double both dimensions after the aspect ratio fix.
-		output = doubleSizePaletted(width, height*2, doubledHeightImg)
-	} else {
-		output = doubledHeightImg
-	}
-
-	return encodePNG(output)
+	// aspect ratio, each line is duplicated unless another aspect mode is
+	// chosen.
+	return finishImage(img, config, true, AspectSquare)
 }
@@ -1,12 +1,9 @@
 package images
 
 import (
-	"bytes"
 	"encoding/binary"
 	"image"
 	"image/color"
-	"image/png"
-	"msxconverter/decoders"
 )
 
 // Lookup tables for color conversion
@@ -47,67 +44,53 @@ func clamp(val, min, max int) int {
 	return val
 }
 
-func encodePNG(img image.Image) (decoders.DecoderResult, error) {
-	var buffer bytes.Buffer
-	if err := png.Encode(&buffer, img); err != nil {
-		return decoders.DecoderResult{}, err
-	}
-	return decoders.DecoderResult{
-		Buffer: bytes.NewBuffer(buffer.Bytes()),
-		IsText: false,
-	}, nil
-}
-
-func doubleSize(width, height int, img *image.RGBA) image.Image {
-	doubleImg := image.NewRGBA(image.Rect(0, 0, width*2, height*2))
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := img.At(x, y)
-			doubleImg.Set(x*2, y*2, c)
-			doubleImg.Set(x*2+1, y*2, c)
-			doubleImg.Set(x*2, y*2+1, c)
-			doubleImg.Set(x*2+1, y*2+1, c)
-		}
-	}
-	return doubleImg
-}
-
-func doubleSizePaletted(width, height int, img *image.Paletted) image.Image {
-	doubleImg := image.NewPaletted(image.Rect(0, 0, width*2, height*2), img.Palette)
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := img.At(x, y)
-			doubleImg.Set(x*2, y*2, c)
-			doubleImg.Set(x*2+1, y*2, c)
-			doubleImg.Set(x*2, y*2+1, c)
-			doubleImg.Set(x*2+1, y*2+1, c)
+// toPaletted converts an image with at most 256 colours to a paletted image,
+// with the colours in order of first use. Other images are returned as is.
+func toPaletted(img *image.RGBA) image.Image {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, nil)
+	indices := map[color.RGBA]uint8{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			index, ok := indices[c]
+			if !ok {
+				if len(paletted.Palette) == 256 {
+					return img
+				}
+				index = uint8(len(paletted.Palette))
+				indices[c] = index
+				paletted.Palette = append(paletted.Palette, c)
+			}
+			paletted.SetColorIndex(x, y, index)
 		}
 	}
-	return doubleImg
+	return paletted
 }
 
-// doubleHeightPaletted duplicates each row of a paletted image to correct the
-// aspect ratio for formats that use rectangular pixels.
-func doubleHeightPaletted(width, height int, img *image.Paletted) *image.Paletted {
-	doubleImg := image.NewPaletted(image.Rect(0, 0, width, height*2), img.Palette)
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			c := img.ColorIndexAt(x, y)
-			doubleImg.SetColorIndex(x, y*2, c)
-			doubleImg.SetColorIndex(x, y*2+1, c)
+// screen8Palette returns the fixed 256 colours of screen 8, indexed by the
+// GGGRRRBB pixel byte.
+func screen8Palette(profile *ColorProfile) color.Palette {
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.RGBA{
+			R: profile.Levels3[(i>>2)&0b111],
+			G: profile.Levels3[(i>>5)&0b111],
+			B: profile.Levels2[i&0b11],
+			A: 255,
 		}
 	}
-	return doubleImg
+	return palette
 }
 
-func getPalette(data []byte, paletteOffset int) color.Palette {
+func getPalette(data []byte, paletteOffset int, profile *ColorProfile) color.Palette {
 	paletteData := data[paletteOffset : paletteOffset+32]
 	palette := make(color.Palette, 16)
 	for i := 0; i < 16; i++ {
 		raw := binary.LittleEndian.Uint16(paletteData[i*2 : i*2+2])
-		r := color3bitsLookupTable[(raw>>4)&0b111]
-		g := color3bitsLookupTable[(raw>>8)&0b111]
-		b := color3bitsLookupTable[(raw)&0b111]
+		r := profile.Levels3[(raw>>4)&0b111]
+		g := profile.Levels3[(raw>>8)&0b111]
+		b := profile.Levels3[(raw)&0b111]
 		palette[i] = color.RGBA{R: r, G: g, B: b, A: 255}
 	}
 	return palette
@@ -0,0 +1,411 @@
+package images
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"msxconverter/decoders"
+	"strconv"
+	"strings"
+)
+
+// VRAMSize is the size of the largest VRAM snapshot, the 128 KB of an MSX2.
+// Smaller snapshots, such as the 16 KB of an MSX1, read as 0 above their end.
+const VRAMSize = 0x20000
+
+// VDPRegisterCount is the number of register values in a register file, as
+// saved from the "VDP regs" debuggable of openMSX. It can be followed by the
+// 32 bytes of the "VDP palette" debuggable.
+const VDPRegisterCount = 64
+
+// Display modes, named as in the V9938 datasheet.
+const (
+	modeText1      = "TEXT1"
+	modeText2      = "TEXT2"
+	modeMulticolor = "MULTICOLOR"
+	modeGraphic1   = "GRAPHIC1"
+	modeGraphic2   = "GRAPHIC2"
+	modeGraphic3   = "GRAPHIC3"
+	modeGraphic4   = "GRAPHIC4"
+	modeGraphic5   = "GRAPHIC5"
+	modeGraphic6   = "GRAPHIC6"
+	modeGraphic7   = "GRAPHIC7"
+)
+
+// vdpMode describes a display mode.
+type vdpMode struct {
+	name         string
+	screen       string // SCREEN mode of MSX BASIC
+	width        int
+	bits         int // bits per pixel of the bitmap modes, 0 for pattern modes
+	sprites      int // sprite mode, 0 for none
+	paletteTable int // where MSX BASIC keeps a copy of the palette, 0 for none
+}
+
+// vdpModes are keyed by the mode bits M5 M4 M3 M2 M1.
+var vdpModes = map[int]vdpMode{
+	0b00001: {modeText1, "0 (40 columns)", 240, 0, 0, 0},
+	0b01001: {modeText2, "0 (80 columns)", 480, 0, 0, 0},
+	0b00010: {modeMulticolor, "3", 256, 0, 1, 0},
+	0b00000: {modeGraphic1, "1", 256, 0, 1, 0},
+	0b00100: {modeGraphic2, "2", 256, 0, 1, 0},
+	0b01000: {modeGraphic3, "4", 256, 0, 2, 0},
+	0b01100: {modeGraphic4, "5", ScreenWidth, 4, 2, PaletteOffset5},
+	0b10000: {modeGraphic5, "6", ScreenWidth7, 2, 2, PaletteOffset5},
+	0b10100: {modeGraphic6, "7", ScreenWidth7, 4, 2, PaletteOffset},
+	0b11100: {modeGraphic7, "8", ScreenWidth, 8, 2, PaletteOffset},
+}
+
+// vdpRegisters holds the register values of a VRAM snapshot, and the
+// palette when it was saved with them.
+type vdpRegisters struct {
+	r       [VDPRegisterCount]byte
+	palette []byte
+}
+
+// ParseVDPRegisters parses a comma separated list of register values, such
+// as "0=0x06,1=&H60,R#23=10". Values are decimal, or hexadecimal with a 0x
+// or &H prefix.
+func ParseVDPRegisters(text string) (map[int]byte, error) {
+	values := map[int]byte{}
+	if strings.TrimSpace(text) == "" {
+		return values, nil
+	}
+	for _, field := range strings.Split(text, ",") {
+		number, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid register value %q, expected register=value", field)
+		}
+		number = strings.TrimPrefix(strings.ToUpper(number), "R")
+		number = strings.TrimPrefix(number, "#")
+		register, err := strconv.Atoi(number)
+		if err != nil || register < 0 || register >= VDPRegisterCount {
+			return nil, fmt.Errorf("invalid register number in %q", field)
+		}
+		if strings.HasPrefix(strings.ToUpper(value), "&H") {
+			value = "0x" + value[2:]
+		}
+		parsed, err := strconv.ParseUint(value, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid register value in %q", field)
+		}
+		values[register] = byte(parsed)
+	}
+	return values, nil
+}
+
+// loadVDPRegisters reads a register file and applies the register values of
+// the text on top of it.
+func loadVDPRegisters(data []byte, text string) (vdpRegisters, error) {
+	var regs vdpRegisters
+	if len(data) == 0 && strings.TrimSpace(text) == "" {
+		return regs, errors.New("a VRAM snapshot needs the VDP registers, as a second input or with -vdp")
+	}
+	switch {
+	case len(data) <= VDPRegisterCount:
+		copy(regs.r[:], data)
+	case len(data) == VDPRegisterCount+MSXPaletteSize:
+		copy(regs.r[:], data)
+		regs.palette = data[VDPRegisterCount:]
+	default:
+		return regs, fmt.Errorf("a register file has up to %d bytes, or %d with palette, got %d",
+			VDPRegisterCount, VDPRegisterCount+MSXPaletteSize, len(data))
+	}
+
+	values, err := ParseVDPRegisters(text)
+	if err != nil {
+		return regs, err
+	}
+	for register, value := range values {
+		regs.r[register] = value
+	}
+	return regs, nil
+}
+
+// mode returns the display mode selected by the mode bits.
+func (regs *vdpRegisters) mode() (vdpMode, error) {
+	bits := int(regs.r[1]>>4&1 | regs.r[1]>>2&2 | regs.r[0]<<1&0b11100)
+	mode, ok := vdpModes[bits]
+	if !ok {
+		return mode, fmt.Errorf("unsupported VDP mode bits M5-M1 %05b", bits)
+	}
+	return mode, nil
+}
+
+// colors returns the 16 colour palette: the one saved with the registers,
+// the copy MSX BASIC keeps in VRAM, or the default palette.
+func (regs *vdpRegisters) colors(v vram, mode vdpMode, profile *ColorProfile) color.Palette {
+	if regs.palette != nil {
+		return getPalette(regs.palette, 0, profile)
+	}
+	if mode.paletteTable > 0 && validPaletteTable(v, mode.paletteTable) {
+		return vramPalette(v, mode.paletteTable, profile)
+	}
+	return profile.defaultPalette()
+}
+
+// validPaletteTable tells whether the VRAM holds a palette at address: not
+// all zero, and without bits set that the 9-bit palette does not use.
+func validPaletteTable(v vram, address int) bool {
+	used := false
+	for i := 0; i < MSXPaletteSize; i += 2 {
+		rb, g := v.at(address+i), v.at(address+i+1)
+		if rb&0x88 != 0 || g&0xF8 != 0 {
+			return false
+		}
+		used = used || rb != 0 || g != 0
+	}
+	return used
+}
+
+// page returns the address and size of the page shown in a bitmap mode.
+// Screens 5 and 6 have four pages of 32 KB, screens 7 and 8 two of 64 KB.
+func (regs *vdpRegisters) page(mode vdpMode) (address, size int) {
+	if mode.width*mode.bits/8 > 128 {
+		return int(regs.r[2]&0x20) << 11, 0x10000
+	}
+	return int(regs.r[2]&0x60) << 10, 0x8000
+}
+
+// spriteLayout returns the sprite tables, size and magnification set in the
+// registers.
+func (regs *vdpRegisters) spriteLayout(mode vdpMode) spriteLayout {
+	layout := spriteLayout{
+		mode:       mode.sprites,
+		attributes: (int(regs.r[11])<<15 | int(regs.r[5])<<7) & (VRAMSize - 1),
+		patterns:   int(regs.r[6]&0x3F) << 11,
+		size:       8,
+		magnify:    regs.r[1]&0x01 != 0,
+		scroll:     int(regs.r[23]),
+	}
+	if regs.r[1]&0x02 != 0 {
+		layout.size = 16
+	}
+	if mode.sprites == 2 {
+		// the colour table is the 512 bytes below the attribute table
+		layout.attributes &^= 0x180
+		layout.colors = layout.attributes &^ 0x3FF
+	}
+	return layout
+}
+
+// DecodeVRAM renders the visible screen of a VRAM snapshot with the display
+// mode, table addresses, page, scroll registers and palette of the VDP
+// registers. The registers come from ExtraData, a register file, and the
+// VDPRegisters text of the configuration.
+func DecodeVRAM(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	if len(data) > VRAMSize {
+		return decoders.DecoderResult{}, fmt.Errorf("a VRAM snapshot has at most %d bytes, got %d", VRAMSize, len(data))
+	}
+	regs, err := loadVDPRegisters(config.ExtraData, config.VDPRegisters)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	mode, err := regs.mode()
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	profile, err := configProfile(config)
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	v := vram{data: data}
+	r := &regs.r
+	colors := regs.colors(v, mode, profile)
+	palette, backdrop := colors, r[7]&0x0F
+	if mode.name == modeGraphic7 {
+		palette, backdrop = screen8Palette(profile), r[7]
+	}
+
+	// colour 0 shows the backdrop colour unless the TP bit is set
+	if config.Color0 == "" {
+		if r[8]&0x20 != 0 {
+			config.Color0 = Color0Opaque
+		} else {
+			palette = append(color.Palette{}, palette...)
+			palette[0] = palette[backdrop]
+		}
+	}
+	palette = applyColor0(palette, config)
+
+	height := Height192
+	if r[9]&0x80 != 0 {
+		height = ScreenHeight
+	}
+
+	var img image.Image
+	if mode.bits > 0 {
+		page, pageSize := regs.page(mode)
+		view := bitmapView{
+			vram:     v,
+			page:     page,
+			pageSize: pageSize,
+			width:    mode.width,
+			height:   height,
+			bits:     mode.bits,
+			scrollX:  (int(r[26]&0x3F)*8 - int(r[27]&0x07)) * mode.width / 256,
+			scrollY:  int(r[23]),
+			twoPages: r[25]&0x01 != 0,
+		}
+		if mode.name == modeGraphic7 && r[25]&0x08 != 0 {
+			img = toPaletted(renderYJK(view, profile, colors, r[25]&0x10 != 0))
+		} else {
+			img = renderPaletted(view, palette)
+		}
+	} else {
+		img = renderPatterns(v, r, mode, height, palette)
+	}
+
+	// MSK hides the leftmost 8 pixel clocks behind the border
+	if r[25]&0x02 != 0 {
+		clocks := 8
+		if mode.width > ScreenWidth {
+			clocks = 16
+		}
+		img = maskLeft(img, clocks, palette[backdrop])
+	}
+
+	layout := regs.spriteLayout(mode)
+	if mode.name == modeGraphic7 {
+		layout.palette = graphic7SpritePalette(profile)
+	}
+	switch {
+	case mode.sprites == 0 && config.Sprites == SpritesOverlay:
+		config.Sprites = "" // the text modes have no sprites
+	case config.Sprites == "" && mode.sprites > 0 && r[8]&0x02 == 0:
+		config.Sprites = SpritesOverlay
+	}
+
+	result, err := finishScreen(img, v, config, layout, mode.width > ScreenWidth)
+	if err != nil {
+		return result, err
+	}
+	if r[1]&0x40 == 0 {
+		result.Warnings = append(result.Warnings, "the display is disabled (R#1 BL is 0); the screen contents are shown anyway")
+	}
+	return result, nil
+}
+
+// renderPatterns draws the text and pattern modes. The blink attributes of
+// TEXT2 are not shown.
+func renderPatterns(v vram, r *[VDPRegisterCount]byte, mode vdpMode, height int, palette color.Palette) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, mode.width, height), palette)
+	names := int(r[2]&0x7F) << 10
+	patterns := int(r[4]&0x3F) << 11
+	foreground, background := r[7]>>4, r[7]&0x0F
+
+	for y := 0; y < height; y++ {
+		line := (y + int(r[23])) & 0xFF
+		row := line >> 3
+		for x := 0; x < mode.width; x++ {
+			var c byte
+			switch mode.name {
+			case modeText1, modeText2:
+				columns := mode.width / 6
+				base := names
+				if mode.name == modeText2 {
+					base = int(r[2]&0x7C) << 10
+				}
+				name := int(v.at(base + row*columns + x/6))
+				c = background
+				if v.at(patterns+name*8+line&7)&(0x80>>(x%6)) != 0 {
+					c = foreground
+				}
+			case modeMulticolor:
+				// every pattern byte holds the colours of 4x4 pixel blocks
+				name := int(v.at(names + row*32 + x/8))
+				colors := v.at(patterns + name*8 + row&3*2 + line>>2&1)
+				c = colors & 0x0F
+				if x%8 < 4 {
+					c = colors >> 4
+				}
+			case modeGraphic1:
+				name := int(v.at(names + row*32 + x/8))
+				colors := v.at(int(r[10]&0x07)<<14 | int(r[3])<<6 + name/8)
+				c = colors & 0x0F
+				if v.at(patterns+name*8+line&7)&(0x80>>(x%8)) != 0 {
+					c = colors >> 4
+				}
+			default:
+				// GRAPHIC2 and GRAPHIC3 have a pattern and colour per line of
+				// each third of the screen, masked by the table registers
+				name := int(v.at(names + row*32 + x/8))
+				offset := (line>>6&3<<8|name)<<3 | line&7
+				pattern := v.at(int(r[4]&0x3C)<<11 | offset&(int(r[4]&0x03)<<11|0x7FF))
+				colors := v.at(int(r[10]&0x07)<<14 | int(r[3]&0x80)<<6 | offset&(int(r[3]&0x7F)<<6|0x3F))
+				c = colors & 0x0F
+				if pattern&(0x80>>(x%8)) != 0 {
+					c = colors >> 4
+				}
+			}
+			img.SetColorIndex(x, y, c)
+		}
+	}
+	return img
+}
+
+// maskLeft fills the leftmost pixels with the border colour.
+func maskLeft(img image.Image, width int, border color.Color) image.Image {
+	bounds := img.Bounds()
+	if paletted, ok := img.(*image.Paletted); ok {
+		index := uint8(paletted.Palette.Index(border))
+		if paletted.Palette[index] == border {
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Min.X+width; x++ {
+					paletted.SetColorIndex(x, y, index)
+				}
+			}
+			return paletted
+		}
+	}
+
+	rgba := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if x < bounds.Min.X+width {
+				rgba.Set(x, y, border)
+			} else {
+				rgba.Set(x, y, img.At(x, y))
+			}
+		}
+	}
+	return rgba
+}
+
+// DescribeVDPRegisters returns the display mode and table addresses set in
+// the registers, for the info command.
+func DescribeVDPRegisters(data []byte, text string) ([]string, error) {
+	regs, err := loadVDPRegisters(data, text)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := regs.mode()
+	if err != nil {
+		return nil, err
+	}
+	r := &regs.r
+	if mode.name == modeGraphic7 && r[25]&0x08 != 0 {
+		mode.screen = "12"
+		if r[25]&0x10 != 0 {
+			mode.screen = "10 or 11"
+		}
+	}
+
+	info := []string{fmt.Sprintf("Mode:     %s (SCREEN %s)", mode.name, mode.screen)}
+	if mode.bits > 0 {
+		page, pageSize := regs.page(mode)
+		info = append(info, fmt.Sprintf("Page:     %d at &H%05X", page/pageSize, page))
+	} else {
+		info = append(info, fmt.Sprintf("Names:    &H%05X", int(r[2]&0x7F)<<10))
+		info = append(info, fmt.Sprintf("Patterns: &H%05X", int(r[4]&0x3F)<<11))
+	}
+	if mode.sprites > 0 {
+		layout := regs.spriteLayout(mode)
+		info = append(info, fmt.Sprintf("Sprites:  mode %d, %dx%d, attributes &H%05X, patterns &H%05X",
+			mode.sprites, layout.size, layout.size, layout.attributes, layout.patterns))
+	}
+	info = append(info, fmt.Sprintf("Scroll:   R#23 %d, R#26 %d, R#27 %d", r[23], r[26], r[27]))
+	return info, nil
+}
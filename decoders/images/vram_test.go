@@ -0,0 +1,144 @@
+package images
+
+import (
+	"image"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// screen5Registers are the registers of SCREEN 5 with page 1 shown, 212
+// lines, TP set and sprites disabled.
+var screen5Registers = []byte{0: 0x06, 1: 0x60, 2: 0x3F, 5: 0xEF, 6: 0x0F, 8: 0x22, 9: 0x80}
+
+// screen5VRAM returns 128 KB of VRAM with the screen5 test picture on page 1.
+func screen5VRAM() []byte {
+	data := make([]byte, VRAMSize)
+	copy(data[0x8000:], screen5()[7:])
+	copy(data[PaletteOffset5:], screen5()[7+PaletteOffset5:])
+	return data
+}
+
+func samePixels(t *testing.T, expected, actual image.Image, dx, dy int) {
+	bounds := actual.Bounds()
+	for y := 0; y < bounds.Dy()-dy; y++ {
+		for x := 0; x < bounds.Dx()-dx; x++ {
+			if !assert.Equal(t, expected.At(x+dx, y+dy), actual.At(x, y), "pixel %d,%d", x, y) {
+				return
+			}
+		}
+	}
+}
+
+func TestDecodeVRAM_Screen5Page(t *testing.T) {
+	expected, err := DecodeScreen5(screen5(), decoders.Config{})
+	assert.NoError(t, err)
+	result, err := DecodeVRAM(screen5VRAM(), decoders.Config{ExtraData: screen5Registers})
+	assert.NoError(t, err)
+
+	img := decodePNG(t, result)
+	assert.Equal(t, image.Rect(0, 0, ScreenWidth, ScreenHeight), img.Bounds())
+	samePixels(t, decodePNG(t, expected), img, 0, 0)
+}
+
+func TestDecodeVRAM_Scroll(t *testing.T) {
+	expected, err := DecodeScreen5(screen5(), decoders.Config{})
+	assert.NoError(t, err)
+
+	// R#23 moves the screen up, R#26 and R#27 move it left
+	result, err := DecodeVRAM(screen5VRAM(), decoders.Config{ExtraData: screen5Registers, VDPRegisters: "23=10,26=1,27=3"})
+	assert.NoError(t, err)
+	samePixels(t, decodePNG(t, expected), decodePNG(t, result), 5, 10)
+}
+
+func TestDecodeVRAM_Backdrop(t *testing.T) {
+	// without TP colour 0 shows the backdrop colour of R#7
+	result, err := DecodeVRAM(screen5VRAM(), decoders.Config{ExtraData: screen5Registers, VDPRegisters: "7=15,8=0x02"})
+	assert.NoError(t, err)
+	paletted := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, paletted.Palette[15], paletted.Palette[0])
+}
+
+func TestDecodeVRAM_Graphic2(t *testing.T) {
+	// SCREEN 2 tables: names 0x1800, patterns 0x0000, colours 0x2000
+	data := make([]byte, 0x4000)
+	data[0x1800+1] = 3              // second character of the top third
+	data[0x1800+512] = 3            // first character of the bottom third
+	data[3*8] = 0xF0                // pattern 3, line 0 of the top third
+	data[0x1000+3*8+1] = 0x0F       // pattern 3, line 1 of the bottom third
+	data[0x2000+3*8] = 0x4A         // blue on yellow
+	data[0x2000+0x1000+3*8+1] = 0x6 // black on dark red
+	registers := []byte{0: 0x02, 1: 0x40, 2: 0x06, 3: 0xFF, 4: 0x03, 5: 0x36, 6: 0x07, 8: 0x22}
+
+	result, err := DecodeVRAM(data, decoders.Config{ExtraData: registers})
+	assert.NoError(t, err)
+	img := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, image.Rect(0, 0, 256, Height192), img.Bounds())
+	assert.Equal(t, uint8(4), img.ColorIndexAt(8, 0))
+	assert.Equal(t, uint8(10), img.ColorIndexAt(12, 0))
+	assert.Equal(t, uint8(6), img.ColorIndexAt(0, 129))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(4, 129))
+}
+
+func TestDecodeVRAM_Text1(t *testing.T) {
+	// SCREEN 0: names 0x0000, patterns 0x0800
+	data := make([]byte, 0x4000)
+	data[41] = 'A'
+	data[0x800+'A'*8+2] = 0x84
+	result, err := DecodeVRAM(data, decoders.Config{VDPRegisters: "1=0x50,4=1,7=0xF4,8=0x20"})
+	assert.NoError(t, err)
+	img := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, image.Rect(0, 0, 240, Height192), img.Bounds())
+	assert.Equal(t, uint8(15), img.ColorIndexAt(6, 10))
+	assert.Equal(t, uint8(4), img.ColorIndexAt(7, 10))
+	assert.Equal(t, uint8(15), img.ColorIndexAt(11, 10))
+}
+
+func TestDecodeVRAM_Sprites(t *testing.T) {
+	data := make([]byte, VRAMSize)
+	data[spriteLayout5.attributes] = 99
+	data[spriteLayout5.attributes+4] = spriteEnd2
+	data[spriteLayout5.patterns] = 0x80
+	data[spriteLayout5.colors] = 9
+
+	// 8x8 sprites, magnified
+	result, err := DecodeVRAM(data, decoders.Config{ExtraData: screen5Registers, VDPRegisters: "1=0x61,8=0x20"})
+	assert.NoError(t, err)
+	img := decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, uint8(9), img.ColorIndexAt(1, 101))
+	assert.Equal(t, uint8(0), img.ColorIndexAt(2, 100))
+
+	// vertical scroll moves the sprites too
+	result, err = DecodeVRAM(data, decoders.Config{ExtraData: screen5Registers, VDPRegisters: "1=0x61,8=0x20,23=50"})
+	assert.NoError(t, err)
+	img = decodePNG(t, result).(*image.Paletted)
+	assert.Equal(t, uint8(9), img.ColorIndexAt(1, 50))
+}
+
+func TestDecodeVRAM_Errors(t *testing.T) {
+	_, err := DecodeVRAM(make([]byte, 0x4000), decoders.Config{})
+	assert.Error(t, err)
+	_, err = DecodeVRAM(make([]byte, VRAMSize+1), decoders.Config{ExtraData: screen5Registers})
+	assert.Error(t, err)
+	_, err = DecodeVRAM(make([]byte, 0x4000), decoders.Config{VDPRegisters: "0=0x0C,1=0x10"})
+	assert.Error(t, err)
+}
+
+func TestParseVDPRegisters(t *testing.T) {
+	values, err := ParseVDPRegisters("0=0x0E, R#1=&H62, r23=10")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]byte{0: 0x0E, 1: 0x62, 23: 10}, values)
+
+	for _, text := range []string{"0", "64=1", "1=256", "x=1"} {
+		_, err = ParseVDPRegisters(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestDescribeVDPRegisters(t *testing.T) {
+	info, err := DescribeVDPRegisters(screen5Registers, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mode:     GRAPHIC4 (SCREEN 5)", info[0])
+	assert.Equal(t, "Page:     1 at &H08000", info[1])
+}
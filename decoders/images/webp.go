@@ -0,0 +1,324 @@
+package images
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// WebP lossless (VP8L) encoder. It only writes literal pixels, without
+// transforms, colour cache or backward references; MSX screens have few
+// colours so the prefix codes alone already give a compact file.
+
+const (
+	vp8lSignature    = 0x2F
+	vp8lMaxSize      = 16384
+	vp8lMaxCodeLen   = 15
+	vp8lMaxCLCodeLen = 7
+)
+
+// order in which the code length code lengths are stored
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// alphabet sizes of the green, red, blue, alpha and distance prefix codes
+var vp8lAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+type bitWriter struct {
+	data  []byte
+	acc   uint64
+	nbits uint
+}
+
+// writeBits writes the n lowest bits of value, least significant bit first.
+func (w *bitWriter) writeBits(value uint32, n uint) {
+	w.acc |= uint64(value) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.data = append(w.data, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.data = append(w.data, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.data
+}
+
+// prefixCode is a canonical prefix code with the codes stored bit-reversed,
+// as VP8L reads them least significant bit first.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+	single  bool // only one symbol, which takes no bits
+}
+
+func (c *prefixCode) write(w *bitWriter, symbol int) {
+	if !c.single {
+		w.writeBits(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+	}
+}
+
+// newPrefixCode builds a length-limited Huffman code for the histogram.
+func newPrefixCode(histogram []int, maxLength int) *prefixCode {
+	code := &prefixCode{lengths: make([]uint8, len(histogram)), codes: make([]uint16, len(histogram))}
+
+	var used []int
+	for symbol, count := range histogram {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	switch len(used) {
+	case 0:
+		code.single = true
+		return code
+	case 1:
+		code.lengths[used[0]] = 1
+		code.single = true
+		return code
+	}
+
+	counts := make([]int, len(histogram))
+	copy(counts, histogram)
+	for {
+		if huffmanLengths(counts, code.lengths) <= maxLength {
+			break
+		}
+		// flatten the histogram until the code fits
+		for i := range counts {
+			if counts[i] > 0 {
+				counts[i] = (counts[i] + 1) / 2
+			}
+		}
+	}
+
+	// canonical codes
+	var lengthCount [vp8lMaxCodeLen + 1]int
+	for _, length := range code.lengths {
+		lengthCount[length]++
+	}
+	lengthCount[0] = 0
+	var nextCode [vp8lMaxCodeLen + 2]int
+	for length := 1; length <= vp8lMaxCodeLen; length++ {
+		nextCode[length+1] = (nextCode[length] + lengthCount[length]) << 1
+	}
+	for symbol, length := range code.lengths {
+		if length > 0 {
+			code.codes[symbol] = reverseBits(nextCode[length], int(length))
+			nextCode[length]++
+		}
+	}
+	return code
+}
+
+// huffmanLengths stores the Huffman code lengths of the histogram and
+// returns the longest length.
+func huffmanLengths(histogram []int, lengths []uint8) int {
+	type node struct {
+		count       int
+		symbol      int
+		left, right int
+	}
+	var nodes []node
+	var queue []int
+	for symbol, count := range histogram {
+		lengths[symbol] = 0
+		if count > 0 {
+			nodes = append(nodes, node{count: count, symbol: symbol, left: -1, right: -1})
+			queue = append(queue, len(nodes)-1)
+		}
+	}
+
+	for len(queue) > 1 {
+		sort.Slice(queue, func(i, j int) bool {
+			a, b := nodes[queue[i]], nodes[queue[j]]
+			if a.count != b.count {
+				return a.count < b.count
+			}
+			return queue[i] < queue[j]
+		})
+		nodes = append(nodes, node{count: nodes[queue[0]].count + nodes[queue[1]].count, symbol: -1, left: queue[0], right: queue[1]})
+		queue = append(queue[2:], len(nodes)-1)
+	}
+
+	longest := 0
+	var walk func(n, depth int)
+	walk = func(n, depth int) {
+		if nodes[n].symbol >= 0 {
+			lengths[nodes[n].symbol] = uint8(depth)
+			longest = max(longest, depth)
+			return
+		}
+		walk(nodes[n].left, depth+1)
+		walk(nodes[n].right, depth+1)
+	}
+	walk(queue[0], 0)
+	return longest
+}
+
+func reverseBits(code, length int) uint16 {
+	var reversed uint16
+	for i := 0; i < length; i++ {
+		reversed = reversed<<1 | uint16(code&1)
+		code >>= 1
+	}
+	return reversed
+}
+
+// writePrefixCode stores a prefix code, as a simple code when it has at most
+// two symbols below 256 and as a normal code otherwise.
+func writePrefixCode(w *bitWriter, code *prefixCode) {
+	var used []int
+	for symbol, length := range code.lengths {
+		if length > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		w.writeBits(1, 1) // simple code
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		w.writeBits(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.writeBits(0, 1)
+			w.writeBits(uint32(used[0]), 1)
+		} else {
+			w.writeBits(1, 1)
+			w.writeBits(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			w.writeBits(uint32(used[1]), 8)
+		}
+		return
+	}
+
+	// code lengths as symbols 0-15, with 17 and 18 for runs of zeros
+	type clSymbol struct {
+		symbol int
+		extra  uint32
+		bits   uint
+	}
+	var symbols []clSymbol
+	histogram := make([]int, 19)
+	for i := 0; i < len(code.lengths); {
+		length := int(code.lengths[i])
+		run := 1
+		for i+run < len(code.lengths) && code.lengths[i+run] == 0 && length == 0 {
+			run++
+		}
+		switch {
+		case length == 0 && run >= 11:
+			run = min(run, 138)
+			symbols = append(symbols, clSymbol{18, uint32(run - 11), 7})
+		case length == 0 && run >= 3:
+			symbols = append(symbols, clSymbol{17, uint32(run - 3), 3})
+		default:
+			run = 1
+			symbols = append(symbols, clSymbol{length, 0, 0})
+		}
+		histogram[symbols[len(symbols)-1].symbol]++
+		i += run
+	}
+
+	clCode := newPrefixCode(histogram, vp8lMaxCLCodeLen)
+
+	w.writeBits(0, 1)  // normal code
+	w.writeBits(15, 4) // all 19 code length code lengths
+	for _, symbol := range vp8lCodeLengthOrder {
+		w.writeBits(uint32(clCode.lengths[symbol]), 3)
+	}
+	w.writeBits(0, 1) // code lengths for the whole alphabet
+	for _, s := range symbols {
+		clCode.write(w, s.symbol)
+		if s.bits > 0 {
+			w.writeBits(s.extra, s.bits)
+		}
+	}
+}
+
+// encodeWebPLossless writes the image as a lossless WebP file.
+func encodeWebPLossless(out io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return errors.New("image size not supported by WebP")
+	}
+
+	argb := make([][4]uint8, 0, width*height) // green, red, blue, alpha
+	alphaUsed := false
+	var histograms [5][]int
+	for i, size := range vp8lAlphabetSizes {
+		histograms[i] = make([]int, size)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// VP8L stores unpremultiplied colours
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xFF {
+				alphaUsed = true
+			}
+			pixel := [4]uint8{c.G, c.R, c.B, c.A}
+			argb = append(argb, pixel)
+			for i, value := range pixel {
+				histograms[i][value]++
+			}
+		}
+	}
+
+	w := &bitWriter{}
+	w.writeBits(vp8lSignature, 8)
+	w.writeBits(uint32(width-1), 14)
+	w.writeBits(uint32(height-1), 14)
+	if alphaUsed {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+	w.writeBits(0, 3) // version
+	w.writeBits(0, 1) // no transforms
+	w.writeBits(0, 1) // no colour cache
+	w.writeBits(0, 1) // a single group of prefix codes
+
+	var codes [5]*prefixCode
+	for i := range codes {
+		codes[i] = newPrefixCode(histograms[i], vp8lMaxCodeLen)
+		writePrefixCode(w, codes[i])
+	}
+	for _, pixel := range argb {
+		for i, value := range pixel {
+			codes[i].write(w, int(value))
+		}
+	}
+
+	payload := w.bytes()
+	chunkSize := len(payload)
+	padding := chunkSize & 1
+
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(4+8+chunkSize+padding))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(chunkSize))
+
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	if _, err := out.Write(payload); err != nil {
+		return err
+	}
+	if padding == 1 {
+		_, err := out.Write([]byte{0})
+		return err
+	}
+	return nil
+}
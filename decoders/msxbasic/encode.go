@@ -0,0 +1,309 @@
+package msxbasic
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"msxconverter/decoders"
+	"strconv"
+	"strings"
+)
+
+// start address of a BASIC program in RAM, used for the line links
+const programStart = 0x8001
+
+// tokens followed by line numbers, these are stored as 0x0E line references
+var lineNumberTokens = map[string]bool{
+	"GOTO": true, "GOSUB": true, "THEN": true, "ELSE": true, "RESTORE": true,
+	"RUN": true, "LIST": true, "LLIST": true, "DELETE": true, "RENUM": true,
+	"RESUME": true, "AUTO": true,
+}
+
+// EncodeMSXBasic tokenizes an ASCII BASIC listing to the MSX BASIC file
+// format, the reverse of DecodeMSXBasic.
+func EncodeMSXBasic(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	var program bytes.Buffer
+	program.WriteByte(0xFF)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lastNumber := -1
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), " \r\x1a")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		number, tokens, err := tokenizeLine(text)
+		if err != nil {
+			return decoders.DecoderResult{}, err
+		}
+		if number <= lastNumber {
+			return decoders.DecoderResult{}, fmt.Errorf("line %d follows line %d", number, lastNumber)
+		}
+		lastNumber = number
+
+		// link to the next line: current address + link + number + tokens + 0
+		next := programStart + program.Len() - 1 + 4 + len(tokens) + 1
+		program.Write([]byte{byte(next), byte(next >> 8), byte(number), byte(number >> 8)})
+		program.Write(tokens)
+		program.WriteByte(0x00)
+	}
+	if err := scanner.Err(); err != nil {
+		return decoders.DecoderResult{}, err
+	}
+	program.Write([]byte{0x00, 0x00})
+
+	return decoders.DecoderResult{Buffer: &program, Extension: ".BAS"}, nil
+}
+
+// tokenizeLine splits off the line number and tokenizes the rest of a line.
+func tokenizeLine(text string) (int, []byte, error) {
+	text = strings.TrimLeft(text, " ")
+	end := 0
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	number, err := strconv.Atoi(text[:end])
+	if err != nil || number > 65529 {
+		return 0, nil, fmt.Errorf("invalid line number in: %s", text)
+	}
+	text = strings.TrimPrefix(text[end:], " ")
+
+	var tokens bytes.Buffer
+	expectLine := false
+	for pos := 0; pos < len(text); {
+		c := text[pos]
+
+		switch {
+		case c == '"':
+			end := strings.IndexByte(text[pos+1:], '"')
+			if end == -1 {
+				tokens.WriteString(text[pos:])
+				pos = len(text)
+			} else {
+				tokens.WriteString(text[pos : pos+end+2])
+				pos += end + 2
+			}
+			continue
+
+		case c == '&' && pos+1 < len(text) && (text[pos+1] == 'H' || text[pos+1] == 'h' || text[pos+1] == 'O' || text[pos+1] == 'o'):
+			base, token := 16, byte(0x0C)
+			if text[pos+1] == 'O' || text[pos+1] == 'o' {
+				base, token = 8, 0x0B
+			}
+			end := pos + 2
+			for end < len(text) && isDigit(text[end], base) {
+				end++
+			}
+			value, err := strconv.ParseUint(text[pos+2:end], base, 16)
+			if err != nil {
+				return 0, nil, fmt.Errorf("line %d: invalid number %s", number, text[pos:end])
+			}
+			tokens.Write([]byte{token, byte(value), byte(value >> 8)})
+			pos = end
+			continue
+
+		case (c >= '0' && c <= '9') || (c == '.' && pos+1 < len(text) && text[pos+1] >= '0' && text[pos+1] <= '9'):
+			if pos > 0 && isIdentifier(text[pos-1]) {
+				tokens.WriteByte(c)
+				pos++
+				continue
+			}
+			literal, length := scanNumber(text[pos:])
+			encoded, err := encodeNumber(literal, expectLine)
+			if err != nil {
+				return 0, nil, fmt.Errorf("line %d: %v", number, err)
+			}
+			tokens.Write(encoded)
+			pos += length
+			continue
+
+		case c == '\'':
+			tokens.Write([]byte{':', 0x8F, 0xE6})
+			tokens.WriteString(text[pos+1:])
+			pos = len(text)
+			continue
+		}
+
+		name, code := matchToken(text[pos:])
+		if c == '?' {
+			name, code = "?", []byte{0x91} // short for PRINT
+		}
+		if name == "" {
+			if c == ',' && expectLine {
+				// ON X GOTO 10,20,30
+			} else if c != ' ' {
+				expectLine = false
+			}
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			tokens.WriteByte(c)
+			pos++
+			continue
+		}
+
+		pos += len(name)
+		expectLine = lineNumberTokens[name] || (name == "-" && expectLine) // LIST 10-20
+		switch name {
+		case "ELSE":
+			if b := tokens.Bytes(); len(b) == 0 || b[len(b)-1] != ':' {
+				tokens.WriteByte(':')
+			}
+			tokens.Write(code)
+		case "REM":
+			tokens.Write(code)
+			tokens.WriteString(text[pos:])
+			pos = len(text)
+		case "DATA":
+			tokens.Write(code)
+			end := dataEnd(text[pos:])
+			tokens.WriteString(text[pos : pos+end])
+			pos += end
+		default:
+			tokens.Write(code)
+		}
+	}
+
+	return number, tokens.Bytes(), nil
+}
+
+// matchToken returns the longest BASIC keyword at the start of text.
+func matchToken(text string) (string, []byte) {
+	upper := strings.ToUpper(text)
+	best, code := "", []byte(nil)
+	for i, name := range tokenMap {
+		if len(name) > len(best) && strings.HasPrefix(upper, name) {
+			best, code = name, []byte{byte(0x81 + i)}
+		}
+	}
+	for i, name := range tokenMapFF {
+		if len(name) > len(best) && strings.HasPrefix(upper, name) {
+			best, code = name, []byte{0xFF, byte(0x81 + i)}
+		}
+	}
+	return best, code
+}
+
+// dataEnd returns the length of the literal DATA text, up to a colon that is
+// not inside a string.
+func dataEnd(text string) int {
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		if text[i] == '"' {
+			quoted = !quoted
+		} else if text[i] == ':' && !quoted {
+			return i
+		}
+	}
+	return len(text)
+}
+
+func isDigit(c byte, base int) bool {
+	_, err := strconv.ParseUint(string(c), base, 8)
+	return err == nil
+}
+
+func isIdentifier(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// scanNumber returns the numeric literal at the start of text, including a
+// type suffix.
+func scanNumber(text string) (string, int) {
+	end := 0
+	for end < len(text) && ((text[end] >= '0' && text[end] <= '9') || text[end] == '.') {
+		end++
+	}
+	if end < len(text) && (text[end] == 'E' || text[end] == 'e' || text[end] == 'D' || text[end] == 'd') {
+		exp := end + 1
+		if exp < len(text) && (text[exp] == '+' || text[exp] == '-') {
+			exp++
+		}
+		if exp < len(text) && text[exp] >= '0' && text[exp] <= '9' {
+			for end = exp; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+			}
+		}
+	}
+	if end < len(text) && (text[end] == '!' || text[end] == '#' || text[end] == '%') {
+		end++
+	}
+	return text[:end], end
+}
+
+// encodeNumber encodes a numeric literal as MSX BASIC stores it: small
+// integers as 0x11-0x1A, bytes as 0x0F, integers as 0x1C, line numbers as
+// 0x0E and other numbers as BCD single (0x1D) or double (0x1F) precision.
+func encodeNumber(literal string, lineNumber bool) ([]byte, error) {
+	suffix := literal[len(literal)-1]
+	plain := strings.TrimRight(literal, "!#%")
+	isInteger := !strings.ContainsAny(plain, ".EeDd")
+
+	if isInteger && suffix != '!' && suffix != '#' {
+		value, err := strconv.Atoi(plain)
+		if err == nil && lineNumber && value <= 65529 {
+			return []byte{0x0E, byte(value), byte(value >> 8)}, nil
+		}
+		if err == nil && value <= 32767 {
+			switch {
+			case value < 10:
+				return []byte{byte(0x11 + value)}, nil
+			case value < 256:
+				return []byte{0x0F, byte(value)}, nil
+			default:
+				return []byte{0x1C, byte(value), byte(value >> 8)}, nil
+			}
+		}
+		if suffix == '%' {
+			return nil, fmt.Errorf("integer %s out of range", literal)
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.NewReplacer("D", "E", "d", "E").Replace(plain), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s", literal)
+	}
+
+	digits := len(strings.TrimLeft(strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.SplitN(strings.ToUpper(plain), "E", 2)[0]), "0"))
+	if suffix == '#' || (suffix != '!' && (digits > 6 || strings.ContainsAny(plain, "Dd"))) {
+		return encodeBCD(0x1F, value, 14)
+	}
+	return encodeBCD(0x1D, value, 6)
+}
+
+// encodeBCD encodes a float in the MSX BCD format: an exponent byte with
+// the sign in bit 7 followed by the packed mantissa digits.
+func encodeBCD(token byte, value float64, digits int) ([]byte, error) {
+	result := make([]byte, 1+1+digits/2)
+	result[0] = token
+	if value == 0 {
+		return result, nil
+	}
+
+	var sign byte
+	if value < 0 {
+		sign = 0x80
+		value = -value
+	}
+
+	mantissa := strconv.FormatFloat(value, 'e', digits-1, 64) // d.dddddde±xx
+	parts := strings.SplitN(mantissa, "e", 2)
+	exponent, _ := strconv.Atoi(parts[1])
+	exponent++ // 0.d1d2.. * 10^exponent
+	if exponent < -63 || exponent > 63 {
+		return nil, errors.New("number out of range")
+	}
+	mantissaDigits := strings.Replace(parts[0], ".", "", 1)
+
+	result[1] = sign | byte(64+exponent)
+	for i := 0; i < digits/2; i++ {
+		result[2+i] = (mantissaDigits[2*i]-'0')<<4 | (mantissaDigits[2*i+1] - '0')
+	}
+	return result, nil
+}
@@ -0,0 +1,35 @@
+package msxbasic
+
+import (
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeMSXBasic_RoundTrip(t *testing.T) {
+	listing := "10 SCREEN 5:COLOR 15,0,0\n" +
+		"20 FOR I=0 TO 255:PSET(I,I),I MOD 16:NEXT I\n" +
+		"30 A$=\"Hello\":PRINT A$;1000;.05;&HFF\n" +
+		"40 IF INKEY$=\"\" THEN 40 ELSE GOTO 10\n" +
+		"50 DATA 1,2,\"a:b\"\n" +
+		"60 'comment\n"
+
+	encoded, err := EncodeMSXBasic([]byte(listing), decoders.Config{})
+	assert.NoError(t, err)
+
+	decoded, err := DecodeMSXBasic(encoded.Buffer.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, listing, decoded.Text)
+}
+
+func TestEncodeMSXBasic_LineLinks(t *testing.T) {
+	encoded, err := EncodeMSXBasic([]byte("10 END\n20 END\n"), decoders.Config{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{
+		0xFF,
+		0x07, 0x80, 0x0A, 0x00, 0x81, 0x00,
+		0x0D, 0x80, 0x14, 0x00, 0x81, 0x00,
+		0x00, 0x00,
+	}, encoded.Buffer.Bytes())
+}
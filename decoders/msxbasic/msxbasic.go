@@ -62,18 +62,30 @@ func DecodeMSXBasic(data []byte) (decoders.DecoderResult, error) {
 		// Read tokens until 0x00 (end of line)
 		for offset < len(data) && data[offset] != 0x00 {
 			token := data[offset]
-			if token == 0x0E || token == 0x1C {
+			if (token == 0x0E || token == 0x1C) && offset+3 <= len(data) {
 				line := int(data[offset+1]) | int(data[offset+2])<<8
 				offset += 2
 				result.WriteString(fmt.Sprintf("%d", line))
-			} else if token == 0x0F {
+			} else if token == 0x0F && offset+2 <= len(data) {
 				value := int(data[offset+1])
 				offset++
 				result.WriteString(fmt.Sprintf("%d", value))
-			} else if token == 0x1D {
+			} else if token == 0x1D && offset+5 <= len(data) {
 				//1D 3F 50 00 00 = .05
 				result.WriteString(customBCDToString(data[offset+1 : offset+5]))
 				offset += 4
+			} else if token == 0x1F && offset+9 <= len(data) {
+				// double precision, 14 digits
+				result.WriteString(bcdToString(data[offset+1 : offset+9]))
+				offset += 8
+			} else if (token == 0x0B || token == 0x0C) && offset+3 <= len(data) {
+				value := int(data[offset+1]) | int(data[offset+2])<<8
+				offset += 2
+				if token == 0x0B {
+					result.WriteString(fmt.Sprintf("&O%o", value))
+				} else {
+					result.WriteString(fmt.Sprintf("&H%X", value))
+				}
 			} else if token == 0x3A {
 				if offset+1 < len(data) {
 					nextToken := data[offset+1]
@@ -92,8 +104,12 @@ func DecodeMSXBasic(data []byte) (decoders.DecoderResult, error) {
 				// Skip this byte and use the token map FF for the next byte
 				offset++
 				if offset < len(data) {
-					nextToken := data[offset]
-					result.WriteString(tokenMapFF[nextToken-0x81])
+					nextToken := int(data[offset]) - 0x81
+					if nextToken >= 0 && nextToken < len(tokenMapFF) {
+						result.WriteString(tokenMapFF[nextToken])
+					} else {
+						result.WriteString(fmt.Sprintf("-%d-", data[offset]))
+					}
 				}
 			} else if token >= 128 {
 				if int(token-0x81) < len(tokenMap) {
@@ -103,17 +119,13 @@ func DecodeMSXBasic(data []byte) (decoders.DecoderResult, error) {
 				}
 			} else if token == 34 { // quoted string
 				result.WriteByte(token)
-				offset++
-				for {
-					token = data[offset]
-					result.WriteByte(token)
-					if token == 34 {
-						break
-					}
-					if data[offset+1] == 0 {
+				// the string ends at the closing quote or the end of the line
+				for offset+1 < len(data) && data[offset+1] != 0 {
+					offset++
+					result.WriteByte(data[offset])
+					if data[offset] == 34 {
 						break
 					}
-					offset++
 				}
 			} else if token >= 32 {
 				result.WriteByte(token)
@@ -145,6 +157,15 @@ func customBCDToString(b []byte) string {
 	if len(b) != 4 {
 		return ""
 	}
+	return bcdToString(b)
+}
+
+// bcdToString formats a BCD number with an exponent byte followed by any
+// number of mantissa bytes.
+func bcdToString(b []byte) string {
+	if len(b) < 2 {
+		return ""
+	}
 
 	sign := ""
 	if b[0]&0x80 != 0 {
@@ -152,7 +173,7 @@ func customBCDToString(b []byte) string {
 	}
 
 	exponent := int(b[0]&0x7F) - 64
-	mantissa := fmt.Sprintf("%02X%02X%02X", b[1], b[2], b[3])
+	mantissa := fmt.Sprintf("%X", b[1:])
 
 	mantissaString := insertDecimalPoint(mantissa, 1)
 	mantissaString = RemoveTrailingZeros(mantissaString)
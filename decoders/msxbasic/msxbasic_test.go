@@ -46,3 +46,41 @@ func TestNegativeExponent(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeMSXBasic_Constants(t *testing.T) {
+	// 10 PRINT &HFF;&O17;1.2345678901234
+	data := []byte{
+		0xFF, 0x17, 0x80, 0x0A, 0x00, 0x91, ' ',
+		0x0C, 0xFF, 0x00, ';',
+		0x0B, 0x0F, 0x00, ';',
+		0x1F, 0x41, 0x12, 0x34, 0x56, 0x78, 0x90, 0x12, 0x34,
+		0x00, 0x00, 0x00,
+	}
+	result, err := DecodeMSXBasic(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "10 PRINT &HFF;&O17;1.2345678901234\n"; result.Text != expected {
+		t.Errorf("DecodeMSXBasic() = %q; want %q", result.Text, expected)
+	}
+}
+
+func TestDecodeMSXBasic_Truncated(t *testing.T) {
+	tests := map[string][]byte{
+		"unterminated string": {0xFF, 0x00, 0x80, 0x0A, 0x00, 0x91, '"', 'A'},
+		"quote at line end":   {0xFF, 0x09, 0x80, 0x0A, 0x00, '"', 0x00, 0x00, 0x00},
+		"line number":         {0xFF, 0x00, 0x80, 0x0A, 0x00, 0x89, 0x0E, 0x0A},
+		"byte":                {0xFF, 0x00, 0x80, 0x0A, 0x00, 0x0F},
+		"function":            {0xFF, 0x00, 0x80, 0x0A, 0x00, 0xFF, 0x20, 0x00},
+	}
+	for name, data := range tests {
+		if _, err := DecodeMSXBasic(data); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	result, _ := DecodeMSXBasic(tests["quote at line end"])
+	if result.Text != "10 \"\n" {
+		t.Errorf("DecodeMSXBasic() = %q; want %q", result.Text, "10 \"\n")
+	}
+}
@@ -0,0 +1,26 @@
+package rom
+
+// biosCalls are the names of the main BIOS entries.
+var biosCalls = map[int]string{
+	0x0000: "CHKRAM", 0x0008: "SYNCHR", 0x000C: "RDSLT", 0x0010: "CHRGTR",
+	0x0014: "WRSLT", 0x0018: "OUTDO", 0x001C: "CALSLT", 0x0020: "DCOMPR",
+	0x0024: "ENASLT", 0x0028: "GETYPR", 0x0030: "CALLF", 0x0038: "KEYINT",
+	0x003B: "INITIO", 0x003E: "INIFNK", 0x0041: "DISSCR", 0x0044: "ENASCR",
+	0x0047: "WRTVDP", 0x004A: "RDVRM", 0x004D: "WRTVRM", 0x0050: "SETRD",
+	0x0053: "SETWRT", 0x0056: "FILVRM", 0x0059: "LDIRMV", 0x005C: "LDIRVM",
+	0x005F: "CHGMOD", 0x0062: "CHGCLR", 0x0066: "NMI", 0x0069: "CLRSPR",
+	0x006C: "INITXT", 0x006F: "INIT32", 0x0072: "INIGRP", 0x0075: "INIMLT",
+	0x0078: "SETTXT", 0x007B: "SETT32", 0x007E: "SETGRP", 0x0081: "SETMLT",
+	0x0084: "CALPAT", 0x0087: "CALATR", 0x008A: "GSPSIZ", 0x008D: "GRPPRT",
+	0x0090: "GICINI", 0x0093: "WRTPSG", 0x0096: "RDPSG", 0x0099: "STRTMS",
+	0x009C: "CHSNS", 0x009F: "CHGET", 0x00A2: "CHPUT", 0x00A5: "LPTOUT",
+	0x00A8: "LPTSTT", 0x00AB: "CNVCHR", 0x00AE: "PINLIN", 0x00B1: "INLIN",
+	0x00B4: "QINLIN", 0x00B7: "BREAKX", 0x00BA: "ISCNTC", 0x00BD: "CKCNTC",
+	0x00C0: "BEEP", 0x00C3: "CLS", 0x00C6: "POSIT", 0x00C9: "FNKSB",
+	0x00CC: "ERAFNK", 0x00CF: "DSPFNK", 0x00D2: "TOTEXT", 0x00D5: "GTSTCK",
+	0x00D8: "GTTRIG", 0x00DB: "GTPAD", 0x00DE: "GTPDL", 0x00E1: "TAPION",
+	0x00E4: "TAPIN", 0x00E7: "TAPIOF", 0x00EA: "TAPOON", 0x00ED: "TAPOUT",
+	0x00F0: "TAPOOF", 0x00F3: "STMOTR", 0x0132: "CHGCAP", 0x0135: "CHGSND",
+	0x0138: "RSLREG", 0x013B: "WSLREG", 0x013E: "RDVDP", 0x0141: "SNSMAT",
+	0x0156: "KILBUF", 0x015F: "EXTROM", 0x0180: "CHGCPU", 0x0183: "GETCPU",
+}
@@ -0,0 +1,272 @@
+// Package rom analyses MSX cartridge ROMs: the AB header, the MegaROM
+// mapper, a BASIC program in the ROM and the code of the entry points.
+package rom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"msxconverter/decoders"
+	"msxconverter/decoders/msxbasic"
+	"msxconverter/decoders/z80"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Mapper types of MegaROMs.
+const (
+	MapperNone      = "none"
+	MapperUnknown   = "unknown"
+	MapperASCII8    = "ASCII8"
+	MapperASCII16   = "ASCII16"
+	MapperKonami    = "Konami"
+	MapperKonamiSCC = "Konami SCC"
+)
+
+// mappers with the addresses their bank registers are written to. Only
+// writes to the exclusive addresses tell one mapper from the others; ASCII16
+// ROMs usually only write 6000h and 7000h.
+var mappers = []struct {
+	name      string
+	addresses []int
+	exclusive []int
+}{
+	{MapperKonamiSCC, []int{0x5000, 0x7000, 0x9000, 0xB000}, []int{0x5000, 0x9000, 0xB000}},
+	{MapperKonami, []int{0x4000, 0x6000, 0x8000, 0xA000}, []int{0x4000, 0x8000, 0xA000}},
+	{MapperASCII8, []int{0x6000, 0x6800, 0x7000, 0x7800}, []int{0x6800, 0x7800}},
+	{MapperASCII16, []int{0x6000, 0x7000, 0x77FF}, nil},
+}
+
+// Info describes a ROM.
+type Info struct {
+	Size         int
+	Offset       int // offset of the header in the file
+	Address      int // address the header is mapped at
+	Init         int
+	Statement    int
+	Device       int
+	Text         int
+	Mapper       string
+	MapperReason string
+}
+
+// base is the address of the start of the file.
+func (info Info) base() int {
+	return info.Address - info.Offset
+}
+
+// Analyze reads the header of a ROM and guesses its mapper.
+func Analyze(data []byte) (Info, error) {
+	info := Info{Size: len(data)}
+	switch {
+	case len(data) >= 0x10 && string(data[0:2]) == "AB":
+		info.Address = 0x4000
+	case len(data) >= 0x4010 && len(data) <= 0x10000 && string(data[0x4000:0x4002]) == "AB":
+		// a ROM that starts in page 0
+		info.Offset, info.Address = 0x4000, 0x4000
+	default:
+		return info, errors.New("no AB cartridge header found")
+	}
+
+	header := data[info.Offset:]
+	info.Init = int(binary.LittleEndian.Uint16(header[2:]))
+	info.Statement = int(binary.LittleEndian.Uint16(header[4:]))
+	info.Device = int(binary.LittleEndian.Uint16(header[6:]))
+	info.Text = int(binary.LittleEndian.Uint16(header[8:]))
+
+	// 16 KB ROMs with BASIC or code in page 2 are mapped at 8000h
+	if info.Offset == 0 && len(data) <= 0x4000 && (inPage2(info.Init) || inPage2(info.Text)) {
+		info.Address = 0x8000
+	}
+
+	info.Mapper, info.MapperReason = MapperNone, "plain ROM"
+	if info.Offset == 0 && len(data) > 0x8000 {
+		info.Mapper, info.MapperReason = guessMapper(data)
+	}
+	return info, nil
+}
+
+func inPage2(address int) bool {
+	return address >= 0x8000 && address < 0xC000
+}
+
+// guessMapper counts the LD (nn),A instructions that write to the bank
+// registers of the mappers.
+func guessMapper(data []byte) (string, string) {
+	writes := map[int]int{}
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] == 0x32 {
+			writes[int(binary.LittleEndian.Uint16(data[i+1:]))]++
+		}
+	}
+
+	best, bestScore := "", 0
+	for _, m := range mappers {
+		score, exclusive := 0, 0
+		for _, address := range m.addresses {
+			score += writes[address]
+		}
+		for _, address := range m.exclusive {
+			exclusive += writes[address]
+		}
+		if score > bestScore && (exclusive > 0 || m.exclusive == nil) {
+			best, bestScore = m.name, score
+		}
+	}
+	if best == "" {
+		if len(data) > 0x10000 {
+			return MapperUnknown, "no writes to bank registers found"
+		}
+		return MapperNone, "plain ROM"
+	}
+
+	var addresses []int
+	for _, m := range mappers {
+		for _, address := range m.addresses {
+			if writes[address] > 0 && !slices.Contains(addresses, address) {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	sort.Ints(addresses)
+	counts := make([]string, len(addresses))
+	for i, address := range addresses {
+		counts[i] = fmt.Sprintf("&H%04X (%d)", address, writes[address])
+	}
+	return best, "writes to " + strings.Join(counts, ", ")
+}
+
+func entry(address int) string {
+	if address == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("&H%04X", address)
+}
+
+// Describe returns the header fields and the mapper of a ROM.
+func Describe(data []byte) []string {
+	info, err := Analyze(data)
+	if err != nil {
+		return []string{"Error:    " + err.Error()}
+	}
+	lines := []string{
+		fmt.Sprintf("ROM:      %d KB, header at offset &H%04X mapped at &H%04X", info.Size/1024, info.Offset, info.Address),
+		"INIT:     " + entry(info.Init),
+		"STATEMENT: " + entry(info.Statement),
+		"DEVICE:   " + entry(info.Device),
+		"TEXT:     " + entry(info.Text),
+	}
+	return append(lines, fmt.Sprintf("Mapper:   %s (%s)", info.Mapper, info.MapperReason))
+}
+
+// DecodeROM reports the header and mapper of a ROM, lists its BASIC program
+// and, with config.Disassemble, the code of its entry points.
+func DecodeROM(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	result := decoders.DecoderResult{IsText: true, Extension: ".txt"}
+	info, err := Analyze(data)
+	if err != nil {
+		return result, err
+	}
+
+	var out strings.Builder
+	for _, line := range Describe(data) {
+		out.WriteString(line + "\n")
+	}
+
+	if info.Text != 0 {
+		basic, err := basicProgram(data, info)
+		if err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		} else {
+			fmt.Fprintf(&out, "\nBASIC program at &H%04X:\n%s", info.Text, basic)
+		}
+	}
+
+	if config.Disassemble {
+		out.WriteString("\n" + disassemble(data, info))
+	}
+	result.Text = out.String()
+	return result, nil
+}
+
+// basicProgram decodes the tokenized program at TEXT, which may start with
+// the 0 byte that precedes programs in RAM.
+func basicProgram(data []byte, info Info) (string, error) {
+	offset := info.Text - info.base()
+	if offset < 0 || offset+4 > len(data) {
+		return "", fmt.Errorf("TEXT &H%04X is outside of the ROM", info.Text)
+	}
+	if data[offset] == 0 {
+		offset++
+	}
+	if err := checkLinks(data, offset, info.base()); err != nil {
+		return "", err
+	}
+	program, err := msxbasic.DecodeMSXBasic(append([]byte{0xFF}, data[offset:]...))
+	if err != nil {
+		return "", err
+	}
+	return program.Text, nil
+}
+
+// checkLinks follows the line links of the program at offset, so only a
+// program that ends with a 0 link after lines with rising numbers is
+// decoded. The links are addresses in the ROM mapped at base.
+func checkLinks(data []byte, offset, base int) error {
+	number := -1
+	for line := 1; offset+2 <= len(data); line++ {
+		link := int(binary.LittleEndian.Uint16(data[offset:]))
+		if link == 0 {
+			return nil
+		}
+		next := link - base
+		if offset+4 > len(data) || next <= offset+4 || next > len(data) || data[next-1] != 0 {
+			return fmt.Errorf("line link %d of the BASIC program at &H%04X is broken", line, base+offset)
+		}
+		if current := int(binary.LittleEndian.Uint16(data[offset+2:])); current > number {
+			number = current
+		} else {
+			return fmt.Errorf("line %d of the BASIC program follows line %d", current, number)
+		}
+		offset = next
+	}
+	return errors.New("the BASIC program has no end link")
+}
+
+// disassemble lists the code reached from INIT, STATEMENT and DEVICE. Of a
+// MegaROM only the first 16 KB are mapped before the ROM switches banks.
+func disassemble(data []byte, info Info) string {
+	memory := data
+	switch {
+	case info.Mapper != MapperNone:
+		memory = data[:0x4000]
+	case len(data) > 0x10000-info.base():
+		memory = data[:0x10000-info.base()]
+	}
+
+	labels := map[int]string{}
+	var entries []int
+	for _, e := range []struct {
+		name    string
+		address int
+	}{{"INIT", info.Init}, {"STATEMENT", info.Statement}, {"DEVICE", info.Device}} {
+		if e.address != 0 {
+			labels[e.address] = e.name
+			entries = append(entries, e.address)
+		}
+	}
+
+	code := z80.Trace(memory, info.base(), entries)
+	listing := z80.Listing{Memory: memory, Origin: info.base(), Code: code, Labels: labels, Comment: biosComment}
+	heading := "Disassembly:\n"
+	if len(memory) < len(data) {
+		heading = fmt.Sprintf("Disassembly of the first %d KB:\n", len(memory)/1024)
+	}
+	return heading + listing.String()
+}
+
+// biosComment names the BIOS routine called by an instruction.
+func biosComment(instruction z80.Instruction) string {
+	return biosCalls[instruction.Target]
+}
@@ -0,0 +1,108 @@
+package rom
+
+import (
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testROM returns a ROM of size bytes with a header and code that prints a
+// character and loops.
+func testROM(size int) []byte {
+	data := make([]byte, size)
+	copy(data, []byte{'A', 'B', 0x10, 0x40})
+	copy(data[0x10:], []byte{
+		0x3E, 0x41, // LD A,41h
+		0xCD, 0xA2, 0x00, // CALL CHPUT
+		0x18, 0xFE, // JR $
+	})
+	return data
+}
+
+// bankWrites adds count LD (address),A instructions to a ROM.
+func bankWrites(data []byte, offset int, address, count int) int {
+	for i := 0; i < count; i++ {
+		copy(data[offset:], []byte{0x32, byte(address), byte(address >> 8)})
+		offset += 3
+	}
+	return offset
+}
+
+func TestAnalyze(t *testing.T) {
+	info, err := Analyze(testROM(0x8000))
+	assert.NoError(t, err)
+	assert.Equal(t, Info{Size: 0x8000, Address: 0x4000, Init: 0x4010, Mapper: MapperNone, MapperReason: "plain ROM"}, info)
+
+	// a 64 KB ROM starting in page 0
+	data := make([]byte, 0x10000)
+	copy(data[0x4000:], testROM(0x10))
+	info, err = Analyze(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 0x4000, info.Offset)
+	assert.Equal(t, MapperNone, info.Mapper)
+
+	_, err = Analyze(make([]byte, 0x4000))
+	assert.Error(t, err)
+}
+
+func TestAnalyze_Mapper(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []int
+	}{
+		{MapperKonamiSCC, []int{0x5000, 0x7000, 0x9000, 0xB000}},
+		{MapperKonami, []int{0x6000, 0x8000, 0xA000}},
+		{MapperASCII8, []int{0x6000, 0x6800, 0x7000, 0x7800}},
+		{MapperASCII16, []int{0x6000, 0x7000}},
+	}
+	for _, test := range tests {
+		data := testROM(0x20000)
+		offset := 0x100
+		for _, address := range test.addresses {
+			offset = bankWrites(data, offset, address, 3)
+		}
+		info, err := Analyze(data)
+		assert.NoError(t, err)
+		assert.Equal(t, test.name, info.Mapper)
+	}
+
+	info, err := Analyze(testROM(0x20000))
+	assert.NoError(t, err)
+	assert.Equal(t, MapperUnknown, info.Mapper)
+}
+
+func TestDecodeROM(t *testing.T) {
+	result, err := DecodeROM(testROM(0x4000), decoders.Config{Disassemble: true})
+	assert.NoError(t, err)
+	assert.Contains(t, result.Text, "INIT:     &H4010\n")
+	assert.Contains(t, result.Text, "TEXT:     none\n")
+	assert.Contains(t, result.Text, "INIT:\n4010  3E 41         LD A,41h\n")
+	assert.Contains(t, result.Text, "CALL 00A2h              ; CHPUT\n")
+	assert.Contains(t, result.Text, "L4015:\n4015  18 FE         JR L4015\n")
+
+	result, err = DecodeROM(testROM(0x4000), decoders.Config{})
+	assert.NoError(t, err)
+	assert.NotContains(t, result.Text, "Disassembly")
+}
+
+func TestDecodeROM_Basic(t *testing.T) {
+	// a BASIC ROM in page 2 with the program 10 PRINT "MSX"
+	data := make([]byte, 0x4000)
+	copy(data, []byte{'A', 'B', 0, 0, 0, 0, 0, 0, 0x10, 0x80})
+	copy(data[0x10:], []byte{0x00, 0x1D, 0x80, 0x0A, 0x00, 0x91, ' ', '"', 'M', 'S', 'X', '"', 0x00, 0x00, 0x00})
+
+	result, err := DecodeROM(data, decoders.Config{})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Warnings)
+	assert.Contains(t, result.Text, "ROM:      16 KB, header at offset &H0000 mapped at &H8000\n")
+	assert.Contains(t, result.Text, "BASIC program at &H8010:\n10 PRINT \"MSX\"\n")
+}
+
+func TestDecodeROM_BrokenBasic(t *testing.T) {
+	// TEXT points to a string that is not terminated
+	result, err := DecodeROM([]byte("AB00000\"\x00@000000"), decoders.Config{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Warnings, 1)
+	assert.NotContains(t, result.Text, "BASIC program at")
+}
@@ -0,0 +1,533 @@
+package wbass2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"msxconverter/decoders"
+	"msxconverter/fileutils"
+	"path/filepath"
+	"strings"
+)
+
+// registers indexes
+const (
+	regA = iota
+	regB
+	regC
+	regD
+	regE
+	regH
+	regL
+	regI
+	regR
+	regBC
+	regDE
+	regHL
+	regSP
+	regIX
+	regIY
+	regAF
+)
+
+// maximum nesting of INCLUDE files
+const maxIncludeDepth = 16
+
+type operandKind int
+
+const (
+	operandValue     operandKind = iota // n or nn
+	operandRegister                     // A, BC, IX, ...
+	operandCondition                    // NZ, Z, NC, ...
+	operandAddress                      // (nn)
+	operandIndirect                     // (BC), (DE), (HL), (SP), (C)
+	operandIndexed                      // (IX+d), (IY+d)
+)
+
+type operand struct {
+	kind  operandKind
+	reg   int
+	expr  []token
+	value []token // all tokens of the operand, used by DB/DM
+}
+
+// source is a tokenized WBASS2 file taking part in the assembly.
+type source struct {
+	name     string
+	data     []byte
+	beglabel int
+	lines    []sourceLine
+}
+
+// assembler is a two-pass Z80 assembler working on the WBASS2 token stream.
+// The first pass collects the label values, the second pass generates code.
+type assembler struct {
+	symbols  map[string]int
+	defined  map[string]bool
+	sources  map[string]*source
+	loaded   []*source // the sources in the order they were read
+	readFile func(name string) ([]byte, error)
+	final    bool
+	tolerant bool // skip lines with errors, used when only the symbols are needed
+	lostPC   bool // the address is unknown after a skipped line
+	ended    bool
+	depth    int
+	pc       int
+	exec     int
+	memory   [0x10000]byte
+	low      int
+	high     int
+}
+
+// AssembleWBASS2 assembles a WBASS2 source file to a BSAVE binary, or a raw
+// binary when config.Assemble is "raw". INCLUDE files are read from the
+// directory of config.InputFileName.
+func AssembleWBASS2(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	dir := filepath.Dir(config.InputFileName)
+	code, begin, exec, err := Assemble(data, filepath.Base(config.InputFileName), func(name string) ([]byte, error) {
+		return readInclude(dir, name)
+	})
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	var buffer bytes.Buffer
+	if config.Assemble != "raw" {
+		header := make([]byte, 7)
+		header[0] = 0xFE
+		binary.LittleEndian.PutUint16(header[1:3], uint16(begin))
+		binary.LittleEndian.PutUint16(header[3:5], uint16(begin+len(code)-1))
+		binary.LittleEndian.PutUint16(header[5:7], uint16(exec))
+		buffer.Write(header)
+	}
+	buffer.Write(code)
+
+	return decoders.DecoderResult{Buffer: &buffer, Extension: ".bin"}, nil
+}
+
+// Assemble assembles a WBASS2 source file and returns the generated code, its
+// begin address and the execution address (the first ORG).
+func Assemble(data []byte, name string, readFile func(name string) ([]byte, error)) ([]byte, int, int, error) {
+	a := &assembler{
+		symbols:  map[string]int{},
+		sources:  map[string]*source{},
+		readFile: readFile,
+	}
+
+	main, err := a.load(name, data)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if err := a.run(main); err != nil {
+		return nil, 0, 0, err
+	}
+
+	if a.high <= a.low {
+		return nil, 0, 0, errors.New("no code generated")
+	}
+	if a.exec < 0 {
+		a.exec = a.low
+	}
+
+	return a.memory[a.low:a.high], a.low, a.exec, nil
+}
+
+// run performs both assembler passes on the main source.
+func (a *assembler) run(main *source) error {
+	for pass := 1; pass <= 2; pass++ {
+		a.final = pass == 2
+		a.defined = map[string]bool{}
+		a.ended = false
+		a.lostPC = false
+		a.pc = 0
+		a.exec = -1
+		a.low = 0x10000
+		a.high = 0
+		if err := a.assembleSource(main); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readInclude(dir, name string) ([]byte, error) {
+	if len(name) > 2 && name[1] == ':' { // strip MSX-DOS drive letter
+		name = name[2:]
+	}
+	if filepath.Ext(name) == "" {
+		name += ".WB2"
+	}
+	for _, candidate := range []string{name, strings.ToUpper(name), strings.ToLower(name)} {
+		if data, err := fileutils.ReadInput(filepath.Join(dir, candidate)); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("include file %s not found", name)
+}
+
+func (a *assembler) load(name string, data []byte) (*source, error) {
+	if src, ok := a.sources[name]; ok {
+		return src, nil
+	}
+
+	lines, beglabel, err := readLines(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	src := &source{name: name, data: data, beglabel: beglabel, lines: lines}
+	a.sources[name] = src
+	a.loaded = append(a.loaded, src)
+	return src, nil
+}
+
+func (a *assembler) assembleSource(src *source) error {
+	for _, line := range src.lines {
+		if a.ended {
+			break
+		}
+		if err := a.assembleLine(src, line); err != nil {
+			if a.tolerant {
+				a.lostPC = true
+				continue
+			}
+			return fmt.Errorf("%s:%d: %w", src.name, line.number, err)
+		}
+	}
+	return nil
+}
+
+func (a *assembler) assembleLine(src *source, line sourceLine) error {
+	mnemonic := ""
+	if line.instruction >= 0 && line.instruction < len(instructions) {
+		mnemonic = instructions[line.instruction]
+	}
+
+	ops, err := splitOperands(line.operands)
+	if err != nil {
+		return err
+	}
+
+	if line.label >= 0 && mnemonic != "EQU" && mnemonic != "ORG" && !a.lostPC {
+		if err := a.define(src, line.label, a.pc); err != nil {
+			return err
+		}
+	}
+
+	switch mnemonic {
+	case "", "GLOBAL":
+		return nil
+
+	case "ORG":
+		if len(ops) != 1 {
+			return errors.New("ORG needs one operand")
+		}
+		value, err := a.evaluateStrict(src, ops[0].value)
+		if err != nil {
+			return err
+		}
+		a.pc = value & 0xFFFF
+		a.lostPC = false
+		if a.exec < 0 {
+			a.exec = a.pc
+		}
+		if line.label >= 0 {
+			return a.define(src, line.label, a.pc)
+		}
+		return nil
+
+	case "EQU":
+		if line.label < 0 {
+			return errors.New("EQU without label")
+		}
+		if len(ops) != 1 {
+			return errors.New("EQU needs one operand")
+		}
+		value, err := a.evaluateStrict(src, ops[0].value)
+		if errors.Is(err, errUndefinedLabel) && !a.final {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return a.define(src, line.label, value)
+
+	case "END":
+		a.ended = true
+		return nil
+
+	case "DB", "DEFB", "DM", "DEFM":
+		for _, op := range ops {
+			if len(op.value) == 1 && op.value[0].kind == tokenString {
+				a.emit(op.value[0].text...)
+				continue
+			}
+			value, err := a.byteValue(src, op.value)
+			if err != nil {
+				return err
+			}
+			a.emit(value)
+		}
+		return nil
+
+	case "DW", "DEFW":
+		for _, op := range ops {
+			value, err := a.wordValue(src, op.value)
+			if err != nil {
+				return err
+			}
+			a.emit(value...)
+		}
+		return nil
+
+	case "DS", "DEFS":
+		if len(ops) < 1 || len(ops) > 2 {
+			return errors.New("DS needs a size and an optional fill value")
+		}
+		size, err := a.evaluateStrict(src, ops[0].value)
+		if err != nil {
+			return err
+		}
+		if size < 0 || size > 0x10000 {
+			return fmt.Errorf("invalid DS size %d", size)
+		}
+		var fill byte
+		if len(ops) == 2 {
+			if fill, err = a.byteValue(src, ops[1].value); err != nil {
+				return err
+			}
+		}
+		a.emit(bytes.Repeat([]byte{fill}, size)...)
+		return nil
+
+	case "INCLUDE":
+		if len(ops) != 1 || len(ops[0].value) != 1 || ops[0].value[0].kind != tokenString {
+			return errors.New("INCLUDE needs a quoted file name")
+		}
+		return a.include(string(ops[0].value[0].text))
+	}
+
+	code, err := a.encode(src, mnemonic, ops)
+	if err != nil {
+		return err
+	}
+	a.emit(code...)
+	return nil
+}
+
+func (a *assembler) include(name string) error {
+	if a.readFile == nil {
+		return fmt.Errorf("cannot include %s", name)
+	}
+	if a.depth >= maxIncludeDepth {
+		return fmt.Errorf("INCLUDE nested too deeply at %s", name)
+	}
+
+	src, ok := a.sources[name]
+	if !ok {
+		data, err := a.readFile(name)
+		if err != nil {
+			return err
+		}
+		if src, err = a.load(name, data); err != nil {
+			return err
+		}
+	}
+
+	a.depth++
+	defer func() { a.depth-- }()
+	return a.assembleSource(src)
+}
+
+func (a *assembler) define(src *source, label, value int) error {
+	record, ok := readLabel(src.data, src.beglabel, label)
+	if !ok {
+		return fmt.Errorf("label #%d outside the label table", label)
+	}
+	name := record.name
+	if name == "" {
+		return fmt.Errorf("label #%d has no name", label)
+	}
+	if a.defined[name] {
+		if a.tolerant {
+			return nil
+		}
+		return fmt.Errorf("duplicate label %s", name)
+	}
+	a.defined[name] = true
+	a.symbols[name] = value
+	return nil
+}
+
+func (a *assembler) emit(code ...byte) {
+	for _, b := range code {
+		addr := a.pc & 0xFFFF
+		if a.final {
+			a.memory[addr] = b
+			a.low = min(a.low, addr)
+			a.high = max(a.high, addr+1)
+		}
+		a.pc = addr + 1
+	}
+}
+
+// evaluateStrict evaluates an expression, failing on undefined labels even
+// in the first pass. Used where the value changes the size of the code.
+func (a *assembler) evaluateStrict(src *source, expr []token) (int, error) {
+	e := expression{
+		tokens: expr,
+		pc:     a.pc,
+		lookup: func(label int) (int, error) {
+			name := labelName(src.data, src.beglabel, label)
+			if value, ok := a.symbols[name]; ok {
+				return value, nil
+			}
+			return 0, fmt.Errorf("%w %s", errUndefinedLabel, name)
+		},
+	}
+	return e.evaluate()
+}
+
+// evaluate evaluates an expression; in the first pass undefined labels are
+// taken as zero.
+func (a *assembler) evaluate(src *source, expr []token) (int, error) {
+	value, err := a.evaluateStrict(src, expr)
+	if errors.Is(err, errUndefinedLabel) && (!a.final || a.tolerant) {
+		return 0, nil
+	}
+	return value, err
+}
+
+func (a *assembler) byteValue(src *source, expr []token) (byte, error) {
+	value, err := a.evaluate(src, expr)
+	if err != nil {
+		return 0, err
+	}
+	if value < -128 || value > 255 {
+		return 0, fmt.Errorf("value %d out of byte range", value)
+	}
+	return byte(value), nil
+}
+
+func (a *assembler) wordValue(src *source, expr []token) ([]byte, error) {
+	value, err := a.evaluate(src, expr)
+	if err != nil {
+		return nil, err
+	}
+	if value < -32768 || value > 65535 {
+		return nil, fmt.Errorf("value %d out of word range", value)
+	}
+	return []byte{byte(value), byte(value >> 8)}, nil
+}
+
+// relative returns the displacement of a JR or DJNZ instruction.
+func (a *assembler) relative(src *source, expr []token) (byte, error) {
+	target, err := a.evaluate(src, expr)
+	if err != nil {
+		return 0, err
+	}
+	offset := target - (a.pc + 2)
+	if a.final && (offset < -128 || offset > 127) {
+		return 0, fmt.Errorf("relative jump out of range (%d)", offset)
+	}
+	return byte(offset), nil
+}
+
+// splitOperands splits the operand tokens on commas outside parentheses and
+// classifies each operand.
+func splitOperands(tokens []token) ([]operand, error) {
+	var ops []operand
+	if len(tokens) == 0 {
+		return ops, nil
+	}
+
+	depth := 0
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && tokens[i].kind == tokenChar {
+			switch tokens[i].value {
+			case charOpen:
+				depth++
+			case charClose:
+				depth--
+			}
+			if depth != 0 || tokens[i].value != charComma {
+				continue
+			}
+		} else if i < len(tokens) {
+			continue
+		}
+
+		if start == i {
+			return nil, errors.New("missing operand")
+		}
+		ops = append(ops, classifyOperand(tokens[start:i]))
+		start = i + 1
+	}
+
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return ops, nil
+}
+
+func classifyOperand(tokens []token) operand {
+	op := operand{kind: operandValue, expr: tokens, value: tokens}
+
+	if len(tokens) == 1 {
+		switch t := tokens[0]; t.kind {
+		case tokenRegister:
+			op.kind = operandRegister
+			op.reg = t.value
+		case tokenCondition:
+			if t.value != conditionDollar {
+				op.kind = operandCondition
+				op.reg = t.value
+			}
+		}
+		return op
+	}
+
+	last := len(tokens) - 1
+	if !isChar(tokens[0], charOpen) || !isChar(tokens[last], charClose) {
+		return op
+	}
+
+	// the outer parentheses must belong together, (1+2)*(3) is a value
+	depth := 0
+	for i, t := range tokens {
+		if isChar(t, charOpen) {
+			depth++
+		} else if isChar(t, charClose) {
+			depth--
+			if depth == 0 && i != last {
+				return op
+			}
+		}
+	}
+
+	inner := tokens[1:last]
+	if len(inner) > 0 && inner[0].kind == tokenRegister {
+		reg := inner[0].value
+		if reg == regIX || reg == regIY {
+			op.kind = operandIndexed
+			op.reg = reg
+			op.expr = inner[1:]
+			return op
+		}
+		if len(inner) == 1 {
+			op.kind = operandIndirect
+			op.reg = reg
+			return op
+		}
+	}
+
+	op.kind = operandAddress
+	op.expr = inner
+	return op
+}
+
+func isChar(t token, char int) bool {
+	return t.kind == tokenChar && t.value == char
+}
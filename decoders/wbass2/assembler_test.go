@@ -0,0 +1,136 @@
+package wbass2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// helpers to build tokenized WBASS2 lines
+func ins(name string) byte {
+	for i, s := range instructions {
+		if s == name {
+			return byte(128 + i)
+		}
+	}
+	panic("unknown instruction " + name)
+}
+
+func reg(name string) byte {
+	for i, s := range registers {
+		if s == name {
+			return byte(128 + i)
+		}
+	}
+	panic("unknown register " + name)
+}
+
+func num(n int) []byte { return []byte{0xE1, byte(n), byte(n >> 8)} }
+func lbl(n int) []byte { return []byte{0xC0, byte(n), byte(n >> 8)} }
+
+func line(label int, tokens ...[]byte) []byte {
+	var body []byte
+	for _, t := range tokens {
+		body = append(body, t...)
+	}
+	if label < 0 {
+		return append([]byte{byte(len(body))}, body...)
+	}
+	return append([]byte{byte(len(body)+2) | 128, byte(label), byte(label >> 8)}, body...)
+}
+
+func program(labels []string, lines ...[]byte) []byte {
+	data := []byte{0xFD}
+	for _, l := range lines {
+		data = append(data, l...)
+	}
+	data = append(data, 0xFF)
+	for _, name := range labels {
+		entry := make([]byte, 8)
+		copy(entry, name)
+		data = append(data, entry...)
+	}
+	return data
+}
+
+const (
+	tComma = 2
+	tOpen  = 6
+	tClose = 4
+	tPlus  = 8
+)
+
+func TestAssemble(t *testing.T) {
+	data := program([]string{"START", "LOOP"},
+		line(-1, []byte{ins("ORG")}, num(0x9000)),
+		line(0, []byte{ins("LD"), reg("A"), tComma}, num(1)),
+		line(1, []byte{ins("DJNZ")}, lbl(1)),
+		line(-1, []byte{ins("JP")}, lbl(0)),
+		line(-1, []byte{ins("LD"), tOpen, reg("IX"), tPlus}, num(5), []byte{tClose, tComma, reg("A")}),
+		line(-1, []byte{ins("DB"), 34, 'A', 'B', 34, tComma}, num(3)),
+	)
+
+	code, begin, exec, err := Assemble(data, "TEST.WB2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0x9000, begin)
+	assert.Equal(t, 0x9000, exec)
+	assert.Equal(t, []byte{
+		0x3E, 0x01,
+		0x10, 0xFE,
+		0xC3, 0x00, 0x90,
+		0xDD, 0x77, 0x05,
+		0x41, 0x42, 0x03,
+	}, code)
+}
+
+func TestAssemble_ForwardReferenceAndEqu(t *testing.T) {
+	data := program([]string{"VALUE", "NEXT"},
+		line(-1, []byte{ins("ORG")}, num(0xC000)),
+		line(-1, []byte{ins("JR")}, lbl(1)),
+		line(-1, []byte{ins("LD"), reg("HL"), tComma}, lbl(0)),
+		line(1, []byte{ins("RET")}),
+		line(0, []byte{ins("EQU")}, num(0x1234)),
+	)
+
+	code, _, _, err := Assemble(data, "TEST.WB2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x18, 0x03, 0x21, 0x34, 0x12, 0xC9}, code)
+}
+
+func TestAssemble_Include(t *testing.T) {
+	lib := program([]string{"LIB"},
+		line(0, []byte{ins("RET")}),
+	)
+	data := program([]string{"LIB"},
+		line(-1, []byte{ins("ORG")}, num(0xC000)),
+		line(-1, []byte{ins("CALL")}, lbl(0)),
+		line(-1, []byte{ins("INCLUDE"), 34, 'L', 'I', 'B', 34}),
+	)
+
+	code, _, _, err := Assemble(data, "MAIN.WB2", func(name string) ([]byte, error) {
+		assert.Equal(t, "LIB", name)
+		return lib, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xCD, 0x03, 0xC0, 0xC9}, code)
+}
+
+func TestAssemble_BadToken(t *testing.T) {
+	data := program(nil,
+		line(-1, []byte{ins("LD"), reg("A"), tComma, 0xA0}),
+	)
+
+	_, _, _, err := Assemble(data, "TEST.WB2", nil)
+	assert.ErrorContains(t, err, "line 1: bad token A0")
+	assert.Equal(t, "?", token{kind: tokenLogic, value: 0xA0 - 153}.String())
+	assert.Equal(t, "?", token{kind: tokenChar, value: 12}.String())
+}
+
+func TestAssemble_UndefinedLabel(t *testing.T) {
+	data := program([]string{"NOPE"},
+		line(-1, []byte{ins("JP")}, lbl(0)),
+	)
+
+	_, _, _, err := Assemble(data, "TEST.WB2", nil)
+	assert.ErrorContains(t, err, "TEST.WB2:1: undefined label NOPE")
+}
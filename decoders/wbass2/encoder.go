@@ -0,0 +1,510 @@
+package wbass2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 8-bit register codes as used in the Z80 opcodes
+var reg8Codes = map[int]byte{regB: 0, regC: 1, regD: 2, regE: 3, regH: 4, regL: 5, regA: 7}
+
+// 16-bit register pair codes, SP and AF share code 3
+var reg16Codes = map[int]byte{regBC: 0, regDE: 1, regHL: 2, regSP: 3}
+
+// alu operation codes for the ADD A,r .. CP r group
+var aluCodes = map[string]byte{"ADD": 0, "ADC": 1, "SUB": 2, "SBC": 3, "AND": 4, "XOR": 5, "OR": 6, "CP": 7}
+
+// rotate and shift codes for the CB group, ??? is the undocumented SLL
+var rotateCodes = map[string]byte{"RLC": 0, "RRC": 1, "RL": 2, "RR": 3, "SLA": 4, "SRA": 5, "???": 6, "SRL": 7}
+
+// instructions without operands
+var impliedCodes = map[string][]byte{
+	"CPD": {0xED, 0xA9}, "CPDR": {0xED, 0xB9}, "CPI": {0xED, 0xA1}, "CPIR": {0xED, 0xB1},
+	"IND": {0xED, 0xAA}, "INDR": {0xED, 0xBA}, "INI": {0xED, 0xA2}, "INIR": {0xED, 0xB2},
+	"LDD": {0xED, 0xA8}, "LDDR": {0xED, 0xB8}, "LDI": {0xED, 0xA0}, "LDIR": {0xED, 0xB0},
+	"OUTD": {0xED, 0xAB}, "OTDR": {0xED, 0xBB}, "OUTI": {0xED, 0xA3}, "OTIR": {0xED, 0xB3},
+	"NEG": {0xED, 0x44}, "RETI": {0xED, 0x4D}, "RETN": {0xED, 0x45}, "RLD": {0xED, 0x6F},
+	"RRD": {0xED, 0x67}, "CCF": {0x3F}, "CPL": {0x2F}, "DAA": {0x27}, "DI": {0xF3},
+	"EI": {0xFB}, "EXX": {0xD9}, "HALT": {0x76}, "NOP": {0x00}, "RLA": {0x17},
+	"RLCA": {0x07}, "RRA": {0x1F}, "RRCA": {0x0F}, "SCF": {0x37},
+}
+
+var errOperands = errors.New("invalid operands")
+
+// reg8 is an encoded 8-bit operand: B, C, D, E, H, L, A, (HL) or (IX+d).
+type reg8 struct {
+	code   byte
+	prefix []byte
+	disp   []byte
+}
+
+func indexPrefix(reg int) []byte {
+	if reg == regIX {
+		return []byte{0xDD}
+	}
+	return []byte{0xFD}
+}
+
+func isReg(op operand, reg int) bool {
+	return op.kind == operandRegister && op.reg == reg
+}
+
+func isIndirect(op operand, reg int) bool {
+	return op.kind == operandIndirect && op.reg == reg
+}
+
+func isIndex(op operand) bool {
+	return op.kind == operandRegister && (op.reg == regIX || op.reg == regIY)
+}
+
+func (a *assembler) reg8(src *source, op operand) (reg8, bool, error) {
+	switch op.kind {
+	case operandRegister:
+		if code, ok := reg8Codes[op.reg]; ok {
+			return reg8{code: code}, true, nil
+		}
+	case operandIndirect:
+		if op.reg == regHL {
+			return reg8{code: 6}, true, nil
+		}
+	case operandIndexed:
+		disp := 0
+		if len(op.expr) > 0 {
+			var err error
+			if disp, err = a.evaluate(src, op.expr); err != nil {
+				return reg8{}, false, err
+			}
+		}
+		if a.final && (disp < -128 || disp > 127) {
+			return reg8{}, false, fmt.Errorf("index offset %d out of range", disp)
+		}
+		return reg8{code: 6, prefix: indexPrefix(op.reg), disp: []byte{byte(disp)}}, true, nil
+	}
+	return reg8{}, false, nil
+}
+
+// reg16 encodes BC, DE, HL, SP or IX/IY (as HL with a prefix). When push is
+// set AF takes the place of SP.
+func reg16(op operand, push bool) (byte, []byte, bool) {
+	if op.kind != operandRegister {
+		return 0, nil, false
+	}
+	switch op.reg {
+	case regIX, regIY:
+		return 2, indexPrefix(op.reg), true
+	case regSP:
+		return 3, nil, !push
+	case regAF:
+		return 3, nil, push
+	}
+	code, ok := reg16Codes[op.reg]
+	return code, nil, ok
+}
+
+// condition returns the condition code, the register C is accepted for the
+// carry condition.
+func condition(op operand) (byte, bool) {
+	if op.kind == operandCondition && op.reg < conditionDollar {
+		return byte(op.reg), true
+	}
+	if isReg(op, regC) {
+		return 3, true
+	}
+	return 0, false
+}
+
+func join(parts ...[]byte) []byte {
+	var code []byte
+	for _, part := range parts {
+		code = append(code, part...)
+	}
+	return code
+}
+
+// encode generates the machine code of a single Z80 instruction.
+func (a *assembler) encode(src *source, mnemonic string, ops []operand) ([]byte, error) {
+	if code, ok := impliedCodes[mnemonic]; ok {
+		if len(ops) != 0 {
+			return nil, errOperands
+		}
+		return code, nil
+	}
+
+	if alu, ok := aluCodes[mnemonic]; ok {
+		return a.encodeALU(src, mnemonic, alu, ops)
+	}
+
+	if rotate, ok := rotateCodes[mnemonic]; ok {
+		if len(ops) != 1 {
+			return nil, errOperands
+		}
+		r, ok, err := a.reg8(src, ops[0])
+		if err != nil || !ok {
+			return nil, orOperands(err)
+		}
+		return join(r.prefix, []byte{0xCB}, r.disp, []byte{rotate<<3 | r.code}), nil
+	}
+
+	switch mnemonic {
+	case "LD":
+		if len(ops) != 2 {
+			return nil, errOperands
+		}
+		return a.encodeLD(src, ops[0], ops[1])
+
+	case "JR", "DJNZ":
+		target := ops
+		opcode := byte(0x18)
+		if mnemonic == "DJNZ" {
+			opcode = 0x10
+		} else if len(ops) == 2 {
+			cc, ok := condition(ops[0])
+			if !ok || cc > 3 {
+				return nil, errOperands
+			}
+			opcode = 0x20 | cc<<3
+			target = ops[1:]
+		}
+		if len(target) != 1 || target[0].kind != operandValue {
+			return nil, errOperands
+		}
+		e, err := a.relative(src, target[0].expr)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{opcode, e}, nil
+
+	case "CALL", "JP":
+		if mnemonic == "JP" && len(ops) == 1 {
+			if isIndirect(ops[0], regHL) {
+				return []byte{0xE9}, nil
+			}
+			if ops[0].kind == operandIndexed && len(ops[0].expr) == 0 {
+				return join(indexPrefix(ops[0].reg), []byte{0xE9}), nil
+			}
+		}
+		opcode := byte(0xCD)
+		if mnemonic == "JP" {
+			opcode = 0xC3
+		}
+		target := ops
+		if len(ops) == 2 {
+			cc, ok := condition(ops[0])
+			if !ok {
+				return nil, errOperands
+			}
+			opcode = 0xC4 | cc<<3
+			if mnemonic == "JP" {
+				opcode = 0xC2 | cc<<3
+			}
+			target = ops[1:]
+		}
+		if len(target) != 1 || target[0].kind != operandValue {
+			return nil, errOperands
+		}
+		nn, err := a.wordValue(src, target[0].expr)
+		if err != nil {
+			return nil, err
+		}
+		return join([]byte{opcode}, nn), nil
+
+	case "RET":
+		if len(ops) == 0 {
+			return []byte{0xC9}, nil
+		}
+		cc, ok := condition(ops[0])
+		if len(ops) != 1 || !ok {
+			return nil, errOperands
+		}
+		return []byte{0xC0 | cc<<3}, nil
+
+	case "INC", "DEC":
+		if len(ops) != 1 {
+			return nil, errOperands
+		}
+		dec := byte(0)
+		if mnemonic == "DEC" {
+			dec = 1
+		}
+		if code, prefix, ok := reg16(ops[0], false); ok {
+			return join(prefix, []byte{0x03 | code<<4 | dec<<3}), nil
+		}
+		r, ok, err := a.reg8(src, ops[0])
+		if err != nil || !ok {
+			return nil, orOperands(err)
+		}
+		return join(r.prefix, []byte{0x04 | r.code<<3 | dec}, r.disp), nil
+
+	case "PUSH", "POP":
+		if len(ops) != 1 {
+			return nil, errOperands
+		}
+		code, prefix, ok := reg16(ops[0], true)
+		if !ok {
+			return nil, errOperands
+		}
+		opcode := byte(0xC5)
+		if mnemonic == "POP" {
+			opcode = 0xC1
+		}
+		return join(prefix, []byte{opcode | code<<4}), nil
+
+	case "RST":
+		if len(ops) != 1 || ops[0].kind != operandValue {
+			return nil, errOperands
+		}
+		n, err := a.evaluate(src, ops[0].expr)
+		if err != nil {
+			return nil, err
+		}
+		if n&^0x38 != 0 {
+			return nil, fmt.Errorf("invalid RST address %d", n)
+		}
+		return []byte{0xC7 | byte(n)}, nil
+
+	case "IN":
+		if len(ops) != 2 {
+			return nil, errOperands
+		}
+		if isReg(ops[0], regA) && ops[1].kind == operandAddress {
+			n, err := a.byteValue(src, ops[1].expr)
+			if err != nil {
+				return nil, err
+			}
+			return []byte{0xDB, n}, nil
+		}
+		if code, ok := reg8Codes[ops[0].reg]; ok && ops[0].kind == operandRegister && isIndirect(ops[1], regC) {
+			return []byte{0xED, 0x40 | code<<3}, nil
+		}
+		return nil, errOperands
+
+	case "OUT":
+		if len(ops) != 2 {
+			return nil, errOperands
+		}
+		if ops[0].kind == operandAddress && isReg(ops[1], regA) {
+			n, err := a.byteValue(src, ops[0].expr)
+			if err != nil {
+				return nil, err
+			}
+			return []byte{0xD3, n}, nil
+		}
+		if code, ok := reg8Codes[ops[1].reg]; ok && ops[1].kind == operandRegister && isIndirect(ops[0], regC) {
+			return []byte{0xED, 0x41 | code<<3}, nil
+		}
+		return nil, errOperands
+
+	case "IM":
+		if len(ops) != 1 || ops[0].kind != operandValue {
+			return nil, errOperands
+		}
+		mode, err := a.evaluate(src, ops[0].expr)
+		if err != nil {
+			return nil, err
+		}
+		switch mode {
+		case 0:
+			return []byte{0xED, 0x46}, nil
+		case 1:
+			return []byte{0xED, 0x56}, nil
+		case 2:
+			return []byte{0xED, 0x5E}, nil
+		}
+		return nil, fmt.Errorf("invalid interrupt mode %d", mode)
+
+	case "EX":
+		if len(ops) != 2 {
+			return nil, errOperands
+		}
+		switch {
+		case isReg(ops[0], regDE) && isReg(ops[1], regHL):
+			return []byte{0xEB}, nil
+		case isReg(ops[0], regAF) && isReg(ops[1], regAF):
+			return []byte{0x08}, nil
+		case isIndirect(ops[0], regSP) && isReg(ops[1], regHL):
+			return []byte{0xE3}, nil
+		case isIndirect(ops[0], regSP) && isIndex(ops[1]):
+			return join(indexPrefix(ops[1].reg), []byte{0xE3}), nil
+		}
+		return nil, errOperands
+
+	case "BIT", "RES", "SET":
+		if len(ops) != 2 || ops[0].kind != operandValue {
+			return nil, errOperands
+		}
+		bit, err := a.evaluate(src, ops[0].expr)
+		if err != nil {
+			return nil, err
+		}
+		if bit < 0 || bit > 7 {
+			return nil, fmt.Errorf("invalid bit number %d", bit)
+		}
+		r, ok, err := a.reg8(src, ops[1])
+		if err != nil || !ok {
+			return nil, orOperands(err)
+		}
+		group := map[string]byte{"BIT": 0x40, "RES": 0x80, "SET": 0xC0}[mnemonic]
+		return join(r.prefix, []byte{0xCB}, r.disp, []byte{group | byte(bit)<<3 | r.code}), nil
+	}
+
+	return nil, fmt.Errorf("unsupported instruction %s", mnemonic)
+}
+
+func orOperands(err error) error {
+	if err != nil {
+		return err
+	}
+	return errOperands
+}
+
+func (a *assembler) encodeALU(src *source, mnemonic string, alu byte, ops []operand) ([]byte, error) {
+	// 16-bit arithmetic
+	if len(ops) == 2 && ops[0].kind == operandRegister && (ops[0].reg == regHL || isIndex(ops[0])) {
+		code, prefix, ok := reg16(ops[1], false)
+		if !ok || (code == 2 && ops[0].reg != ops[1].reg) {
+			return nil, errOperands
+		}
+		switch {
+		case mnemonic == "ADD":
+			var dst []byte
+			if isIndex(ops[0]) {
+				dst = indexPrefix(ops[0].reg)
+			}
+			return join(dst, []byte{0x09 | code<<4}), nil
+		case mnemonic == "ADC" && ops[0].reg == regHL && prefix == nil:
+			return []byte{0xED, 0x4A | code<<4}, nil
+		case mnemonic == "SBC" && ops[0].reg == regHL && prefix == nil:
+			return []byte{0xED, 0x42 | code<<4}, nil
+		}
+		return nil, errOperands
+	}
+
+	// 8-bit arithmetic, the A, is optional
+	if len(ops) == 2 && isReg(ops[0], regA) {
+		ops = ops[1:]
+	}
+	if len(ops) != 1 {
+		return nil, errOperands
+	}
+
+	if ops[0].kind == operandValue {
+		n, err := a.byteValue(src, ops[0].expr)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{0xC6 | alu<<3, n}, nil
+	}
+
+	r, ok, err := a.reg8(src, ops[0])
+	if err != nil || !ok {
+		return nil, orOperands(err)
+	}
+	return join(r.prefix, []byte{0x80 | alu<<3 | r.code}, r.disp), nil
+}
+
+func (a *assembler) encodeLD(src *source, dst, from operand) ([]byte, error) {
+	// LD r,r' / LD r,n / LD r,(HL) / LD (IX+d),n ...
+	d, dstIs8, err := a.reg8(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if dstIs8 {
+		s, srcIs8, err := a.reg8(src, from)
+		if err != nil {
+			return nil, err
+		}
+		if srcIs8 {
+			if d.code == 6 && s.code == 6 {
+				return nil, errOperands
+			}
+			return join(d.prefix, s.prefix, []byte{0x40 | d.code<<3 | s.code}, d.disp, s.disp), nil
+		}
+		if from.kind == operandValue {
+			n, err := a.byteValue(src, from.expr)
+			if err != nil {
+				return nil, err
+			}
+			return join(d.prefix, []byte{0x06 | d.code<<3}, d.disp, []byte{n}), nil
+		}
+	}
+
+	if isReg(dst, regA) {
+		switch {
+		case isIndirect(from, regBC):
+			return []byte{0x0A}, nil
+		case isIndirect(from, regDE):
+			return []byte{0x1A}, nil
+		case isReg(from, regI):
+			return []byte{0xED, 0x57}, nil
+		case isReg(from, regR):
+			return []byte{0xED, 0x5F}, nil
+		case from.kind == operandAddress:
+			nn, err := a.wordValue(src, from.expr)
+			if err != nil {
+				return nil, err
+			}
+			return join([]byte{0x3A}, nn), nil
+		}
+	}
+
+	if isReg(from, regA) {
+		switch {
+		case isReg(dst, regI):
+			return []byte{0xED, 0x47}, nil
+		case isReg(dst, regR):
+			return []byte{0xED, 0x4F}, nil
+		case isIndirect(dst, regBC):
+			return []byte{0x02}, nil
+		case isIndirect(dst, regDE):
+			return []byte{0x12}, nil
+		}
+	}
+
+	// LD (nn),A / LD (nn),HL / LD (nn),rp
+	if dst.kind == operandAddress {
+		nn, err := a.wordValue(src, dst.expr)
+		if err != nil {
+			return nil, err
+		}
+		if isReg(from, regA) {
+			return join([]byte{0x32}, nn), nil
+		}
+		code, prefix, ok := reg16(from, false)
+		if !ok {
+			return nil, errOperands
+		}
+		if code == 2 {
+			return join(prefix, []byte{0x22}, nn), nil
+		}
+		return join([]byte{0xED, 0x43 | code<<4}, nn), nil
+	}
+
+	// LD rp,nn / LD rp,(nn) / LD SP,HL
+	code, prefix, ok := reg16(dst, false)
+	if !ok {
+		return nil, errOperands
+	}
+	switch from.kind {
+	case operandValue:
+		nn, err := a.wordValue(src, from.expr)
+		if err != nil {
+			return nil, err
+		}
+		return join(prefix, []byte{0x01 | code<<4}, nn), nil
+	case operandAddress:
+		nn, err := a.wordValue(src, from.expr)
+		if err != nil {
+			return nil, err
+		}
+		if code == 2 {
+			return join(prefix, []byte{0x2A}, nn), nil
+		}
+		return join([]byte{0xED, 0x4B | code<<4}, nn), nil
+	case operandRegister:
+		if dst.reg == regSP && (from.reg == regHL || isIndex(from)) {
+			_, prefix, _ := reg16(from, false)
+			return join(prefix, []byte{0xF9}), nil
+		}
+	}
+	return nil, errOperands
+}
@@ -0,0 +1,269 @@
+package wbass2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// kartab indexes of the special characters
+const (
+	charComma = iota
+	charClose
+	charOpen
+	charPlus
+	charMinus
+	charMultiply
+	charDivide
+	charPower
+)
+
+// logies indexes of the logical operators
+const (
+	logicAnd = iota
+	logicXor
+	logicOr
+	logicMod
+)
+
+// condition index of the current address symbol "$"
+const conditionDollar = 8
+
+var errUndefinedLabel = errors.New("undefined label")
+
+// expression evaluates an operand expression built from numbers, labels,
+// strings, "$" and the kartab and logies operators.
+//
+// Precedence, from low to high: OR/XOR, AND, + -, * / MOD, ^ and unary sign.
+type expression struct {
+	tokens []token
+	pos    int
+	lookup func(label int) (int, error)
+	pc     int
+}
+
+func (e *expression) evaluate() (int, error) {
+	if len(e.tokens) == 0 {
+		return 0, errors.New("missing expression")
+	}
+	value, err := e.parseOr()
+	if err != nil {
+		return 0, err
+	}
+	if e.pos < len(e.tokens) {
+		return 0, errors.New("unexpected token in expression")
+	}
+	return value, nil
+}
+
+func (e *expression) peekLogic(ops ...int) (int, bool) {
+	if e.pos < len(e.tokens) && e.tokens[e.pos].kind == tokenLogic {
+		for _, op := range ops {
+			if e.tokens[e.pos].value == op {
+				return op, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (e *expression) peekChar(ops ...int) (int, bool) {
+	if e.pos < len(e.tokens) && e.tokens[e.pos].kind == tokenChar {
+		for _, op := range ops {
+			if e.tokens[e.pos].value == op {
+				return op, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (e *expression) parseOr() (int, error) {
+	left, err := e.parseAnd()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := e.peekLogic(logicOr, logicXor)
+		if !ok {
+			return left, nil
+		}
+		e.pos++
+		right, err := e.parseAnd()
+		if err != nil {
+			return 0, err
+		}
+		if op == logicOr {
+			left |= right
+		} else {
+			left ^= right
+		}
+	}
+}
+
+func (e *expression) parseAnd() (int, error) {
+	left, err := e.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, ok := e.peekLogic(logicAnd); !ok {
+			return left, nil
+		}
+		e.pos++
+		right, err := e.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		left &= right
+	}
+}
+
+func (e *expression) parseSum() (int, error) {
+	left, err := e.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := e.peekChar(charPlus, charMinus)
+		if !ok {
+			return left, nil
+		}
+		e.pos++
+		right, err := e.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == charPlus {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (e *expression) parseProduct() (int, error) {
+	left, err := e.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, isChar := e.peekChar(charMultiply, charDivide)
+		_, isMod := e.peekLogic(logicMod)
+		if !isChar && !isMod {
+			return left, nil
+		}
+		e.pos++
+		right, err := e.parsePower()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case isMod:
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left %= right
+		case op == charMultiply:
+			left *= right
+		default:
+			if right == 0 {
+				return 0, errors.New("division by zero")
+			}
+			left /= right
+		}
+	}
+}
+
+func (e *expression) parsePower() (int, error) {
+	base, err := e.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := e.peekChar(charPower); !ok {
+		return base, nil
+	}
+	e.pos++
+	exponent, err := e.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	result := 1
+	for ; exponent > 0; exponent-- {
+		result *= base
+	}
+	return result, nil
+}
+
+func (e *expression) parseUnary() (int, error) {
+	if op, ok := e.peekChar(charPlus, charMinus); ok {
+		e.pos++
+		value, err := e.parseUnary()
+		if op == charMinus {
+			value = -value
+		}
+		return value, err
+	}
+	return e.parsePrimary()
+}
+
+func (e *expression) parsePrimary() (int, error) {
+	if e.pos >= len(e.tokens) {
+		return 0, errors.New("unexpected end of expression")
+	}
+
+	t := e.tokens[e.pos]
+	e.pos++
+
+	switch t.kind {
+	case tokenNumber:
+		return t.value, nil
+	case tokenLabel:
+		return e.lookup(t.value)
+	case tokenCondition:
+		if t.value == conditionDollar {
+			return e.pc, nil
+		}
+	case tokenString:
+		switch len(t.text) {
+		case 1:
+			return int(t.text[0]), nil
+		case 2:
+			return int(t.text[0])<<8 | int(t.text[1]), nil
+		}
+		return 0, fmt.Errorf("string \"%s\" used in expression", t.text)
+	case tokenChar:
+		if t.value == charOpen {
+			value, err := e.parseOr()
+			if err != nil {
+				return 0, err
+			}
+			if _, ok := e.peekChar(charClose); !ok {
+				return 0, errors.New("missing )")
+			}
+			e.pos++
+			return value, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unexpected %s in expression", t)
+}
+
+// String renders a token the way it appears in the listing.
+func (t token) String() string {
+	switch t.kind {
+	case tokenRegister:
+		return lookupName(registers, t.value)
+	case tokenCondition:
+		return lookupName(condities, t.value)
+	case tokenLogic:
+		return lookupName(logies, t.value)
+	case tokenChar:
+		return lookupName(kartab, t.value)
+	case tokenString:
+		return "\"" + string(t.text) + "\""
+	case tokenLabel:
+		return fmt.Sprintf("label #%d", t.value)
+	default:
+		return fmt.Sprintf("%d", t.value)
+	}
+}
@@ -89,3 +89,21 @@ func TestHandleNumberFormats(t *testing.T) {
 	assert.Equal(t, "&B1010101010101010", buffer.String(), "Binary number format mismatch")
 
 }
+
+// an unterminated string ends at the end of its line
+func TestReadLines_UnterminatedString(t *testing.T) {
+	data := program(nil,
+		line(-1, []byte{ins("DB"), 34, 'A', 'B'}),
+		line(-1, []byte{ins("NOP")}),
+	)
+
+	lines, _, err := readLines(data)
+	assert.NoError(t, err)
+	assert.Len(t, lines, 2)
+	assert.Equal(t, []token{{kind: tokenString, text: []byte("AB")}}, lines[0].operands)
+	assert.Equal(t, int(ins("NOP")-128), lines[1].instruction)
+
+	result, err := DecodeWBASS2(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "        DB    \"AB\n        NOP\n", result.Text)
+}
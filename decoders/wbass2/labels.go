@@ -0,0 +1,110 @@
+package wbass2
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// labelRecord is an 8-byte entry of the WBASS2 label table. The first six
+// bytes hold the name, padded with zeros when it is shorter, so names are
+// at most six characters. The characters are 7-bit, bit 7 of the name bytes
+// is masked off; the last two bytes are not used by the decoder.
+type labelRecord struct {
+	name string
+}
+
+const (
+	labelRecordSize = 8
+	labelNameSize   = 6
+)
+
+// readLabel decodes a record of the label table. It returns false when the
+// label index is outside the table.
+func readLabel(data []byte, beglabel, label int) (labelRecord, bool) {
+	start := beglabel + labelRecordSize*label
+	if beglabel < 0 || label < 0 || start+labelRecordSize > len(data) {
+		return labelRecord{}, false
+	}
+
+	record := data[start : start+labelRecordSize]
+	var name bytes.Buffer
+	for i := 0; i < labelNameSize; i++ {
+		if record[i]&127 != 0 && name.Len() == i {
+			name.WriteByte(record[i] & 127)
+		}
+	}
+
+	return labelRecord{name: name.String()}, true
+}
+
+// labelCount returns the number of records in the label table.
+func labelCount(data []byte, beglabel int) int {
+	if beglabel < 0 || beglabel > len(data) {
+		return 0
+	}
+	return (len(data) - beglabel) / labelRecordSize
+}
+
+// labelName returns the name of a label from the label table, labels outside
+// the table are named after their index.
+func labelName(data []byte, beglabel, label int) string {
+	record, ok := readLabel(data, beglabel, label)
+	if !ok {
+		return fmt.Sprintf("LABEL%d", label)
+	}
+	return record.name
+}
+
+// checkLabels reports label references outside the label table, labels
+// that are used but not defined in the file and labels that are defined more
+// than once, so a corrupted file gives warnings instead of a broken listing.
+func checkLabels(data []byte) []string {
+	lines, beglabel, err := readLines(data)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var warnings []string
+	count := labelCount(data, beglabel)
+	defined := make([][]int, count)
+	used := make([][]int, count)
+	for _, line := range lines {
+		if line.label >= 0 {
+			if line.label >= count {
+				warnings = append(warnings, fmt.Sprintf("line %d: label #%d outside the label table", line.number, line.label))
+			} else {
+				defined[line.label] = append(defined[line.label], line.number)
+			}
+		}
+		for _, t := range line.operands {
+			if t.kind != tokenLabel {
+				continue
+			}
+			if t.value >= count {
+				warnings = append(warnings, fmt.Sprintf("line %d: label #%d outside the label table", line.number, t.value))
+			} else {
+				used[t.value] = append(used[t.value], line.number)
+			}
+		}
+	}
+
+	names := map[string]int{}
+	for i := 0; i < count; i++ {
+		name := labelName(data, beglabel, i)
+		switch {
+		case name == "" && (len(defined[i]) > 0 || len(used[i]) > 0):
+			warnings = append(warnings, fmt.Sprintf("label #%d has no name", i))
+		case len(defined[i]) > 1:
+			warnings = append(warnings, fmt.Sprintf("label %s defined more than once (lines %s)", name, joinLines(defined[i])))
+		case len(defined[i]) == 0 && len(used[i]) > 0:
+			warnings = append(warnings, fmt.Sprintf("label %s used but not defined (lines %s)", name, joinLines(used[i])))
+		}
+		if first, ok := names[name]; ok && name != "" {
+			warnings = append(warnings, fmt.Sprintf("labels #%d and #%d are both named %s", first, i, name))
+		} else {
+			names[name] = i
+		}
+	}
+
+	return warnings
+}
@@ -0,0 +1,42 @@
+package wbass2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLabel(t *testing.T) {
+	data := []byte{0xFD, 0xFF, 'L' | 0x80, 'O', 'O', 'P', 0x00, 0x00, 0x34, 0x12}
+
+	record, ok := readLabel(data, 2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, labelRecord{name: "LOOP"}, record)
+
+	_, ok = readLabel(data, 2, 1)
+	assert.False(t, ok, "label outside the table")
+}
+
+func TestDecodeWBASS2_CorruptLabels(t *testing.T) {
+	data := program([]string{"START", "START"},
+		line(0, []byte{ins("JP")}, lbl(7)),
+		line(-1, []byte{ins("JP")}, lbl(1)),
+	)
+
+	result, err := DecodeWBASS2(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "START:  JP    LABEL7\n        JP    START\n", result.Text)
+	assert.Equal(t, []string{
+		"line 1: label #7 outside the label table",
+		"label START used but not defined (lines 2)",
+		"labels #0 and #1 are both named START",
+	}, result.Warnings)
+}
+
+func TestDecodeWBASS2_TruncatedLine(t *testing.T) {
+	data := []byte{0xFD, 0x06, 0x80, 0x80, 0x02, 0xE0}
+
+	assert.NotPanics(t, func() {
+		_, _ = DecodeWBASS2(data)
+	})
+}
@@ -0,0 +1,212 @@
+package wbass2
+
+import (
+	"bytes"
+	"fmt"
+	"msxconverter/decoders"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a label with its cross-reference. Labels are matched by name
+// across the main file and the files it includes.
+type Symbol struct {
+	Index      int // index in the label table of the main file, -1 for labels only in included files
+	Name       string
+	Defined    []Line // lines defining the label
+	References []Line // lines using the label
+	Value      int
+	HasValue   bool // false when the value could not be evaluated
+}
+
+// Line is a line of the main file or an included file.
+type Line struct {
+	File   string
+	Number int
+}
+
+// symbolExtensions holds the output extension of each symbol format.
+var symbolExtensions = map[string]string{
+	"xref":    ".txt",
+	"openmsx": ".sym",
+	"noice":   ".noi",
+	"sym":     ".sym",
+}
+
+// SymbolFormats returns the supported symbol output formats.
+func SymbolFormats() []string {
+	return []string{"xref", "openmsx", "noice", "sym"}
+}
+
+// DecodeWBASS2Symbols writes the label table of a WBASS2 file in the format
+// given by config.Symbols: a cross-reference listing (xref), an openMSX
+// symbol file (openmsx), NoICE commands (noice) or a plain symbol list (sym).
+func DecodeWBASS2Symbols(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	extension, ok := symbolExtensions[config.Symbols]
+	if !ok {
+		return decoders.DecoderResult{}, fmt.Errorf("unknown symbol format: %s", config.Symbols)
+	}
+
+	dir := filepath.Dir(config.InputFileName)
+	symbols, err := Symbols(data, filepath.Base(config.InputFileName), func(name string) ([]byte, error) {
+		return readInclude(dir, name)
+	})
+	if err != nil {
+		return decoders.DecoderResult{}, err
+	}
+
+	var result bytes.Buffer
+	switch config.Symbols {
+	case "xref":
+		writeCrossReference(&result, symbols, filepath.Base(config.InputFileName))
+	case "openmsx":
+		for _, s := range symbols {
+			if s.HasValue {
+				result.WriteString(fmt.Sprintf("%s: equ %05Xh\n", s.Name, s.Value&0xFFFF))
+			}
+		}
+	case "noice":
+		for _, s := range symbols {
+			if s.HasValue {
+				result.WriteString(fmt.Sprintf("DEF %s %04X\n", s.Name, s.Value&0xFFFF))
+			}
+		}
+	case "sym":
+		for _, s := range symbols {
+			if s.HasValue {
+				result.WriteString(fmt.Sprintf("%04X %s\n", s.Value&0xFFFF, s.Name))
+			}
+		}
+	}
+
+	return decoders.DecoderResult{Text: result.String(), IsText: true, Extension: extension}, nil
+}
+
+// Symbols returns the label table of a WBASS2 file with the lines defining
+// and referencing each label. The values are taken from an assembler run
+// that skips lines it cannot assemble, so labels after such a line only get
+// a value again after the next ORG.
+func Symbols(data []byte, name string, readFile func(name string) ([]byte, error)) ([]Symbol, error) {
+	a := &assembler{
+		symbols:  map[string]int{},
+		sources:  map[string]*source{},
+		readFile: readFile,
+		tolerant: true,
+	}
+
+	main, err := a.load(name, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.run(main); err != nil {
+		return nil, err
+	}
+
+	count := labelCount(data, main.beglabel)
+	symbols := make([]Symbol, count)
+	byName := map[string]int{}
+	for i := range symbols {
+		name := labelName(data, main.beglabel, i)
+		symbols[i].Index = i
+		symbols[i].Name = name
+		symbols[i].Value, symbols[i].HasValue = a.symbols[name]
+		if _, ok := byName[name]; !ok && name != "" {
+			byName[name] = i
+		}
+	}
+
+	// labels of the main file are found by index, labels of included files
+	// by name, as every file has its own label table
+	lookup := func(src *source, label int) (int, bool) {
+		if src == main {
+			return label, label >= 0 && label < count
+		}
+		record, ok := readLabel(src.data, src.beglabel, label)
+		if !ok || record.name == "" {
+			return 0, false
+		}
+		i, ok := byName[record.name]
+		if !ok {
+			i = len(symbols)
+			byName[record.name] = i
+			symbol := Symbol{Index: -1, Name: record.name}
+			symbol.Value, symbol.HasValue = a.symbols[record.name]
+			symbols = append(symbols, symbol)
+		}
+		return i, true
+	}
+
+	for _, src := range a.loaded {
+		for _, line := range src.lines {
+			at := Line{File: src.name, Number: line.number}
+			if i, ok := lookup(src, line.label); ok {
+				symbols[i].Defined = append(symbols[i].Defined, at)
+			}
+			for _, t := range line.operands {
+				if t.kind != tokenLabel {
+					continue
+				}
+				if i, ok := lookup(src, t.value); ok {
+					refs := symbols[i].References
+					if len(refs) == 0 || refs[len(refs)-1] != at {
+						symbols[i].References = append(refs, at)
+					}
+				}
+			}
+		}
+	}
+
+	// drop unused slots at the end of the label table of the main file
+	unused := func(s Symbol) bool {
+		return s.Name == "" && len(s.Defined) == 0 && len(s.References) == 0
+	}
+	end := count
+	for end > 0 && unused(symbols[end-1]) {
+		end--
+	}
+	return append(symbols[:end], symbols[count:]...), nil
+}
+
+// writeCrossReference lists the symbols with the lines defining and using
+// them; lines of included files are given as FILE:LINE.
+func writeCrossReference(result *bytes.Buffer, symbols []Symbol, main string) {
+	result.WriteString("INDEX NAME    VALUE  DEFINED  REFERENCES\n")
+	for _, s := range symbols {
+		value := "----"
+		if s.HasValue {
+			value = fmt.Sprintf("%04X", s.Value&0xFFFF)
+		}
+		index := "-"
+		if s.Index >= 0 {
+			index = strconv.Itoa(s.Index)
+		}
+		result.WriteString(fmt.Sprintf("%5s %-7s %-6s %-8s %s\n", index, s.Name, value,
+			joinSourceLines(s.Defined, main), joinSourceLines(s.References, main)))
+	}
+}
+
+func joinSourceLines(lines []Line, main string) string {
+	numbers := make([]string, len(lines))
+	for i, line := range lines {
+		numbers[i] = strconv.Itoa(line.Number)
+		if line.File != main {
+			numbers[i] = line.File + ":" + numbers[i]
+		}
+	}
+	if len(numbers) == 0 {
+		return "-"
+	}
+	return strings.Join(numbers, ",")
+}
+
+func joinLines(lines []int) string {
+	if len(lines) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(lines))
+	for i, line := range lines {
+		parts[i] = strconv.Itoa(line)
+	}
+	return strings.Join(parts, ",")
+}
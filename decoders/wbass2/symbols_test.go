@@ -0,0 +1,57 @@
+package wbass2
+
+import (
+	"bytes"
+	"msxconverter/decoders"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSymbols(t *testing.T) {
+	data := program([]string{"START", "PORT", "UNUSED"},
+		line(-1, []byte{ins("ORG")}, num(0x9000)),
+		line(0, []byte{ins("LD"), reg("A"), tComma}, num(1)),
+		line(-1, []byte{ins("OUT"), tOpen}, lbl(1), []byte{tClose, tComma, reg("A")}),
+		line(-1, []byte{ins("JR")}, lbl(0)),
+		line(1, []byte{ins("EQU")}, num(0x98)),
+	)
+
+	symbols, err := Symbols(data, "TEST.WB2", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Symbol{
+		{Index: 0, Name: "START", Defined: []Line{{"TEST.WB2", 2}}, References: []Line{{"TEST.WB2", 4}}, Value: 0x9000, HasValue: true},
+		{Index: 1, Name: "PORT", Defined: []Line{{"TEST.WB2", 5}}, References: []Line{{"TEST.WB2", 3}}, Value: 0x98, HasValue: true},
+		{Index: 2, Name: "UNUSED"},
+	}, symbols)
+
+	result, err := DecodeWBASS2Symbols(data, decoders.Config{InputFileName: "TEST.WB2", Symbols: "openmsx"})
+	assert.NoError(t, err)
+	assert.Equal(t, "START: equ 09000h\nPORT: equ 00098h\n", result.Text)
+}
+
+func TestSymbols_Include(t *testing.T) {
+	lib := program([]string{"PRINT", "LOOP"},
+		line(0, []byte{ins("RET")}),
+		line(1, []byte{ins("JR")}, lbl(1)),
+	)
+	data := program([]string{"PRINT"},
+		line(-1, []byte{ins("ORG")}, num(0xC000)),
+		line(-1, []byte{ins("CALL")}, lbl(0)),
+		line(-1, []byte{ins("INCLUDE"), 34, 'L', 'I', 'B', 34}),
+	)
+
+	symbols, err := Symbols(data, "MAIN.WB2", func(name string) ([]byte, error) {
+		return lib, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Symbol{
+		{Index: 0, Name: "PRINT", Defined: []Line{{"LIB", 1}}, References: []Line{{"MAIN.WB2", 2}}, Value: 0xC003, HasValue: true},
+		{Index: -1, Name: "LOOP", Defined: []Line{{"LIB", 2}}, References: []Line{{"LIB", 2}}, Value: 0xC004, HasValue: true},
+	}, symbols)
+
+	var result bytes.Buffer
+	writeCrossReference(&result, symbols, "MAIN.WB2")
+	assert.Contains(t, result.String(), "    0 PRINT   C003   LIB:1    2\n")
+	assert.Contains(t, result.String(), "    - LOOP    C004   LIB:2    LIB:2\n")
+}
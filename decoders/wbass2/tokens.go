@@ -0,0 +1,147 @@
+package wbass2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// tokenKind identifies the type of a token in a tokenized WBASS2 line.
+type tokenKind int
+
+const (
+	tokenRegister tokenKind = iota
+	tokenCondition
+	tokenLogic
+	tokenChar
+	tokenString
+	tokenLabel
+	tokenNumber
+)
+
+// token is a single operand token of a WBASS2 source line.
+// For registers, conditions, logies and special characters value is the
+// index into the matching name table, for labels it is the label index and
+// for numbers it is the number itself. format holds the number format token.
+type token struct {
+	kind   tokenKind
+	value  int
+	format byte
+	text   []byte
+}
+
+// sourceLine is a decoded WBASS2 line, not yet rendered as text.
+type sourceLine struct {
+	number      int // 1-based line number in the listing
+	label       int // label index, -1 when the line has no label
+	instruction int // index into instructions, -1 when there is none
+	operands    []token
+	comment     []byte
+}
+
+// readLines walks the tokenized content in the same way parseLine does, but
+// returns the tokens instead of the listing text.
+func readLines(data []byte) ([]sourceLine, int, error) {
+	if len(data) == 0 || data[0] != 0xFD {
+		return nil, -1, errors.New("invalid WBASS2 file")
+	}
+
+	beglabel := findLabelOffset(data)
+	if beglabel == -1 {
+		return nil, -1, errors.New("invalid WBASS2 file structure")
+	}
+
+	var lines []sourceLine
+	offset := 1
+	for offset < len(data) {
+		length := data[offset]
+		offset++
+
+		if length == 0xFF { // end of tokenized content
+			break
+		}
+
+		line := sourceLine{number: len(lines) + 1, label: -1, instruction: -1}
+		if length == 0x00 { // empty line
+			lines = append(lines, line)
+			continue
+		}
+
+		endline := offset + int(length&127)
+		if endline > len(data) {
+			return nil, -1, errors.New("truncated WBASS2 line")
+		}
+
+		if length&128 == 128 { // label
+			if offset+2 > endline {
+				return nil, -1, errors.New("truncated WBASS2 label")
+			}
+			line.label = int(data[offset]) | int(data[offset+1])<<8
+			offset += 2
+		}
+
+		if offset < endline {
+			c := data[offset]
+			offset++
+			if c == 1 { // comment
+				line.comment = data[offset:endline]
+				offset = endline
+			} else if c > 127 {
+				line.instruction = int(c - 128)
+			}
+		}
+
+		for offset < endline {
+			c := data[offset]
+			offset++
+
+			switch {
+			case c == 1: // comment
+				line.comment = data[offset:endline]
+				offset = endline
+
+			case c > 1 && c < 14*2: // special character
+				line.operands = append(line.operands, token{kind: tokenChar, value: int(c/2 - 1)})
+
+			case c == 34: // quoted string, up to the end of the line when unterminated
+				end := bytes.IndexByte(data[offset:endline], 34)
+				if end == -1 {
+					line.operands = append(line.operands, token{kind: tokenString, text: data[offset:endline]})
+					offset = endline
+					break
+				}
+				line.operands = append(line.operands, token{kind: tokenString, text: data[offset : offset+end]})
+				offset += end + 1
+
+			case (c == 0xC0 || c == 0xE0 || c == 0xE1 || c == 0xE2) && offset+2 > endline:
+				return nil, -1, errors.New("truncated WBASS2 operand")
+
+			case c == 0xC0: // label
+				label := int(data[offset]) | int(data[offset+1])<<8
+				offset += 2
+				line.operands = append(line.operands, token{kind: tokenLabel, value: label})
+
+			case c == 0xE0 || c == 0xE1 || c == 0xE2: // number formats
+				number := int(data[offset]) | int(data[offset+1])<<8
+				offset += 2
+				line.operands = append(line.operands, token{kind: tokenNumber, value: number, format: c})
+
+			case c > 156:
+				return nil, -1, fmt.Errorf("line %d: bad token %02X", line.number, c)
+
+			case c >= 153:
+				line.operands = append(line.operands, token{kind: tokenLogic, value: int(c - 153)})
+
+			case c >= 144:
+				line.operands = append(line.operands, token{kind: tokenCondition, value: int(c - 144)})
+
+			case c >= 128:
+				line.operands = append(line.operands, token{kind: tokenRegister, value: int(c - 128)})
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, beglabel, nil
+}
@@ -62,6 +62,8 @@ func DecodeWBASS2(data []byte) (decoders.DecoderResult, error) {
 
 	decoderResult.Text = result.String()
 	decoderResult.IsText = true
+	decoderResult.Extension = ".asm"
+	decoderResult.Warnings = checkLabels(data)
 
 	return decoderResult, nil
 }
@@ -71,13 +73,15 @@ func parseLine(length byte, data []byte, offset int, beglabel int) (bytes.Buffer
 
 	if length&128 == 128 { // label?
 		length = length & 127
+		if offset+2 > len(data) || length < 2 {
+			line.WriteString("\n")
+			return line, len(data)
+		}
 		label := int(data[offset]) | int(data[offset+1])<<8
 		offset += 2
 		length -= 2
 
-		for i := beglabel + 8*label; (data[i]&127) != 0 && i < beglabel+8*label+6; i++ {
-			line.WriteByte(data[i] & 0x7F)
-		}
+		line.WriteString(labelName(data, beglabel, label))
 		line.WriteString(":")
 
 		if length == 0 { // only label on this line
@@ -86,16 +90,21 @@ func parseLine(length byte, data []byte, offset int, beglabel int) (bytes.Buffer
 		}
 	}
 
+	if offset >= len(data) {
+		line.WriteString("\n")
+		return line, offset
+	}
+
 	c := data[offset]
 	offset++
 	length--
 
 	if c == 1 { // comment
 		if line.Len() > 0 {
-			line.WriteString(strings.Repeat(" ", 8-line.Len()))
+			line.WriteString(strings.Repeat(" ", max(0, 8-line.Len())))
 		}
 		line.WriteString(";")
-		for ; length > 0; length-- {
+		for ; length > 0 && offset < len(data); length-- {
 			line.WriteByte(data[offset])
 			offset++
 		}
@@ -104,13 +113,13 @@ func parseLine(length byte, data []byte, offset int, beglabel int) (bytes.Buffer
 			line.WriteString(strings.Repeat(" ", 8-line.Len()))
 		}
 
-		line.WriteString(instructions[c-128])
+		line.WriteString(lookupName(instructions, int(c-128)))
 		if length > 0 {
-			line.WriteString(strings.Repeat(" ", 14-line.Len()))
+			line.WriteString(strings.Repeat(" ", max(0, 14-line.Len())))
 		}
 	}
 
-	endline := offset + int(length&127)
+	endline := min(offset+int(length&127), len(data))
 	needspace := false
 	for offset < endline {
 		c := data[offset]
@@ -127,29 +136,32 @@ func parseLine(length byte, data []byte, offset int, beglabel int) (bytes.Buffer
 			}
 
 			line.WriteString(";")
-			for ; length > 0; length-- {
+			for ; length > 0 && offset < len(data); length-- {
 				line.WriteByte(data[offset])
 				offset++
 			}
 
 		case c > 1 && c < 14*2: // special character
-			line.WriteString(kartab[c/2-1]) //  SRL A, CP 14, kartab-1
+			line.WriteString(lookupName(kartab, int(c/2-1))) //  SRL A, CP 14, kartab-1
 			needspace = false
 
-		case c == 34: // quoted string
-			if end := bytes.IndexByte(data[offset:endline], 34); end != -1 {
-				line.WriteByte('"')
-				line.Write(data[offset : offset+end+1])
-				offset += end + 1
+		case c == 34: // quoted string, up to the end of the line when unterminated
+			end := bytes.IndexByte(data[offset:endline], 34) + 1
+			if end == 0 {
+				end = endline - offset
 			}
+			line.WriteByte('"')
+			line.Write(data[offset : offset+end])
+			offset += end
+
+		case (c == 0xC0 || c == 0xE0 || c == 0xE1 || c == 0xE2) && offset+2 > endline:
+			offset = endline // truncated operand
 
 		case c == 0xC0: // label
 			label := int(data[offset]) | int(data[offset+1])<<8
 			offset += 2
 			length -= 2
-			for i := beglabel + 8*label; (data[i]&127) != 0 && i < beglabel+8*label+6; i++ {
-				line.WriteByte(data[i] & 0x7F)
-			}
+			line.WriteString(labelName(data, beglabel, label))
 
 		case c == 0xE0 || c == 0xE1 || c == 0xE2: // number formats
 			handleSpace(&line, needspace)
@@ -163,7 +175,7 @@ func parseLine(length byte, data []byte, offset int, beglabel int) (bytes.Buffer
 			handleSpace(&line, needspace)
 			var value string
 			if c >= 153 {
-				value = logies[c-153]
+				value = lookupName(logies, int(c-153))
 			} else if c >= 144 {
 				value = condities[c-144]
 			} else {
@@ -180,6 +192,14 @@ func parseLine(length byte, data []byte, offset int, beglabel int) (bytes.Buffer
 	return line, offset
 }
 
+// lookupName returns a name from a token table, or ? for corrupted tokens.
+func lookupName(names []string, index int) string {
+	if index < 0 || index >= len(names) {
+		return "?"
+	}
+	return names[index]
+}
+
 func findLabelOffset(data []byte) int {
 	for i := 1; i < len(data); {
 		c := data[i]
@@ -0,0 +1,202 @@
+package z80
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Data is written as DS for runs of equal bytes and as strings for text.
+const (
+	minRun  = 16 // shortest run of equal bytes
+	minText = 4  // shortest text
+	maxText = 48 // characters of text per line
+)
+
+// Trace follows the code from the entry points through jumps and calls, as
+// the CPU would, and returns the instructions found by address. Code outside
+// of memory is not followed, so the rest of memory is data.
+func Trace(memory []byte, origin int, entries []int) map[int]Instruction {
+	code := map[int]Instruction{}
+	covered := make([]bool, len(memory))
+	todo := append([]int{}, entries...)
+	for len(todo) > 0 {
+		address := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		for {
+			instruction, ok := Decode(memory, origin, address)
+			if !ok || overlaps(covered, address-origin, instruction.Length()) {
+				break
+			}
+			code[address] = instruction
+			for i := range instruction.Bytes {
+				covered[address-origin+i] = true
+			}
+			if instruction.Target >= 0 {
+				todo = append(todo, instruction.Target)
+			}
+			if instruction.End {
+				break
+			}
+			address += instruction.Length()
+		}
+	}
+	return code
+}
+
+func overlaps(covered []bool, offset, length int) bool {
+	for i := offset; i < offset+length; i++ {
+		if covered[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Listing is a disassembly of memory: the traced code with labels, and the
+// bytes between the code as data.
+type Listing struct {
+	Memory []byte
+	Origin int
+	Code   map[int]Instruction
+	// Labels names addresses; other jump and call targets in memory get
+	// labels like L4020.
+	Labels map[int]string
+	// Comment returns a comment for an instruction, or an empty string.
+	Comment func(Instruction) string
+}
+
+func (l Listing) String() string {
+	labels := map[int]string{}
+	for address, name := range l.Labels {
+		labels[address] = name
+	}
+	for _, instruction := range l.Code {
+		target := instruction.Target
+		if _, ok := l.Code[target]; ok && labels[target] == "" {
+			labels[target] = fmt.Sprintf("L%04X", target)
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%20sORG %s\n", "", Hex(l.Origin, 4))
+	for offset := 0; offset < len(l.Memory); {
+		address := l.Origin + offset
+		if label, ok := labels[address]; ok {
+			fmt.Fprintf(&out, "%s:\n", label)
+		}
+		instruction, ok := l.Code[address]
+		if !ok {
+			offset += l.data(&out, offset, labels)
+			continue
+		}
+
+		text := instruction.Text
+		if label, ok := labels[instruction.Target]; ok {
+			text = strings.Replace(text, Hex(instruction.Target, 4), label, 1)
+		}
+		if l.Comment != nil {
+			if comment := l.Comment(instruction); comment != "" {
+				text = fmt.Sprintf("%-24s; %s", text, comment)
+			}
+		}
+		out.WriteString(line(address, instruction.Bytes, text))
+		offset += instruction.Length()
+	}
+	return out.String()
+}
+
+// data writes the bytes from offset up to the next code or label, and
+// returns their number.
+func (l Listing) data(out *strings.Builder, offset int, labels map[int]string) int {
+	end := offset + 1
+	for end < len(l.Memory) {
+		address := l.Origin + end
+		if _, ok := l.Code[address]; ok {
+			break
+		}
+		if _, ok := labels[address]; ok {
+			break
+		}
+		end++
+	}
+
+	for start := offset; start < end; {
+		if run := equalRun(l.Memory[start:end]); run >= minRun {
+			out.WriteString(line(l.Origin+start, nil, fmt.Sprintf("DS %d,%s", run, Hex(int(l.Memory[start]), 2))))
+			start += run
+			continue
+		}
+		if n := textLength(l.Memory[start:end]); n >= minText {
+			// text with the line ends and the 0 or MSX-DOS $ terminator
+			// that follow it
+			n = min(n, maxText)
+			values := []string{`"` + string(l.Memory[start:start+n]) + `"`}
+			next := start + n
+			for next < end && next < start+n+3 && (l.Memory[next] == '\r' || l.Memory[next] == '\n') {
+				values = append(values, Hex(int(l.Memory[next]), 2))
+				next++
+			}
+			if next < end && l.Memory[next] == '$' {
+				values = append(values, `"$"`)
+				next++
+			} else if next < end && l.Memory[next] == 0 {
+				values = append(values, Hex(0, 2))
+				next++
+			}
+			out.WriteString(line(l.Origin+start, nil, "DB "+strings.Join(values, ",")))
+			start = next
+			continue
+		}
+
+		// 8 bytes per line, up to a run of equal bytes or text
+		n := 1
+		for n < 8 && start+n < end && equalRun(l.Memory[start+n:end]) < minRun && textLength(l.Memory[start+n:end]) < minText {
+			n++
+		}
+		values := make([]string, n)
+		for i := range values {
+			values[i] = Hex(int(l.Memory[start+i]), 2)
+		}
+		out.WriteString(line(l.Origin+start, nil, "DB "+strings.Join(values, ",")))
+		start += n
+	}
+	return end - offset
+}
+
+// equalRun returns the number of bytes equal to the first.
+func equalRun(data []byte) int {
+	n := 1
+	for n < len(data) && data[n] == data[0] {
+		n++
+	}
+	return n
+}
+
+// textLength returns the number of printable characters at the start of
+// data; quotes end the text, as they cannot be in a DB string.
+func textLength(data []byte) int {
+	n := 0
+	for n < len(data) && data[n] >= 0x20 && data[n] < 0x7F && data[n] != '"' {
+		n++
+	}
+	return n
+}
+
+func line(address int, data []byte, text string) string {
+	var hexBytes strings.Builder
+	for _, b := range data {
+		fmt.Fprintf(&hexBytes, "%02X ", b)
+	}
+	return fmt.Sprintf("%04X  %-12s  %s\n", address, hexBytes.String(), text)
+}
+
+// Addresses returns the sorted addresses of the traced instructions.
+func Addresses(code map[int]Instruction) []int {
+	addresses := make([]int, 0, len(code))
+	for address := range code {
+		addresses = append(addresses, address)
+	}
+	sort.Ints(addresses)
+	return addresses
+}
@@ -0,0 +1,453 @@
+// Package z80 disassembles Z80 machine code, including the undocumented
+// IX/IY halves and the R800 multiplications of the MSX turbo R.
+package z80
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instruction is a decoded instruction. Destination is the register or
+// register pair the instruction writes, "" when it only writes memory, ports
+// or flags. Instructions that write several pairs give the first: BC for EXX
+// and the LDI and CPI block instructions, DE for EX DE,HL and MULUW.
+type Instruction struct {
+	Address     int
+	Bytes       []byte
+	Text        string
+	Target      int  // address of a jump or call, -1 for other instructions
+	End         bool // execution does not continue with the next instruction
+	Call        bool // CALL or RST, after which any register may have changed
+	Destination string
+	Immediate   int // the n or nn operand, -1 for instructions without one
+}
+
+var (
+	registers   = []string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+	pairs       = []string{"BC", "DE", "HL", "SP"}
+	pairsAF     = []string{"BC", "DE", "HL", "AF"}
+	conditions  = []string{"NZ", "Z", "NC", "C", "PO", "PE", "P", "M"}
+	arithmetic  = []string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	rotations   = []string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SLL", "SRL"}
+	accumulator = []string{"RLCA", "RRCA", "RLA", "RRA", "DAA", "CPL", "SCF", "CCF"}
+	interrupts  = []string{"0", "0", "1", "2", "0", "0", "1", "2"}
+	blocks      = [4][4]string{
+		{"LDI", "CPI", "INI", "OUTI"},
+		{"LDD", "CPD", "IND", "OUTD"},
+		{"LDIR", "CPIR", "INIR", "OTIR"},
+		{"LDDR", "CPDR", "INDR", "OTDR"},
+	}
+)
+
+// Hex formats a number as an assembler constant, e.g. 00A2h or 0C000h.
+func Hex(value, digits int) string {
+	text := fmt.Sprintf("%0*Xh", digits, value)
+	if text[0] > '9' {
+		return "0" + text
+	}
+	return text
+}
+
+// decoder reads the bytes of one instruction.
+type decoder struct {
+	memory  []byte
+	origin  int
+	address int
+	length  int
+	index   string // IX or IY after a DD or FD prefix
+	target  int
+	end     bool
+	call    bool
+	dest    string
+	value   int // immediate operand
+}
+
+// next reads the next byte; bytes after the end of memory are read as 0
+// and make Decode fail.
+func (d *decoder) next() byte {
+	offset := d.address - d.origin + d.length
+	d.length++
+	if offset >= len(d.memory) {
+		return 0
+	}
+	return d.memory[offset]
+}
+
+func (d *decoder) byteOperand() string {
+	return Hex(int(d.next()), 2)
+}
+
+func (d *decoder) wordOperand() string {
+	low := int(d.next())
+	return Hex(low|int(d.next())<<8, 4)
+}
+
+// immediate reads the n operand of an instruction.
+func (d *decoder) immediate() string {
+	d.value = int(d.next())
+	return Hex(d.value, 2)
+}
+
+// immediateWord reads the nn operand of an instruction.
+func (d *decoder) immediateWord() string {
+	low := int(d.next())
+	d.value = low | int(d.next())<<8
+	return Hex(d.value, 4)
+}
+
+// write records the register an instruction writes; memory operands such as
+// (HL) and (IX+d) are not recorded.
+func (d *decoder) write(register string) string {
+	if !strings.HasPrefix(register, "(") {
+		d.dest = register
+	}
+	return register
+}
+
+// jump reads the address of an absolute jump or call.
+func (d *decoder) jump() string {
+	low := int(d.next())
+	d.target = low | int(d.next())<<8
+	return Hex(d.target, 4)
+}
+
+// relative reads the displacement of JR and DJNZ.
+func (d *decoder) relative() string {
+	displacement := int(int8(d.next()))
+	d.target = (d.address + d.length + displacement) & 0xFFFF
+	return Hex(d.target, 4)
+}
+
+// displacement reads the offset of (IX+d).
+func (d *decoder) displacement() string {
+	offset := int(int8(d.next()))
+	if offset < 0 {
+		return fmt.Sprintf("(%s-%s)", d.index, Hex(-offset, 2))
+	}
+	return fmt.Sprintf("(%s+%s)", d.index, Hex(offset, 2))
+}
+
+// register returns register r; with an index prefix H and L are the halves
+// of the index register and (HL) is indexed, unless indexed is false.
+func (d *decoder) register(r int, indexed bool) string {
+	if d.index == "" || !indexed {
+		return registers[r]
+	}
+	switch r {
+	case 4:
+		return d.index + "H"
+	case 5:
+		return d.index + "L"
+	case 6:
+		return d.displacement()
+	}
+	return registers[r]
+}
+
+func (d *decoder) pair(p int) string {
+	if p == 2 && d.index != "" {
+		return d.index
+	}
+	return pairs[p]
+}
+
+// Decode decodes the instruction at address in memory loaded at origin.
+// ok is false when the instruction does not fit in memory.
+func Decode(memory []byte, origin, address int) (instruction Instruction, ok bool) {
+	start := address - origin
+	if start < 0 || start >= len(memory) {
+		return Instruction{}, false
+	}
+	d := &decoder{memory: memory, origin: origin, address: address, target: -1, value: -1}
+	text := d.decode()
+	if start+d.length > len(memory) {
+		return Instruction{}, false
+	}
+	return Instruction{
+		Address:     address,
+		Bytes:       memory[start : start+d.length],
+		Text:        text,
+		Target:      d.target,
+		End:         d.end,
+		Call:        d.call,
+		Destination: d.dest,
+		Immediate:   d.value,
+	}, true
+}
+
+// Length is the number of bytes of the instruction.
+func (i Instruction) Length() int {
+	return len(i.Bytes)
+}
+
+func (d *decoder) decode() string {
+	opcode := d.next()
+	switch opcode {
+	case 0xCB:
+		return d.decodeCB()
+	case 0xED:
+		return d.decodeED()
+	case 0xDD, 0xFD:
+		d.index = "IX"
+		if opcode == 0xFD {
+			d.index = "IY"
+		}
+		offset := d.address - d.origin + 1
+		if offset >= len(d.memory) {
+			return "DB " + Hex(int(opcode), 2)
+		}
+		switch d.memory[offset] {
+		case 0xDD, 0xED, 0xFD:
+			// a prefix without effect
+			return "DB " + Hex(int(opcode), 2)
+		case 0xCB:
+			d.next()
+			return d.decodeIndexedCB()
+		}
+		return d.decodeMain(d.next())
+	}
+	return d.decodeMain(opcode)
+}
+
+func (d *decoder) decodeMain(opcode byte) string {
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+	p, q := y>>1, y&1
+
+	switch x {
+	case 0:
+		switch z {
+		case 0:
+			switch y {
+			case 0:
+				return "NOP"
+			case 1:
+				d.dest = "AF"
+				return "EX AF,AF'"
+			case 2:
+				d.dest = "B"
+				return "DJNZ " + d.relative()
+			case 3:
+				d.end = true
+				return "JR " + d.relative()
+			}
+			return fmt.Sprintf("JR %s,%s", conditions[y-4], d.relative())
+		case 1:
+			if q == 0 {
+				return fmt.Sprintf("LD %s,%s", d.write(d.pair(p)), d.immediateWord())
+			}
+			return fmt.Sprintf("ADD %s,%s", d.write(d.pair(2)), d.pair(p))
+		case 2:
+			switch y {
+			case 0:
+				return "LD (BC),A"
+			case 1:
+				return "LD " + d.write("A") + ",(BC)"
+			case 2:
+				return "LD (DE),A"
+			case 3:
+				return "LD " + d.write("A") + ",(DE)"
+			case 4:
+				return fmt.Sprintf("LD (%s),%s", d.wordOperand(), d.pair(2))
+			case 5:
+				return fmt.Sprintf("LD %s,(%s)", d.write(d.pair(2)), d.wordOperand())
+			case 6:
+				return fmt.Sprintf("LD (%s),A", d.wordOperand())
+			}
+			return fmt.Sprintf("LD %s,(%s)", d.write("A"), d.wordOperand())
+		case 3:
+			if q == 0 {
+				return "INC " + d.write(d.pair(p))
+			}
+			return "DEC " + d.write(d.pair(p))
+		case 4:
+			return "INC " + d.write(d.register(y, true))
+		case 5:
+			return "DEC " + d.write(d.register(y, true))
+		case 6:
+			r := d.write(d.register(y, true))
+			return fmt.Sprintf("LD %s,%s", r, d.immediate())
+		}
+		if y < 6 {
+			d.dest = "A" // not SCF and CCF
+		}
+		return accumulator[y]
+	case 1:
+		if y == 6 && z == 6 {
+			return "HALT"
+		}
+		if y == 6 || z == 6 {
+			// with (IX+d) the other register is not an index half
+			return fmt.Sprintf("LD %s,%s", d.write(d.register(y, y == 6)), d.register(z, z == 6))
+		}
+		return fmt.Sprintf("LD %s,%s", d.write(d.register(y, true)), d.register(z, true))
+	case 2:
+		if y != 7 {
+			d.dest = "A" // not CP
+		}
+		return arithmetic[y] + d.register(z, true)
+	}
+
+	switch z {
+	case 0:
+		return "RET " + conditions[y]
+	case 1:
+		if q == 0 {
+			if p == 2 && d.index != "" {
+				return "POP " + d.write(d.index)
+			}
+			return "POP " + d.write(pairsAF[p])
+		}
+		switch p {
+		case 0:
+			d.end = true
+			return "RET"
+		case 1:
+			d.dest = "BC"
+			return "EXX"
+		case 2:
+			d.end = true
+			return fmt.Sprintf("JP (%s)", d.pair(2))
+		}
+		return fmt.Sprintf("LD %s,%s", d.write("SP"), d.pair(2))
+	case 2:
+		return fmt.Sprintf("JP %s,%s", conditions[y], d.jump())
+	case 3:
+		switch y {
+		case 0:
+			d.end = true
+			return "JP " + d.jump()
+		case 2:
+			return fmt.Sprintf("OUT (%s),A", d.byteOperand())
+		case 3:
+			return fmt.Sprintf("IN %s,(%s)", d.write("A"), d.byteOperand())
+		case 4:
+			return fmt.Sprintf("EX (SP),%s", d.write(d.pair(2)))
+		case 5:
+			d.dest = "DE"
+			return "EX DE,HL"
+		case 6:
+			return "DI"
+		case 7:
+			return "EI"
+		}
+	case 4:
+		d.call = true
+		return fmt.Sprintf("CALL %s,%s", conditions[y], d.jump())
+	case 5:
+		if q == 0 {
+			if p == 2 && d.index != "" {
+				return "PUSH " + d.index
+			}
+			return "PUSH " + pairsAF[p]
+		}
+		d.call = true
+		return "CALL " + d.jump()
+	case 6:
+		if y != 7 {
+			d.dest = "A" // not CP
+		}
+		return arithmetic[y] + d.immediate()
+	case 7:
+		d.call = true
+		d.target = y * 8
+		return "RST " + Hex(y*8, 2)
+	}
+	return "DB " + Hex(int(opcode), 2) // prefixes are handled before
+}
+
+func (d *decoder) decodeCB() string {
+	opcode := d.next()
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+	if x != 1 {
+		d.write(registers[z]) // not BIT
+	}
+	return bitInstruction(x, y, registers[z])
+}
+
+// decodeIndexedCB decodes DD CB d op, where the displacement comes before
+// the opcode.
+func (d *decoder) decodeIndexedCB() string {
+	operand := d.displacement()
+	opcode := d.next()
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+	text := bitInstruction(x, y, operand)
+	if z != 6 && x != 1 {
+		// undocumented: the result is also stored in a register
+		text += "," + d.write(registers[z])
+	}
+	return text
+}
+
+func bitInstruction(x, y int, operand string) string {
+	switch x {
+	case 0:
+		return rotations[y] + " " + operand
+	case 1:
+		return fmt.Sprintf("BIT %d,%s", y, operand)
+	case 2:
+		return fmt.Sprintf("RES %d,%s", y, operand)
+	}
+	return fmt.Sprintf("SET %d,%s", y, operand)
+}
+
+func (d *decoder) decodeED() string {
+	opcode := d.next()
+	x, y, z := int(opcode>>6), int(opcode>>3&7), int(opcode&7)
+	p, q := y>>1, y&1
+
+	switch {
+	case x == 1:
+		switch z {
+		case 0:
+			if y == 6 {
+				return "IN F,(C)"
+			}
+			return fmt.Sprintf("IN %s,(C)", d.write(registers[y]))
+		case 1:
+			if y == 6 {
+				return "OUT (C),0"
+			}
+			return fmt.Sprintf("OUT (C),%s", registers[y])
+		case 2:
+			d.dest = "HL"
+			if q == 0 {
+				return "SBC HL," + pairs[p]
+			}
+			return "ADC HL," + pairs[p]
+		case 3:
+			if q == 0 {
+				return fmt.Sprintf("LD (%s),%s", d.wordOperand(), pairs[p])
+			}
+			return fmt.Sprintf("LD %s,(%s)", d.write(pairs[p]), d.wordOperand())
+		case 4:
+			d.dest = "A"
+			return "NEG"
+		case 5:
+			d.end = true
+			if y == 1 {
+				return "RETI"
+			}
+			return "RETN"
+		case 6:
+			return "IM " + interrupts[y]
+		}
+		d.dest = []string{"I", "R", "A", "A", "A", "A", "", ""}[y]
+		return []string{"LD I,A", "LD R,A", "LD A,I", "LD A,R", "RRD", "RLD", "NOP", "NOP"}[y]
+	case x == 2 && z <= 3 && y >= 4:
+		// LDI and CPI count with BC, INI and OUTI with B
+		d.dest = []string{"BC", "BC", "B", "B"}[z]
+		return blocks[y-4][z]
+	case x == 3 && z == 1 && y != 6:
+		d.dest = "HL"
+		return "MULUB A," + registers[y] // R800
+	case x == 3 && z == 3 && q == 0:
+		d.dest = "DE"
+		return "MULUW HL," + pairs[p] // R800
+	}
+	return fmt.Sprintf("DB %s,%s", Hex(0xED, 2), Hex(int(opcode), 2))
+}
+
+// String formats the instruction with its address and bytes.
+func (i Instruction) String() string {
+	return strings.TrimSuffix(line(i.Address, i.Bytes, i.Text), "\n")
+}
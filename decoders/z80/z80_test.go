@@ -0,0 +1,137 @@
+package z80
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		code []byte
+		text string
+	}{
+		{[]byte{0x00}, "NOP"},
+		{[]byte{0x3E, 0xFF}, "LD A,0FFh"},
+		{[]byte{0x21, 0x00, 0xC0}, "LD HL,0C000h"},
+		{[]byte{0x32, 0x00, 0x50}, "LD (5000h),A"},
+		{[]byte{0x2A, 0x34, 0x12}, "LD HL,(1234h)"},
+		{[]byte{0x46}, "LD B,(HL)"},
+		{[]byte{0x76}, "HALT"},
+		{[]byte{0xB8}, "CP B"},
+		{[]byte{0xD6, 0x30}, "SUB 30h"},
+		{[]byte{0xCD, 0xA2, 0x00}, "CALL 00A2h"},
+		{[]byte{0xC4, 0x00, 0x40}, "CALL NZ,4000h"},
+		{[]byte{0x18, 0xFE}, "JR 0100h"},
+		{[]byte{0x10, 0x02}, "DJNZ 0104h"},
+		{[]byte{0xF5}, "PUSH AF"},
+		{[]byte{0xFF}, "RST 38h"},
+		{[]byte{0xD3, 0x98}, "OUT (98h),A"},
+		{[]byte{0xCB, 0x7E}, "BIT 7,(HL)"},
+		{[]byte{0xCB, 0x11}, "RL C"},
+		{[]byte{0xED, 0xB0}, "LDIR"},
+		{[]byte{0xED, 0x4B, 0x00, 0x80}, "LD BC,(8000h)"},
+		{[]byte{0xED, 0x56}, "IM 1"},
+		{[]byte{0xED, 0xC1}, "MULUB A,B"},
+		{[]byte{0xED, 0x00}, "DB 0EDh,00h"},
+		{[]byte{0xDD, 0x21, 0x00, 0x90}, "LD IX,9000h"},
+		{[]byte{0xDD, 0x7E, 0x05}, "LD A,(IX+05h)"},
+		{[]byte{0xFD, 0x66, 0xFE}, "LD H,(IY-02h)"},
+		{[]byte{0xDD, 0x36, 0x01, 0x20}, "LD (IX+01h),20h"},
+		{[]byte{0xDD, 0x65}, "LD IXH,IXL"},
+		{[]byte{0xFD, 0xE9}, "JP (IY)"},
+		{[]byte{0xDD, 0xCB, 0x03, 0xC6}, "SET 0,(IX+03h)"},
+	}
+	for _, test := range tests {
+		instruction, ok := Decode(test.code, 0x100, 0x100)
+		assert.True(t, ok, test.text)
+		assert.Equal(t, test.text, instruction.Text)
+		assert.Equal(t, len(test.code), instruction.Length(), test.text)
+	}
+
+	// a prefix followed by another prefix has no effect
+	instruction, _ := Decode([]byte{0xDD, 0xDD, 0x00}, 0x100, 0x100)
+	assert.Equal(t, "DB 0DDh", instruction.Text)
+	assert.Equal(t, 1, instruction.Length())
+
+	_, ok := Decode([]byte{0xCD, 0x00}, 0x100, 0x100)
+	assert.False(t, ok)
+}
+
+func TestDecode_Operands(t *testing.T) {
+	tests := []struct {
+		code        []byte
+		destination string
+		immediate   int
+		call        bool
+	}{
+		{[]byte{0x0E, 0x09}, "C", 0x09, false},            // LD C,09h
+		{[]byte{0x01, 0x6F, 0x00}, "BC", 0x6F, false},     // LD BC,006Fh
+		{[]byte{0x4F}, "C", -1, false},                    // LD C,A
+		{[]byte{0x2A, 0x34, 0x12}, "HL", -1, false},       // LD HL,(1234h)
+		{[]byte{0x32, 0x00, 0x50}, "", -1, false},         // LD (5000h),A
+		{[]byte{0xFE, 0x30}, "", 0x30, false},             // CP 30h
+		{[]byte{0xD6, 0x30}, "A", 0x30, false},            // SUB 30h
+		{[]byte{0xDD, 0x36, 0x01, 0x20}, "", 0x20, false}, // LD (IX+01h),20h
+		{[]byte{0xDD, 0x26, 0x20}, "IXH", 0x20, false},    // LD IXH,20h
+		{[]byte{0xCB, 0x11}, "C", -1, false},              // RL C
+		{[]byte{0xCB, 0x41}, "", -1, false},               // BIT 0,C
+		{[]byte{0xDD, 0xCB, 0x03, 0xC1}, "C", -1, false},  // SET 0,(IX+03h),C
+		{[]byte{0xED, 0x48}, "C", -1, false},              // IN C,(C)
+		{[]byte{0xED, 0xB0}, "BC", -1, false},             // LDIR
+		{[]byte{0xD9}, "BC", -1, false},                   // EXX
+		{[]byte{0xCD, 0xA2, 0x00}, "", -1, true},          // CALL 00A2h
+		{[]byte{0xFF}, "", -1, true},                      // RST 38h
+		{[]byte{0xC3, 0x05, 0x00}, "", -1, false},         // JP 0005h
+	}
+	for _, test := range tests {
+		instruction, ok := Decode(test.code, 0x100, 0x100)
+		assert.True(t, ok)
+		assert.Equal(t, test.destination, instruction.Destination, instruction.Text)
+		assert.Equal(t, test.immediate, instruction.Immediate, instruction.Text)
+		assert.Equal(t, test.call, instruction.Call, instruction.Text)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	memory := []byte{
+		0x3E, 0x41, // 0100 LD A,41h
+		0xCD, 0x08, 0x01, // 0102 CALL 0108h
+		0xC3, 0x00, 0x00, // 0105 JP 0000h
+		0xFE, 0x20, // 0108 CP 20h
+		0x28, 0x01, // 010A JR Z,010Dh
+		0xC9,               // 010C RET
+		0xC9,               // 010D RET
+		'D', 'A', 'T', 'A', // data
+	}
+	code := Trace(memory, 0x100, []int{0x100})
+	assert.Equal(t, []int{0x100, 0x102, 0x105, 0x108, 0x10A, 0x10C, 0x10D}, Addresses(code))
+	assert.True(t, code[0x105].End)
+	assert.Equal(t, 0x10D, code[0x10A].Target)
+
+	listing := Listing{Memory: memory, Origin: 0x100, Code: code, Labels: map[int]string{0x100: "START"}}.String()
+	assert.Contains(t, listing, "START:\n0100  3E 41")
+	assert.Contains(t, listing, "CALL L0108\n")
+	assert.Contains(t, listing, "JR Z,L010D\n")
+	assert.Contains(t, listing, "JP 0000h\n")
+	assert.Contains(t, listing, "010E                DB \"DATA\"\n")
+	assert.Equal(t, 12, strings.Count(listing, "\n"))
+}
+
+func TestListing_Runs(t *testing.T) {
+	memory := append([]byte{0xC9, 1, 2}, make([]byte, 40)...)
+	code := Trace(memory, 0x4000, []int{0x4000})
+	listing := Listing{Memory: memory, Origin: 0x4000, Code: code}.String()
+	assert.Contains(t, listing, "4001                DB 01h,02h\n")
+	assert.Contains(t, listing, "4003                DS 40,00h\n")
+}
+
+func TestListing_Text(t *testing.T) {
+	memory := append([]byte{0xC9, 1}, []byte("Hello\r\n$ab\"x")...)
+	code := Trace(memory, 0x100, []int{0x100})
+	listing := Listing{Memory: memory, Origin: 0x100, Code: code}.String()
+	assert.Contains(t, listing, "0101                DB 01h\n")
+	assert.Contains(t, listing, "0102                DB \"Hello\",0Dh,0Ah,\"$\"\n")
+	assert.Contains(t, listing, "010A                DB 61h,62h,22h,78h\n")
+}
@@ -0,0 +1,374 @@
+// Package disk reads and writes MSX-DOS disk images: FAT12 file systems as written by
+// MSX-DOS 1 and 2, stored sector by sector in .DSK files.
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	SectorSize = 512
+	entrySize  = 32
+)
+
+// Directory entry attributes.
+const (
+	AttrReadOnly  = 0x01
+	AttrHidden    = 0x02
+	AttrSystem    = 0x04
+	AttrVolume    = 0x08
+	AttrDirectory = 0x10
+	AttrArchive   = 0x20
+)
+
+// FAT12 cluster values
+const (
+	clusterFree = 0x000
+	clusterEnd  = 0xFF8 // this and higher values end a chain
+)
+
+// geometry is the layout of a disk as given in its boot sector.
+type geometry struct {
+	sectorsPerCluster int
+	reservedSectors   int
+	fats              int
+	rootEntries       int
+	totalSectors      int
+	media             byte
+	sectorsPerFAT     int
+	sectorsPerTrack   int
+	sides             int
+}
+
+// Layouts of the standard MSX disks, by media descriptor. Disks formatted by
+// early MSX-DOS 1 versions have no valid boot sector and are recognised by
+// the media descriptor in the first byte of the FAT.
+var standardGeometries = map[byte]geometry{
+	0xF8: {2, 1, 2, 112, 720, 0xF8, 2, 9, 1},  // 360 KB, single sided
+	0xF9: {2, 1, 2, 112, 1440, 0xF9, 3, 9, 2}, // 720 KB, double sided
+	0xFA: {2, 1, 2, 112, 640, 0xFA, 1, 8, 1},  // 320 KB, single sided
+	0xFB: {2, 1, 2, 112, 1280, 0xFB, 2, 8, 2}, // 640 KB, double sided
+}
+
+// Image is a disk image.
+type Image struct {
+	data []byte
+	geometry
+}
+
+// Entry is a file or directory of a disk.
+type Entry struct {
+	Name       string // file name as NAME.EXT
+	Size       int
+	Modified   time.Time
+	Attributes byte
+	cluster    int
+	offset     int // position of the directory entry in the image
+}
+
+// IsDir tells whether the entry is a directory.
+func (e Entry) IsDir() bool {
+	return e.Attributes&AttrDirectory != 0
+}
+
+// Open reads the layout of a disk image.
+func Open(data []byte) (*Image, error) {
+	if len(data) < SectorSize*2 {
+		return nil, errors.New("disk image is too small")
+	}
+	boot := data[:SectorSize]
+	g := geometry{
+		sectorsPerCluster: int(boot[0x0D]),
+		reservedSectors:   int(binary.LittleEndian.Uint16(boot[0x0E:])),
+		fats:              int(boot[0x10]),
+		rootEntries:       int(binary.LittleEndian.Uint16(boot[0x11:])),
+		totalSectors:      int(binary.LittleEndian.Uint16(boot[0x13:])),
+		media:             boot[0x15],
+		sectorsPerFAT:     int(binary.LittleEndian.Uint16(boot[0x16:])),
+		sectorsPerTrack:   int(binary.LittleEndian.Uint16(boot[0x18:])),
+		sides:             int(binary.LittleEndian.Uint16(boot[0x1A:])),
+	}
+	if binary.LittleEndian.Uint16(boot[0x0B:]) != SectorSize || !g.valid() {
+		standard, ok := standardGeometries[data[SectorSize]]
+		if !ok {
+			return nil, errors.New("no valid boot sector or media descriptor, not an MSX-DOS disk")
+		}
+		g = standard
+	}
+	if g.totalSectors*SectorSize > len(data) {
+		// images of partly used disks are sometimes cut short
+		data = append(data, make([]byte, g.totalSectors*SectorSize-len(data))...)
+	}
+	return &Image{data: data, geometry: g}, nil
+}
+
+func (g geometry) valid() bool {
+	return g.sectorsPerCluster > 0 && g.sectorsPerCluster&(g.sectorsPerCluster-1) == 0 &&
+		g.reservedSectors > 0 && g.fats > 0 && g.fats <= 2 && g.rootEntries > 0 &&
+		g.totalSectors > 0 && g.sectorsPerFAT > 0 && g.media >= 0xF0
+}
+
+// Size returns the size of the disk in bytes.
+func (d *Image) Size() int {
+	return d.totalSectors * SectorSize
+}
+
+func (d *Image) fatOffset() int {
+	return d.reservedSectors * SectorSize
+}
+
+func (d *Image) rootOffset() int {
+	return (d.reservedSectors + d.fats*d.sectorsPerFAT) * SectorSize
+}
+
+func (d *Image) dataSector() int {
+	return d.reservedSectors + d.fats*d.sectorsPerFAT + d.rootEntries*entrySize/SectorSize
+}
+
+func (d *Image) clusterSize() int {
+	return d.sectorsPerCluster * SectorSize
+}
+
+// clusters returns the number of data clusters; they are numbered from 2.
+func (d *Image) clusters() int {
+	return (d.totalSectors - d.dataSector()) / d.sectorsPerCluster
+}
+
+func (d *Image) clusterOffset(cluster int) int {
+	return (d.dataSector() + (cluster-2)*d.sectorsPerCluster) * SectorSize
+}
+
+// fat returns the FAT entry of a cluster, from the first FAT.
+func (d *Image) fat(cluster int) int {
+	offset := d.fatOffset() + cluster*3/2
+	if offset+1 >= len(d.data) {
+		return clusterEnd
+	}
+	value := int(d.data[offset]) | int(d.data[offset+1])<<8
+	if cluster%2 == 1 {
+		return value >> 4
+	}
+	return value & 0xFFF
+}
+
+// chain returns the clusters of a file starting at cluster.
+func (d *Image) chain(cluster int) ([]int, error) {
+	var chain []int
+	for cluster >= 2 && cluster < clusterEnd {
+		if cluster >= d.clusters()+2 {
+			return chain, fmt.Errorf("cluster %d is outside the disk", cluster)
+		}
+		if len(chain) > d.clusters() {
+			return chain, errors.New("cluster chain loops")
+		}
+		chain = append(chain, cluster)
+		cluster = d.fat(cluster)
+	}
+	return chain, nil
+}
+
+// FreeSpace returns the number of free bytes.
+func (d *Image) FreeSpace() int {
+	free := 0
+	for cluster := 2; cluster < d.clusters()+2; cluster++ {
+		if d.fat(cluster) == clusterFree {
+			free++
+		}
+	}
+	return free * d.clusterSize()
+}
+
+// directory returns the offsets of the entry slots of a directory: the root
+// directory for cluster 0, or the clusters of a subdirectory.
+func (d *Image) directory(cluster int) ([]int, error) {
+	var offsets []int
+	if cluster == 0 {
+		for i := 0; i < d.rootEntries; i++ {
+			offsets = append(offsets, d.rootOffset()+i*entrySize)
+		}
+		return offsets, nil
+	}
+	chain, err := d.chain(cluster)
+	for _, c := range chain {
+		for i := 0; i < d.clusterSize(); i += entrySize {
+			offsets = append(offsets, d.clusterOffset(c)+i)
+		}
+	}
+	return offsets, err
+}
+
+// entries returns the files and subdirectories of a directory, without the
+// volume label and the . and .. entries.
+func (d *Image) entries(cluster int) ([]Entry, error) {
+	offsets, err := d.directory(cluster)
+	var entries []Entry
+	for _, offset := range offsets {
+		if offset+entrySize > len(d.data) {
+			break
+		}
+		raw := d.data[offset : offset+entrySize]
+		if raw[0] == 0x00 {
+			break // end of the directory
+		}
+		if raw[0] == 0xE5 || raw[0] == '.' || raw[11]&AttrVolume != 0 {
+			continue // deleted entry, . and .., volume label
+		}
+		entries = append(entries, Entry{
+			Name:       entryName(raw),
+			Size:       int(binary.LittleEndian.Uint32(raw[28:])),
+			Modified:   entryTime(binary.LittleEndian.Uint16(raw[24:]), binary.LittleEndian.Uint16(raw[22:])),
+			Attributes: raw[11],
+			cluster:    int(binary.LittleEndian.Uint16(raw[26:])),
+			offset:     offset,
+		})
+	}
+	return entries, err
+}
+
+// entryName returns the name of a directory entry as NAME.EXT.
+func entryName(raw []byte) string {
+	name := strings.TrimRight(string(raw[0:8]), " ")
+	if name != "" && name[0] == 0x05 {
+		name = "\xE5" + name[1:] // a first byte of 0xE5 is stored as 0x05
+	}
+	if extension := strings.TrimRight(string(raw[8:11]), " "); extension != "" {
+		return name + "." + extension
+	}
+	return name
+}
+
+// entryTime decodes the FAT date and time.
+func entryTime(date, clock uint16) time.Time {
+	if date == 0 {
+		return time.Time{}
+	}
+	return time.Date(1980+int(date>>9), time.Month(date>>5&0x0F), int(date&0x1F),
+		int(clock>>11), int(clock>>5&0x3F), int(clock&0x1F)*2, 0, time.UTC)
+}
+
+// lookup finds the entry of a path; directories are separated by / or \.
+func (d *Image) lookup(name string) (Entry, error) {
+	parts := splitPath(name)
+	if len(parts) == 0 {
+		return Entry{}, errors.New("empty path")
+	}
+	cluster := 0
+	for i, part := range parts {
+		entries, err := d.entries(cluster)
+		if err != nil {
+			return Entry{}, err
+		}
+		found := false
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name, part) {
+				if i < len(parts)-1 {
+					if !entry.IsDir() {
+						return Entry{}, fmt.Errorf("%s is not a directory", entry.Name)
+					}
+					cluster = entry.cluster
+				} else {
+					return entry, nil
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return Entry{}, fmt.Errorf("%s not found on disk", name)
+		}
+	}
+	return Entry{}, fmt.Errorf("%s not found on disk", name)
+}
+
+func splitPath(name string) []string {
+	var parts []string
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part != "." {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// List returns the entries of a directory; an empty name is the root
+// directory.
+func (d *Image) List(dir string) ([]Entry, error) {
+	if len(splitPath(dir)) == 0 {
+		return d.entries(0)
+	}
+	entry, err := d.lookup(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return []Entry{entry}, nil
+	}
+	return d.entries(entry.cluster)
+}
+
+// Walk returns all files of the disk with their paths, in directory order
+// and descending into subdirectories where they are found.
+func (d *Image) Walk() ([]string, []Entry, error) {
+	var names []string
+	var files []Entry
+	var walk func(cluster int, prefix string, depth int) error
+	walk = func(cluster int, prefix string, depth int) error {
+		if depth > 16 {
+			return errors.New("directories are nested too deep")
+		}
+		entries, err := d.entries(cluster)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				if err := walk(entry.cluster, path.Join(prefix, entry.Name), depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			names = append(names, path.Join(prefix, entry.Name))
+			files = append(files, entry)
+		}
+		return nil
+	}
+	err := walk(0, "", 0)
+	return names, files, err
+}
+
+// ReadFile returns the contents of a file.
+func (d *Image) ReadFile(name string) ([]byte, error) {
+	entry, err := d.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", entry.Name)
+	}
+	return d.read(entry)
+}
+
+func (d *Image) read(entry Entry) ([]byte, error) {
+	chain, err := d.chain(entry.cluster)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", entry.Name, err)
+	}
+	data := make([]byte, 0, len(chain)*d.clusterSize())
+	for _, cluster := range chain {
+		offset := d.clusterOffset(cluster)
+		end := min(offset+d.clusterSize(), len(d.data))
+		if offset >= end {
+			return nil, fmt.Errorf("%s: cluster %d is outside the image", entry.Name, cluster)
+		}
+		data = append(data, d.data[offset:end]...)
+	}
+	if len(data) < entry.Size {
+		return nil, fmt.Errorf("%s: file has %d bytes, but its clusters only %d", entry.Name, entry.Size, len(data))
+	}
+	return data[:entry.Size], nil
+}
@@ -0,0 +1,140 @@
+package disk
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testDisk builds a 720 KB disk with HELLO.BAS in two clusters, a deleted
+// file, a volume label and the directory GAMES with TITLE.SC5.
+func testDisk(bootSector bool) []byte {
+	data := make([]byte, 1440*SectorSize)
+	if bootSector {
+		boot := data[:SectorSize]
+		binary.LittleEndian.PutUint16(boot[0x0B:], SectorSize)
+		boot[0x0D] = 2
+		binary.LittleEndian.PutUint16(boot[0x0E:], 1)
+		boot[0x10] = 2
+		binary.LittleEndian.PutUint16(boot[0x11:], 112)
+		binary.LittleEndian.PutUint16(boot[0x13:], 1440)
+		boot[0x15] = 0xF9
+		binary.LittleEndian.PutUint16(boot[0x16:], 3)
+		binary.LittleEndian.PutUint16(boot[0x18:], 9)
+		binary.LittleEndian.PutUint16(boot[0x1A:], 2)
+	}
+
+	fat := data[SectorSize : SectorSize*4]
+	setFAT := func(cluster, value int) {
+		offset := cluster * 3 / 2
+		if cluster%2 == 0 {
+			fat[offset] = byte(value)
+			fat[offset+1] = fat[offset+1]&0xF0 | byte(value>>8)
+		} else {
+			fat[offset] = fat[offset]&0x0F | byte(value<<4)
+			fat[offset+1] = byte(value >> 4)
+		}
+	}
+	fat[0], fat[1], fat[2] = 0xF9, 0xFF, 0xFF
+	setFAT(2, 3)
+	setFAT(3, 0xFFF)
+	setFAT(4, 0xFFF)
+	setFAT(5, 0xFFF)
+
+	entry := func(offset int, name string, attributes byte, cluster, size int) {
+		raw := data[offset : offset+entrySize]
+		copy(raw, name)
+		raw[11] = attributes
+		binary.LittleEndian.PutUint16(raw[22:], 12<<11|34<<5|28) // 12:34:56
+		binary.LittleEndian.PutUint16(raw[24:], 10<<9|5<<5|1)    // 1990-05-01
+		binary.LittleEndian.PutUint16(raw[26:], uint16(cluster))
+		binary.LittleEndian.PutUint32(raw[28:], uint32(size))
+	}
+	root := 7 * SectorSize
+	entry(root, "MYDISK     ", AttrVolume, 0, 0)
+	entry(root+32, "\xE5LD     TXT", 0, 6, 100)
+	entry(root+64, "HELLO   BAS", AttrArchive, 2, 1500)
+	entry(root+96, "GAMES      ", AttrDirectory, 4, 0)
+
+	clusterOffset := func(cluster int) int { return (14 + (cluster-2)*2) * SectorSize }
+	for i := 0; i < 1500; i++ {
+		data[clusterOffset(2)+i] = byte(i)
+	}
+	entry(clusterOffset(4), ".          ", AttrDirectory, 4, 0)
+	entry(clusterOffset(4)+32, "..         ", AttrDirectory, 0, 0)
+	entry(clusterOffset(4)+64, "TITLE   SC5", 0, 5, 10)
+	copy(data[clusterOffset(5):], "0123456789")
+	return data
+}
+
+func TestOpen(t *testing.T) {
+	for _, bootSector := range []bool{true, false} {
+		d, err := Open(testDisk(bootSector))
+		assert.NoError(t, err)
+		assert.Equal(t, 720*1024, d.Size())
+		assert.Equal(t, 3, d.sectorsPerFAT)
+		assert.Equal(t, (713-4)*1024, d.FreeSpace())
+	}
+
+	_, err := Open(make([]byte, 100))
+	assert.Error(t, err)
+	_, err = Open(make([]byte, 720*1024))
+	assert.Error(t, err)
+}
+
+func TestList(t *testing.T) {
+	d, err := Open(testDisk(true))
+	assert.NoError(t, err)
+
+	entries, err := d.List("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "HELLO.BAS", entries[0].Name)
+	assert.Equal(t, 1500, entries[0].Size)
+	assert.Equal(t, time.Date(1990, 5, 1, 12, 34, 56, 0, time.UTC), entries[0].Modified)
+	assert.True(t, entries[1].IsDir())
+
+	entries, err = d.List("games")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "TITLE.SC5", entries[0].Name)
+
+	names, _, err := d.Walk()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HELLO.BAS", "GAMES/TITLE.SC5"}, names)
+}
+
+func TestReadFile(t *testing.T) {
+	d, err := Open(testDisk(true))
+	assert.NoError(t, err)
+
+	data, err := d.ReadFile("hello.bas")
+	assert.NoError(t, err)
+	assert.Len(t, data, 1500)
+	assert.Equal(t, byte(1499%256), data[1499])
+
+	data, err = d.ReadFile(`GAMES\TITLE.SC5`)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789"), data)
+
+	_, err = d.ReadFile("OLD.TXT")
+	assert.Error(t, err)
+	_, err = d.ReadFile("GAMES")
+	assert.Error(t, err)
+	_, err = d.ReadFile("HELLO.BAS/X")
+	assert.Error(t, err)
+}
+
+func TestReadFile_BrokenChain(t *testing.T) {
+	data := testDisk(true)
+	// let cluster 3 point back to cluster 2
+	fat := data[SectorSize:]
+	fat[4] = fat[4]&0x0F | 0x20
+	fat[5] = 0x00
+	d, err := Open(data)
+	assert.NoError(t, err)
+	_, err = d.ReadFile("HELLO.BAS")
+	assert.ErrorContains(t, err, "loops")
+}
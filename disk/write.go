@@ -0,0 +1,359 @@
+package disk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sizes of the blank disks that can be created.
+const (
+	Size360K = 360 * 1024
+	Size720K = 720 * 1024
+)
+
+// bootProgram is loaded at 0xC000 with the boot sector and called at 0xC01E
+// by the disk ROM. With the carry flag set it loads MSXDOS.SYS at 0x0100 and
+// starts it; without MSXDOS.SYS it starts Disk BASIC, which runs
+// AUTOEXEC.BAS.
+//
+//	C01E  RET NC              ; first call, nothing to set up
+//	      LD (C059),DE        ; routine to call on disk errors
+//	      LD (C0C4),A         ; 0 when Disk BASIC can be started
+//	      LD (HL),56h         ; install the disk error handler at C056
+//	      INC HL
+//	      LD (HL),C0h
+//	      LD SP,F51Fh
+//	C02E  LD DE,C09F          ; open MSXDOS.SYS
+//	      LD C,0Fh
+//	      CALL F37Dh
+//	      INC A
+//	      JP Z,C063           ; not found
+//	      LD DE,0100h         ; set the transfer address
+//	      LD C,1Ah
+//	      CALL F37Dh
+//	      LD HL,1             ; record size 1
+//	      LD (C0AD),HL
+//	      LD HL,3F00h         ; read the file
+//	      LD DE,C09F
+//	      LD C,27h
+//	      CALL F37Dh
+//	      JP 0100h
+//	C056  DW C058
+//	C058  CALL 0000h          ; disk error handler
+//	      LD A,C
+//	      AND FEh
+//	      CP 2
+//	      JP NZ,C06A
+//	C063  LD A,(C0C4)
+//	      AND A
+//	      JP Z,4022h          ; start Disk BASIC
+//	C06A  LD DE,C079          ; print the message, wait for a key, retry
+//	      LD C,09h
+//	      CALL F37Dh
+//	      LD C,07h
+//	      CALL F37Dh
+//	      JR C02E
+//	C079  "Boot error", "Press any key for retry"
+//	C09F  FCB of MSXDOS.SYS
+//	C0C4  DB 0
+var bootProgram = func() []byte {
+	code := []byte{
+		0xD0,
+		0xED, 0x53, 0x59, 0xC0,
+		0x32, 0xC4, 0xC0,
+		0x36, 0x56,
+		0x23,
+		0x36, 0xC0,
+		0x31, 0x1F, 0xF5,
+		0x11, 0x9F, 0xC0,
+		0x0E, 0x0F,
+		0xCD, 0x7D, 0xF3,
+		0x3C,
+		0xCA, 0x63, 0xC0,
+		0x11, 0x00, 0x01,
+		0x0E, 0x1A,
+		0xCD, 0x7D, 0xF3,
+		0x21, 0x01, 0x00,
+		0x22, 0xAD, 0xC0,
+		0x21, 0x00, 0x3F,
+		0x11, 0x9F, 0xC0,
+		0x0E, 0x27,
+		0xCD, 0x7D, 0xF3,
+		0xC3, 0x00, 0x01,
+		0x58, 0xC0,
+		0xCD, 0x00, 0x00,
+		0x79,
+		0xE6, 0xFE,
+		0xFE, 0x02,
+		0xC2, 0x6A, 0xC0,
+		0x3A, 0xC4, 0xC0,
+		0xA7,
+		0xCA, 0x22, 0x40,
+		0x11, 0x79, 0xC0,
+		0x0E, 0x09,
+		0xCD, 0x7D, 0xF3,
+		0x0E, 0x07,
+		0xCD, 0x7D, 0xF3,
+		0x18, 0xB5,
+	}
+	code = append(code, "Boot error\r\nPress any key for retry\r\n$"...)
+	fcb := make([]byte, 37)
+	copy(fcb[1:], "MSXDOS  SYS")
+	code = append(code, fcb...)
+	return append(code, 0x00)
+}()
+
+// bootProgramOffset is where the disk ROM starts the boot program.
+const bootProgramOffset = 0x1E
+
+// New creates a blank MSX-DOS disk of 360 or 720 KB, with a boot sector that
+// starts MSX-DOS when MSXDOS.SYS and COMMAND.COM are added, or Disk BASIC.
+func New(size int) (*Image, error) {
+	var g geometry
+	switch size {
+	case Size360K:
+		g = standardGeometries[0xF8]
+	case Size720K:
+		g = standardGeometries[0xF9]
+	default:
+		return nil, fmt.Errorf("disks of %d KB cannot be created, only 360 or 720 KB", size/1024)
+	}
+
+	data := make([]byte, size)
+	boot := data[:SectorSize]
+	copy(boot, []byte{0xEB, 0xFE, 0x90}) // the jump of PC boot sectors
+	copy(boot[0x03:], "MSXCONV ")
+	binary.LittleEndian.PutUint16(boot[0x0B:], SectorSize)
+	boot[0x0D] = byte(g.sectorsPerCluster)
+	binary.LittleEndian.PutUint16(boot[0x0E:], uint16(g.reservedSectors))
+	boot[0x10] = byte(g.fats)
+	binary.LittleEndian.PutUint16(boot[0x11:], uint16(g.rootEntries))
+	binary.LittleEndian.PutUint16(boot[0x13:], uint16(g.totalSectors))
+	boot[0x15] = g.media
+	binary.LittleEndian.PutUint16(boot[0x16:], uint16(g.sectorsPerFAT))
+	binary.LittleEndian.PutUint16(boot[0x18:], uint16(g.sectorsPerTrack))
+	binary.LittleEndian.PutUint16(boot[0x1A:], uint16(g.sides))
+	copy(boot[bootProgramOffset:], bootProgram)
+
+	d := &Image{data: data, geometry: g}
+	for i := 0; i < g.fats; i++ {
+		fat := data[d.fatOffset()+i*g.sectorsPerFAT*SectorSize:]
+		fat[0], fat[1], fat[2] = g.media, 0xFF, 0xFF
+	}
+	return d, nil
+}
+
+// Bytes returns the disk image.
+func (d *Image) Bytes() []byte {
+	return d.data
+}
+
+// setFAT changes the entry of a cluster in all FATs.
+func (d *Image) setFAT(cluster, value int) {
+	for i := 0; i < d.fats; i++ {
+		offset := d.fatOffset() + i*d.sectorsPerFAT*SectorSize + cluster*3/2
+		if cluster%2 == 0 {
+			d.data[offset] = byte(value)
+			d.data[offset+1] = d.data[offset+1]&0xF0 | byte(value>>8&0x0F)
+		} else {
+			d.data[offset] = d.data[offset]&0x0F | byte(value<<4)
+			d.data[offset+1] = byte(value >> 4)
+		}
+	}
+}
+
+// allocate links count free clusters into a chain and returns them.
+func (d *Image) allocate(count int) ([]int, error) {
+	var chain []int
+	for cluster := 2; cluster < d.clusters()+2 && len(chain) < count; cluster++ {
+		if d.fat(cluster) == clusterFree {
+			chain = append(chain, cluster)
+		}
+	}
+	if len(chain) < count {
+		return nil, fmt.Errorf("disk full: %d bytes needed, %d free", count*d.clusterSize(), d.FreeSpace())
+	}
+	for i, cluster := range chain {
+		next := 0xFFF
+		if i < len(chain)-1 {
+			next = chain[i+1]
+		}
+		d.setFAT(cluster, next)
+	}
+	return chain, nil
+}
+
+// free releases the clusters of a chain.
+func (d *Image) free(cluster int) {
+	chain, _ := d.chain(cluster)
+	for _, c := range chain {
+		d.setFAT(c, clusterFree)
+	}
+}
+
+// WriteFile adds a file, or replaces the file with the same name. The
+// directory of the file must exist.
+func (d *Image) WriteFile(name string, data []byte, modified time.Time) error {
+	raw, err := rawName(lastPart(name))
+	if err != nil {
+		return err
+	}
+	dir, err := d.parentCluster(name)
+	if err != nil {
+		return err
+	}
+
+	offset := -1
+	if existing, err := d.lookup(name); err == nil {
+		if existing.IsDir() {
+			return fmt.Errorf("%s is a directory", existing.Name)
+		}
+		d.free(existing.cluster)
+		offset = existing.offset
+	}
+
+	clusterSize := d.clusterSize()
+	chain, err := d.allocate((len(data) + clusterSize - 1) / clusterSize)
+	if err != nil {
+		if offset >= 0 {
+			d.data[offset] = 0xE5 // the old contents are gone
+		}
+		return err
+	}
+	for i, cluster := range chain {
+		start := d.clusterOffset(cluster)
+		part := data[i*clusterSize : min((i+1)*clusterSize, len(data))]
+		copy(d.data[start:start+clusterSize], part)
+		clear(d.data[start+len(part) : start+clusterSize])
+	}
+
+	if offset < 0 {
+		if offset, err = d.freeSlot(dir); err != nil {
+			d.free(firstCluster(chain))
+			return err
+		}
+	}
+	entry := d.data[offset : offset+entrySize]
+	clear(entry)
+	copy(entry, raw)
+	entry[11] = AttrArchive
+	binary.LittleEndian.PutUint16(entry[26:], uint16(firstCluster(chain)))
+	binary.LittleEndian.PutUint32(entry[28:], uint32(len(data)))
+	setEntryTime(entry, modified)
+	return nil
+}
+
+func firstCluster(chain []int) int {
+	if len(chain) == 0 {
+		return 0
+	}
+	return chain[0]
+}
+
+// Remove deletes a file.
+func (d *Image) Remove(name string) error {
+	entry, err := d.lookup(name)
+	if err != nil {
+		return err
+	}
+	if entry.IsDir() {
+		return fmt.Errorf("%s is a directory", entry.Name)
+	}
+	d.free(entry.cluster)
+	d.data[entry.offset] = 0xE5
+	return nil
+}
+
+// SetTime changes the date and time of a file or directory.
+func (d *Image) SetTime(name string, modified time.Time) error {
+	entry, err := d.lookup(name)
+	if err != nil {
+		return err
+	}
+	setEntryTime(d.data[entry.offset:entry.offset+entrySize], modified)
+	return nil
+}
+
+// setEntryTime stores a time in the FAT format, which has a resolution of
+// two seconds and starts in 1980.
+func setEntryTime(entry []byte, t time.Time) {
+	if t.Year() < 1980 || t.Year() > 2107 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	date := (t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day()
+	clock := t.Hour()<<11 | t.Minute()<<5 | t.Second()/2
+	binary.LittleEndian.PutUint16(entry[22:], uint16(clock))
+	binary.LittleEndian.PutUint16(entry[24:], uint16(date))
+}
+
+// parentCluster returns the first cluster of the directory of a path, 0 for
+// the root directory.
+func (d *Image) parentCluster(name string) (int, error) {
+	parts := splitPath(name)
+	if len(parts) <= 1 {
+		return 0, nil
+	}
+	dir, err := d.lookup(strings.Join(parts[:len(parts)-1], "/"))
+	if err != nil {
+		return 0, err
+	}
+	if !dir.IsDir() {
+		return 0, fmt.Errorf("%s is not a directory", dir.Name)
+	}
+	return dir.cluster, nil
+}
+
+// freeSlot returns the offset of an unused directory entry. A full
+// subdirectory gets another cluster; the root directory has a fixed size.
+func (d *Image) freeSlot(dir int) (int, error) {
+	offsets, err := d.directory(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, offset := range offsets {
+		if d.data[offset] == 0x00 || d.data[offset] == 0xE5 {
+			return offset, nil
+		}
+	}
+	if dir == 0 {
+		return 0, fmt.Errorf("root directory full: %d entries", d.rootEntries)
+	}
+
+	chain, _ := d.chain(dir)
+	added, err := d.allocate(1)
+	if err != nil {
+		return 0, err
+	}
+	d.setFAT(chain[len(chain)-1], added[0])
+	start := d.clusterOffset(added[0])
+	clear(d.data[start : start+d.clusterSize()])
+	return start, nil
+}
+
+func lastPart(name string) string {
+	parts := splitPath(name)
+	if len(parts) == 0 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// rawName converts NAME.EXT to the 11 characters of a directory entry.
+func rawName(name string) ([]byte, error) {
+	base, extension, _ := strings.Cut(strings.ToUpper(name), ".")
+	if base == "" || len(base) > 8 || len(extension) > 3 {
+		return nil, fmt.Errorf("invalid MSX-DOS file name %q, names have up to 8 characters and an extension of up to 3", name)
+	}
+	for _, c := range []byte(base + extension) {
+		if c <= ' ' || strings.IndexByte(`."*+,/:;<=>?[\]|`, c) >= 0 {
+			return nil, fmt.Errorf("invalid character %q in MSX-DOS file name %q", c, name)
+		}
+	}
+	raw := []byte(fmt.Sprintf("%-8s%-3s", base, extension))
+	if raw[0] == 0xE5 {
+		raw[0] = 0x05
+	}
+	return raw, nil
+}
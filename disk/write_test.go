@@ -0,0 +1,124 @@
+package disk
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	for size, clusters := range map[int]int{Size360K: 354, Size720K: 713} {
+		d, err := New(size)
+		assert.NoError(t, err)
+
+		reopened, err := Open(d.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, size, reopened.Size())
+		assert.Equal(t, d.geometry, reopened.geometry)
+		assert.Equal(t, clusters*1024, reopened.FreeSpace())
+
+		boot := d.Bytes()
+		assert.Equal(t, byte(0xEB), boot[0])
+		assert.Equal(t, byte(0xD0), boot[bootProgramOffset])
+		// the file control block is at 0xC09F, the jump back lands at 0xC02E
+		assert.Equal(t, []byte("MSXDOS  SYS"), boot[0x9F+1:0x9F+12])
+		assert.Equal(t, byte(0x18), boot[0x77])
+		assert.Equal(t, 0x2E, 0x79+int(int8(boot[0x78])))
+	}
+
+	_, err := New(100 * 1024)
+	assert.Error(t, err)
+}
+
+func TestWriteFile(t *testing.T) {
+	d, err := New(Size720K)
+	assert.NoError(t, err)
+	free := d.FreeSpace()
+	modified := time.Date(1988, 3, 4, 5, 6, 8, 0, time.UTC)
+
+	contents := bytes.Repeat([]byte{1, 2, 3}, 1000)
+	assert.NoError(t, d.WriteFile("title.sc5", contents, modified))
+	assert.NoError(t, d.WriteFile("EMPTY", nil, modified))
+	assert.Equal(t, free-3*1024, d.FreeSpace())
+
+	reopened, err := Open(d.Bytes())
+	assert.NoError(t, err)
+	data, err := reopened.ReadFile("TITLE.SC5")
+	assert.NoError(t, err)
+	assert.Equal(t, contents, data)
+	entries, err := reopened.List("")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, modified, entries[0].Modified)
+	assert.Equal(t, "EMPTY", entries[1].Name)
+
+	// both FATs are updated
+	fatSize := d.sectorsPerFAT * SectorSize
+	fats := d.Bytes()[d.fatOffset():]
+	assert.Equal(t, fats[:fatSize], fats[fatSize:2*fatSize])
+
+	// replacing frees the old clusters
+	assert.NoError(t, d.WriteFile("TITLE.SC5", []byte("short"), modified))
+	assert.Equal(t, free-1024, d.FreeSpace())
+	data, err = d.ReadFile("TITLE.SC5")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("short"), data)
+	entries, _ = d.List("")
+	assert.Len(t, entries, 2)
+
+	assert.Error(t, d.WriteFile("TOOLONGNAME.BAS", nil, modified))
+	assert.Error(t, d.WriteFile("A*.BAS", nil, modified))
+	assert.Error(t, d.WriteFile("NODIR/A.BAS", nil, modified))
+	assert.ErrorContains(t, d.WriteFile("BIG", make([]byte, 800*1024), modified), "disk full")
+}
+
+func TestWriteFile_Subdirectory(t *testing.T) {
+	d, err := Open(testDisk(true))
+	assert.NoError(t, err)
+
+	// the directory has one cluster of 32 entries, three are in use
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, d.WriteFile("GAMES/F"+string(rune('A'+i/10))+string(rune('0'+i%10)), []byte{byte(i)}, time.Now()))
+	}
+	entries, err := d.List("GAMES")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 41)
+	chain, err := d.chain(4)
+	assert.NoError(t, err)
+	assert.Len(t, chain, 2)
+
+	data, err := d.ReadFile("GAMES/FD9")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{39}, data)
+}
+
+func TestRemove(t *testing.T) {
+	d, err := Open(testDisk(true))
+	assert.NoError(t, err)
+	free := d.FreeSpace()
+
+	assert.NoError(t, d.Remove("HELLO.BAS"))
+	assert.Equal(t, free+2*1024, d.FreeSpace())
+	_, err = d.ReadFile("HELLO.BAS")
+	assert.Error(t, err)
+	names, _, err := d.Walk()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GAMES/TITLE.SC5"}, names)
+
+	assert.Error(t, d.Remove("HELLO.BAS"))
+	assert.Error(t, d.Remove("GAMES"))
+}
+
+func TestSetTime(t *testing.T) {
+	d, err := Open(testDisk(true))
+	assert.NoError(t, err)
+
+	date := time.Date(2024, 12, 31, 23, 59, 58, 0, time.UTC)
+	assert.NoError(t, d.SetTime("games/title.sc5", date))
+	entries, _ := d.List("GAMES")
+	assert.Equal(t, date, entries[0].Modified)
+
+	assert.Error(t, d.SetTime("NONE", date))
+}
@@ -5,12 +5,26 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/fs"
+	"msxconverter/disk"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// ReadInput reads an input file, which can be a file on a disk or tape
+// image or in an archive given as game.dsk:TITLE.SC5, game.cas:GAME.BAS or
+// game.zip:GAME.DSK:TITLE.SC5.
 func ReadInput(filename string) ([]byte, error) {
+	if image, inner, ok := SplitImagePath(filename); ok {
+		return readImageFile(image, inner)
+	}
+	return readFile(filename)
+}
+
+func readFile(filename string) ([]byte, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("error opening file: %v", err)
@@ -24,17 +38,25 @@ func ReadInput(filename string) ([]byte, error) {
 }
 
 func WriteOutput(fileName, data string) error {
-	file, err := os.Create(fileName)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	_, err = file.WriteString(data)
-	return err
+	return WriteOutputBytes(fileName, []byte(data))
 }
 
+// WriteOutputBytes writes an output file, which can be a file on a disk image
+// given as out.dsk:FILE.EXT. A disk image that does not exist yet is created
+// as a blank 720 KB disk.
 func WriteOutputBytes(fileName string, data []byte) error {
+	if image, inner, ok := SplitImagePath(fileName); ok {
+		if !isDisk(image) {
+			return fmt.Errorf("cannot write to %s, files can only be written to .dsk images", image)
+		}
+		if _, _, nested := SplitImagePath(image); nested {
+			return fmt.Errorf("cannot write to %s, images in archives or other images are read-only", image)
+		}
+		return UpdateDisk(image, true, func(d *disk.Image) error {
+			return d.WriteFile(inner, data, time.Now())
+		})
+	}
+
 	file, err := os.Create(fileName)
 	if err != nil {
 		return err
@@ -45,6 +67,31 @@ func WriteOutputBytes(fileName string, data []byte) error {
 	return err
 }
 
+// GenerateOutputFilename replaces the extension of the input file. Files on
+// a disk or tape image get an output file next to the image.
 func GenerateOutputFilename(inputFile, extension string) string {
+	if image, inner, ok := SplitImagePath(inputFile); ok {
+		inputFile = filepath.Join(filepath.Dir(hostFile(image)), path.Base(strings.ReplaceAll(inner, "\\", "/")))
+	}
 	return strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + extension
 }
+
+// CollectFiles returns all regular files below root, sorted by path.
+func CollectFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// EnsureDir creates the directory of fileName when it does not exist yet.
+func EnsureDir(fileName string) error {
+	return os.MkdirAll(filepath.Dir(fileName), 0o755)
+}
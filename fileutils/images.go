@@ -0,0 +1,183 @@
+package fileutils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"msxconverter/archive"
+	"msxconverter/cassette"
+	"msxconverter/disk"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// imageExtensions are the extensions of disk and tape images and archives
+// that input files can be read from; tapes can also be WAV recordings.
+var imageExtensions = []string{".dsk", ".cas", ".wav", ".zip", ".lzh", ".lha", ".pma"}
+
+// archiveExtensions are the archives among the images.
+var archiveExtensions = []string{".zip", ".lzh", ".lha", ".pma"}
+
+// SplitImagePath splits an input name like game.dsk:TITLE.SC5 into the disk
+// or tape image or archive and the path of the file in it. Images can be
+// nested: game.zip:GAME.DSK:TITLE.SC5 is split into the image
+// game.zip:GAME.DSK and TITLE.SC5. ok is false for other names.
+func SplitImagePath(name string) (image, inner string, ok bool) {
+	lower := strings.ToLower(name)
+	split := -1
+	for _, extension := range imageExtensions {
+		if i := strings.LastIndex(lower, extension+":"); i >= 0 {
+			split = max(split, i+len(extension))
+		}
+	}
+	if split < 0 {
+		return name, "", false
+	}
+	return name[:split], name[split+1:], true
+}
+
+// hostFile returns the file holding an image that may be nested in others.
+func hostFile(image string) string {
+	for {
+		outer, _, ok := SplitImagePath(image)
+		if !ok {
+			return image
+		}
+		image = outer
+	}
+}
+
+// IsImage tells whether name is a whole disk or tape image or archive, which
+// may itself be in an image, not another file in one.
+func IsImage(name string) bool {
+	return slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// IsArchive tells whether name is a ZIP, LHA or PMA archive.
+func IsArchive(name string) bool {
+	return slices.Contains(archiveExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+func isDisk(image string) bool {
+	return strings.ToLower(filepath.Ext(image)) == ".dsk"
+}
+
+// ImageFile is a file of a disk or tape image or archive.
+type ImageFile struct {
+	Name     string // path in the image
+	Size     int
+	Modified time.Time // zero for tapes
+	Type     string    // type of a tape file, empty for disks and archives
+}
+
+// ImageContents lists the files of a disk or tape image or archive, in the
+// order of the image, and describes the image.
+func ImageContents(image string) (files []ImageFile, description string, warnings []string, err error) {
+	data, err := ReadInput(image)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if IsArchive(image) {
+		a, err := archive.Open(image, data)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("error opening archive %s: %v", image, err)
+		}
+		for _, entry := range a.Entries {
+			files = append(files, ImageFile{Name: entry.Name, Size: entry.Size, Modified: entry.Modified})
+		}
+		return files, fmt.Sprintf("%s archive with %d files", a.Type, len(files)), nil, nil
+	}
+	if isDisk(image) {
+		d, err := disk.Open(data)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("error opening disk image %s: %v", image, err)
+		}
+		names, entries, err := d.Walk()
+		for i, name := range names {
+			files = append(files, ImageFile{Name: name, Size: entries[i].Size, Modified: entries[i].Modified})
+		}
+		return files, fmt.Sprintf("%d KB disk, %d files, %d bytes free", d.Size()/1024, len(files), d.FreeSpace()), nil, err
+	}
+
+	tape, baud, warnings, err := OpenTape(image, data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	for _, file := range tape.Files {
+		files = append(files, ImageFile{Name: file.Name, Size: len(file.Data), Type: file.TypeName()})
+	}
+	description = fmt.Sprintf("tape with %d files", len(files))
+	if baud != 0 {
+		description = fmt.Sprintf("tape recording at %d baud with %d files", baud, len(files))
+	}
+	return files, description, warnings, nil
+}
+
+// OpenTape reads a .CAS tape image, or demodulates a WAV recording of a
+// tape first. baud is the speed of a recording, 0 for .CAS images.
+func OpenTape(image string, data []byte) (tape *cassette.Image, baud int, warnings []string, err error) {
+	if strings.ToLower(filepath.Ext(image)) == ".wav" {
+		var cas []byte
+		if cas, baud, warnings, err = cassette.DecodeWAV(data); err != nil {
+			return nil, 0, nil, fmt.Errorf("error reading tape recording %s: %v", image, err)
+		}
+		data = cas
+	}
+	if tape, err = cassette.Open(data); err != nil {
+		return nil, 0, nil, fmt.Errorf("error opening tape image %s: %v", image, err)
+	}
+	return tape, baud, append(warnings, tape.Warnings...), nil
+}
+
+// readImageFile reads a file of a disk or tape image or archive.
+func readImageFile(image, inner string) ([]byte, error) {
+	data, err := ReadInput(image)
+	if err != nil {
+		return nil, err
+	}
+	if IsArchive(image) {
+		a, err := archive.Open(image, data)
+		if err != nil {
+			return nil, fmt.Errorf("error opening archive %s: %v", image, err)
+		}
+		return a.ReadFile(inner)
+	}
+	if isDisk(image) {
+		d, err := disk.Open(data)
+		if err != nil {
+			return nil, fmt.Errorf("error opening disk image %s: %v", image, err)
+		}
+		return d.ReadFile(inner)
+	}
+	tape, _, _, err := OpenTape(image, data)
+	if err != nil {
+		return nil, err
+	}
+	return tape.ReadFile(inner)
+}
+
+// UpdateDisk opens a disk image, lets update change it and writes it back.
+// With create set, a missing image is created as a blank 720 KB disk.
+func UpdateDisk(image string, create bool, update func(d *disk.Image) error) error {
+	var d *disk.Image
+	data, err := os.ReadFile(image)
+	switch {
+	case err == nil:
+		if d, err = disk.Open(data); err != nil {
+			return fmt.Errorf("error opening disk image %s: %v", image, err)
+		}
+	case create && errors.Is(err, fs.ErrNotExist):
+		if d, err = disk.New(disk.Size720K); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	if err := update(d); err != nil {
+		return err
+	}
+	return os.WriteFile(image, d.Bytes(), 0o644)
+}
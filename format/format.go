@@ -1,46 +1,78 @@
 package format
 
 import (
+	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// Confidence tells how sure the detection is about a format.
+type Confidence int
+
+const (
+	ConfidenceNone   Confidence = iota // format unknown
+	ConfidenceLow                      // only the extension or a weak hint
+	ConfidenceMedium                   // header and extension agree
+	ConfidenceHigh                     // signature and structure of the contents
+	ConfidenceForced                   // format passed by the user
+)
+
+var confidenceNames = []string{"none", "low", "medium", "high", "forced"}
+
+func (c Confidence) String() string {
+	if c < 0 || int(c) >= len(confidenceNames) {
+		return fmt.Sprintf("Confidence(%d)", c)
+	}
+	return confidenceNames[c]
+}
+
+// Detection is the result of format detection.
+type Detection struct {
+	Format     string
+	Confidence Confidence
+	Reason     string
+}
+
 func DetectFormat(data []byte, inputFileName string, fileType string) string {
+	return Detect(data, inputFileName, fileType).Format
+}
+
+// Detect detects the format of a file and tells how it came to its choice.
+func Detect(data []byte, inputFileName string, fileType string) Detection {
+	detections := DetectAll(data, inputFileName, fileType)
+	if len(detections) == 0 {
+		extension := strings.ToUpper(strings.TrimLeft(filepath.Ext(inputFileName), "."))
+		if len(data) == 0 {
+			return Detection{"unknown", ConfidenceNone, "empty file"}
+		} else if len(data) >= 7 && data[0] == 0xFE {
+			return Detection{"unknown", ConfidenceNone, "BSAVE header with unknown extension " + extension}
+		}
+		return Detection{"unknown", ConfidenceNone, "no format matches the contents or extension " + extension}
+	}
+	return detections[0]
+}
+
+// DetectAll scores the data with the probe of every format and returns the
+// formats that match, the most likely first.
+func DetectAll(data []byte, inputFileName string, fileType string) []Detection {
 	if len(fileType) > 0 {
 		// don't try to detect when file type is passed
-		return fileType
+		return []Detection{{fileType, ConfidenceForced, "type passed with -t"}}
 	}
-
 	if len(data) == 0 {
-		return "unknown"
+		return nil
 	}
 
-	switch data[0] {
-	case 0xFF:
-		return "BAS" // MSX Basic
-	case 0xFD:
-		return "WB2" // WBASS2 file
-	case 0xFE:
-		if len(data) >= 7 {
-			extension := strings.ToUpper(strings.TrimLeft(filepath.Ext(inputFileName), "."))
-
-			if extension == "GE5" || extension == "SC5" || extension == "SR5" {
-				return "SC5"
-			} else if extension == "SC7" || extension == "SR7" {
-				return "SC7"
-			} else if extension == "SC8" || extension == "PIC" || extension == "SR8" {
-				return "SC8"
-			} else if extension == "S10" || extension == "SCA" {
-				return "S10"
-			} else if extension == "S12" || extension == "SCC" || extension == "SRS" {
-				return "S12"
-			}
-
+	extension := strings.ToUpper(strings.TrimLeft(filepath.Ext(inputFileName), "."))
+	var detections []Detection
+	for _, p := range probes {
+		if confidence, reason := p.score(data, extension); confidence > ConfidenceNone {
+			detections = append(detections, Detection{p.format, confidence, reason})
 		}
-		return "unknown"
-
-	default:
-		// just use the extension for filetype, until we have something better..
-		return strings.ToUpper(strings.TrimLeft(filepath.Ext(inputFileName), "."))
 	}
+	sort.SliceStable(detections, func(i, j int) bool {
+		return detections[i].Confidence > detections[j].Confidence
+	})
+	return detections
 }
@@ -0,0 +1,121 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bsave returns a BSAVE file of the range begin-end.
+func bsave(begin, end int) []byte {
+	data := []byte{0xFE, byte(begin), byte(begin >> 8), byte(end), byte(end >> 8), 0, 0}
+	return append(data, make([]byte, end-begin+1)...)
+}
+
+// basic returns the tokenized program 10 END, 20 END.
+func basic() []byte {
+	return []byte{
+		0xFF,
+		0x07, 0x80, 0x0A, 0x00, 0x81, 0x00, // 10 END, next line at 8007h
+		0x0D, 0x80, 0x14, 0x00, 0x81, 0x00, // 20 END, next line at 800Dh
+		0x00, 0x00,
+	}
+}
+
+func TestDetect_Basic(t *testing.T) {
+	detection := Detect(basic(), "GAME", "")
+	assert.Equal(t, Detection{"BAS", ConfidenceHigh, "first byte 0xFF and valid links of 2 MSX BASIC lines"}, detection)
+
+	broken := basic()
+	broken[7] = 0x05 // link back into line 10
+	detection = Detect(broken, "GAME.BAS", "")
+	assert.Equal(t, "BAS", detection.Format)
+	assert.Equal(t, ConfidenceMedium, detection.Confidence)
+	assert.Contains(t, detection.Reason, "line 2 is broken")
+}
+
+func TestDetect_WBASS2(t *testing.T) {
+	data := []byte{0xFD, 0x00, 0xFF, 'L' | 0x80, 'O', 'O', 'P', 0x00, 0x00, 0x34, 0x12}
+	assert.Equal(t, Detection{"WB2", ConfidenceHigh, "first byte 0xFD and a valid label table of 8 bytes"}, Detect(data, "A.WB2", ""))
+
+	data[5] = 0x00 // a gap in the name
+	assert.Equal(t, ConfidenceMedium, Detect(data, "A.WB2", "").Confidence)
+	assert.Equal(t, ConfidenceMedium, Detect(data[:10], "A.WB2", "").Confidence)
+}
+
+func TestDetect_Screen(t *testing.T) {
+	detection := Detect(bsave(0, 0x7FFF), "TITLE.SC5", "")
+	assert.Equal(t, Detection{"SC5", ConfidenceHigh, "BSAVE &H0000-&H7FFF holds 212 lines of SC5 and the palette at &H7680 with extension SC5"}, detection)
+
+	detection = Detect(bsave(0, 0x69FF), "TITLE.GE5", "")
+	assert.Equal(t, Detection{"SC5", ConfidenceHigh, "BSAVE &H0000-&H69FF holds 212 lines of SC5 with extension GE5"}, detection)
+
+	detection = Detect(bsave(0x1000, 0x2000), "TITLE.SC8", "")
+	assert.Equal(t, "SC8", detection.Format)
+	assert.Equal(t, ConfidenceLow, detection.Confidence)
+}
+
+func TestDetectAll(t *testing.T) {
+	assert.Equal(t, []Detection{{"S12", ConfidenceForced, "type passed with -t"}}, DetectAll(basic(), "GAME.BAS", "S12"))
+	assert.Empty(t, DetectAll(nil, "GAME.BAS", ""))
+
+	// a BASIC program on a cartridge with a ROM extension
+	data := append([]byte{0xFF}, make([]byte, 0x3FFF)...)
+	detections := DetectAll(data, "GAME.ROM", "")
+	assert.Len(t, detections, 2)
+	assert.Equal(t, "BAS", detections[0].Format)
+	assert.Equal(t, "ROM", detections[1].Format)
+
+	detection := Detect([]byte("plain text"), "README.TXT", "")
+	assert.Equal(t, Detection{"unknown", ConfidenceNone, "no format matches the contents or extension TXT"}, detection)
+}
+
+func TestDetect_ScreenRange(t *testing.T) {
+	tests := []struct {
+		end     int
+		formats []string
+	}{
+		{0x69FF, []string{"SC5"}},
+		{0x7FFF, []string{"SC5"}},
+		{0x5FFF, []string{"SC5"}},
+		{0xD3FF, []string{"SC8", "SC7", "S10", "S12"}},
+		{0xFA9F, []string{"SC7", "SC8", "S10", "S12"}},
+		{0xFFFF, []string{"SC7", "SC8", "S10", "S12"}},
+	}
+	for _, test := range tests {
+		detections := DetectAll(bsave(0, test.end), "TITLE.BIN", "")
+		var formats []string
+		for _, detection := range detections {
+			formats = append(formats, detection.Format)
+		}
+		assert.Equal(t, test.formats, formats)
+		assert.Equal(t, ConfidenceMedium, detections[0].Confidence)
+	}
+
+	detection := Detect(bsave(0, 0xFA9F), "TITLE.GRP", "")
+	assert.Equal(t, Detection{"SC7", ConfidenceMedium, "BSAVE &H0000-&HFA9F holds 212 lines of SC7 and the palette at &HFA80, extension GRP"}, detection)
+	assert.Equal(t, ConfidenceLow, DetectAll(bsave(0, 0xD3FF), "PICTURE", "")[1].Confidence)
+
+	// the range decides when it does not fit the mode of the extension
+	assert.Equal(t, "SC5", Detect(bsave(0, 0x69FF), "TITLE.PIC", "").Format)
+	assert.Equal(t, []Detection{{"SC8", ConfidenceHigh, "BSAVE &H0000-&HD3FF holds 212 lines of SC8 with extension PIC"}}, DetectAll(bsave(0, 0xD3FF), "TITLE.PIC", ""))
+
+	// an SC7 picture saved without its palette, and one saved up to the end
+	// of VRAM
+	detections := DetectAll(bsave(0, 0xD3FF), "TITLE.SR7", "")
+	assert.Equal(t, Detection{"SC7", ConfidenceHigh, "BSAVE &H0000-&HD3FF holds 212 lines of SC7 with extension SR7"}, detections[0])
+	assert.Contains(t, DetectAll(bsave(0, 0xD3FF), "TITLE.BIN", ""), Detection{"SC7", ConfidenceLow, "BSAVE &H0000-&HD3FF holds 212 lines of SC7, extension BIN"})
+	detection = Detect(bsave(0, 0xFFFF), "TITLE.BIN", "")
+	assert.Equal(t, Detection{"SC7", ConfidenceMedium, "BSAVE &H0000-&HFFFF holds 212 lines of SC7 and the palette at &HFA80, extension BIN"}, detection)
+
+	// other ranges stay unknown
+	assert.Equal(t, "unknown", Detect(bsave(0, 0x37FF), "TITLE.GRP", "").Format)
+	assert.Equal(t, "unknown", Detect(bsave(0, 0x9FFF), "TITLE.GRP", "").Format)
+	assert.Equal(t, "unknown", Detect(bsave(0x100, 0x69FF), "TITLE.BIN", "").Format)
+}
+
+func TestConfidence_String(t *testing.T) {
+	assert.Equal(t, "high", ConfidenceHigh.String())
+	assert.Equal(t, "Confidence(7)", Confidence(7).String())
+	assert.Equal(t, "Confidence(-1)", Confidence(-1).String())
+}
@@ -0,0 +1,303 @@
+package format
+
+import (
+	"encoding/binary"
+	"fmt"
+	"msxconverter/decoders/images"
+	"slices"
+)
+
+// probe scores how well data matches a format. A probe returns
+// ConfidenceNone when the data cannot be of its format.
+type probe struct {
+	format string
+	score  func(data []byte, extension string) (Confidence, string)
+}
+
+// probes are tried in this order; on equal confidence the first wins.
+var probes = append([]probe{
+	{"VRAM", probeVRAM},
+	{"FNT", probeFont},
+	{"ROM", probeROM},
+	{"COM", probeCOM},
+	{"BAS", probeBasic},
+	{"WB2", probeWBASS2},
+	{"STP", probeStamp},
+}, screenProbes()...)
+
+// screenMode is the layout of a bitmap screen in VRAM.
+type screenMode struct {
+	format        string
+	extensions    []string
+	bytesPerLine  int
+	paletteOffset int // 0 for modes without a palette
+	vramEnd       int // last address of the screen pages
+}
+
+var screenModes = []screenMode{
+	{"SC5", []string{"SC5", "GE5", "SR5"}, images.ScreenWidth / 2, images.PaletteOffset5, 0x7FFF},
+	{"SC7", []string{"SC7", "SR7"}, images.ScreenWidth7 / 2, images.PaletteOffset, 0xFFFF},
+	{"SC8", []string{"SC8", "PIC", "SR8"}, images.ScreenWidth, 0, 0xFFFF},
+	{"S10", []string{"S10", "SCA"}, images.ScreenWidth, images.PaletteOffset, 0xFFFF},
+	{"S12", []string{"S12", "SCC", "SRS"}, images.ScreenWidth, 0, 0xFFFF},
+}
+
+func screenProbes() []probe {
+	result := make([]probe, len(screenModes))
+	for i, mode := range screenModes {
+		result[i] = probe{mode.format, mode.probe}
+	}
+	return result
+}
+
+// probe checks that the BSAVE address range of a screen file fits the VRAM
+// layout of the mode: it starts at 0, holds at least 192 lines and, for
+// modes with a palette, tells whether it reaches the palette.
+func (mode screenMode) probe(data []byte, extension string) (Confidence, string) {
+	if !slices.Contains(mode.extensions, extension) {
+		return mode.probeRange(data, extension)
+	}
+	if len(data) < 7 || data[0] != 0xFE {
+		return ConfidenceLow, "extension " + extension + " without BSAVE header"
+	}
+	begin, end := bsaveRange(data)
+	if !mode.fits(begin, end) {
+		return ConfidenceLow, fmt.Sprintf("BSAVE header with extension %s, but &H%04X-&H%04X is not a %s screen", extension, begin, end, mode.format)
+	}
+	return ConfidenceHigh, fmt.Sprintf("BSAVE &H%04X-&H%04X %s with extension %s", begin, end, mode.contents(end), extension)
+}
+
+// screenRange is a range of BSAVE end addresses that points to screen
+// modes, the likeliest mode first.
+type screenRange struct {
+	first, last int
+	formats     []string
+}
+
+// screenRanges are the end addresses of screens saved from address 0: SC5
+// pictures end after 192 to 256 lines, at the palette or at the end of the
+// page; SC8 and S12 pictures, and SC7 and S10 pictures without their
+// palette, end after 192 or more lines; SC7 and S10 pictures with their
+// palette end at or after it.
+var screenRanges = []screenRange{
+	{0x5FFF, 0x7FFF, []string{"SC5"}},
+	{0xBFFF, images.PaletteOffset + images.MSXPaletteSize - 2, []string{"SC8", "S12", "SC7", "S10"}},
+	{images.PaletteOffset + images.MSXPaletteSize - 1, 0xFFFF, []string{"SC7", "S10", "SC8", "S12"}},
+}
+
+// screenCandidates returns the screen modes the BSAVE end address points to.
+func screenCandidates(end int) []string {
+	for _, r := range screenRanges {
+		if end >= r.first && end <= r.last {
+			return r.formats
+		}
+	}
+	return nil
+}
+
+// probeRange detects a screen from the BSAVE address range alone, for files
+// such as .BIN or .GRP whose extension tells no screen mode, or a .PIC from
+// another source whose range does not fit the mode of its extension.
+func (mode screenMode) probeRange(data []byte, extension string) (Confidence, string) {
+	if len(data) < 7 || data[0] != 0xFE {
+		return ConfidenceNone, ""
+	}
+	begin, end := bsaveRange(data)
+	candidate := slices.Index(screenCandidates(end), mode.format)
+	if begin != 0 || candidate < 0 || extensionFits(extension, begin, end) {
+		return ConfidenceNone, ""
+	}
+	reason := fmt.Sprintf("BSAVE &H%04X-&H%04X %s", begin, end, mode.contents(end))
+	if extension == "" {
+		reason += ", no extension"
+	} else {
+		reason += ", extension " + extension
+	}
+	if candidate > 0 {
+		return ConfidenceLow, reason
+	}
+	return ConfidenceMedium, reason
+}
+
+// extensionFits reports whether the extension belongs to a screen mode
+// that the BSAVE range fits.
+func extensionFits(extension string, begin, end int) bool {
+	for _, mode := range screenModes {
+		if slices.Contains(mode.extensions, extension) && mode.fits(begin, end) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mode screenMode) fits(begin, end int) bool {
+	return begin == 0 && end >= images.Height192*mode.bytesPerLine-1 && end <= mode.vramEnd
+}
+
+// contents describes what a BSAVE range up to end holds of the screen.
+func (mode screenMode) contents(end int) string {
+	lines := min((end+1)/mode.bytesPerLine, images.ScreenHeight)
+	text := fmt.Sprintf("holds %d lines of %s", lines, mode.format)
+	if mode.paletteOffset > 0 && end >= mode.paletteOffset+images.MSXPaletteSize-1 {
+		text += fmt.Sprintf(" and the palette at &H%04X", mode.paletteOffset)
+	}
+	return text
+}
+
+func bsaveRange(data []byte) (begin, end int) {
+	return int(binary.LittleEndian.Uint16(data[1:3])), int(binary.LittleEndian.Uint16(data[3:5]))
+}
+
+// raw VRAM can start with any byte, so only the extension and size count
+func probeVRAM(data []byte, extension string) (Confidence, string) {
+	if extension != "VRM" && extension != "VRAM" {
+		return ConfidenceNone, ""
+	}
+	switch len(data) {
+	case 0x4000, 0x10000, 0x20000:
+		return ConfidenceMedium, fmt.Sprintf("VRAM snapshot of %d KB with extension %s", len(data)/1024, extension)
+	}
+	return ConfidenceLow, "extension " + extension
+}
+
+// fonts can be raw pattern tables or BSAVE files
+func probeFont(data []byte, extension string) (Confidence, string) {
+	if extension != "FNT" && extension != "ALF" {
+		return ConfidenceNone, ""
+	}
+	if len(data) == 2048 || (data[0] == 0xFE && len(data) >= 7+2048) {
+		return ConfidenceMedium, "font of 2 KB with extension " + extension
+	}
+	return ConfidenceLow, "extension " + extension
+}
+
+// cartridge ROMs start with the AB header, ROMs of 48 or 64 KB in page 1
+func probeROM(data []byte, extension string) (Confidence, string) {
+	if extension == "ROM" || extension == "MX1" || extension == "MX2" {
+		if hasROMHeader(data) {
+			return ConfidenceMedium, "AB cartridge header with extension " + extension
+		}
+		return ConfidenceLow, "extension " + extension
+	}
+	if len(data)%0x2000 == 0 && hasROMHeader(data) {
+		return ConfidenceLow, fmt.Sprintf("AB cartridge header in %d KB", len(data)/1024)
+	}
+	return ConfidenceNone, ""
+}
+
+func hasROMHeader(data []byte) bool {
+	return (len(data) >= 0x10 && string(data[0:2]) == "AB") ||
+		(len(data) >= 0x4010 && len(data) <= 0x10000 && string(data[0x4000:0x4002]) == "AB")
+}
+
+// MSX-DOS executables have no header, but often start with a jump
+func probeCOM(data []byte, extension string) (Confidence, string) {
+	if extension != "COM" {
+		return ConfidenceNone, ""
+	}
+	if len(data) >= 3 && data[0] == 0xC3 {
+		if target := int(binary.LittleEndian.Uint16(data[1:3])); target >= 0x100 && target < 0x100+len(data) {
+			return ConfidenceMedium, "jump into the program with extension COM"
+		}
+	}
+	return ConfidenceLow, "extension COM"
+}
+
+// probeBasic follows the line links of a tokenized program, which is saved
+// as loaded at 8001h. Each link points past the 0 that ends its line, and
+// the line numbers go up.
+func probeBasic(data []byte, extension string) (Confidence, string) {
+	if data[0] != 0xFF {
+		if extension == "BAS" {
+			return ConfidenceLow, "extension BAS without 0xFF marker"
+		}
+		return ConfidenceNone, ""
+	}
+	lines, number := 0, -1
+	for offset := 1; offset+2 <= len(data); lines++ {
+		link := int(binary.LittleEndian.Uint16(data[offset:]))
+		if link == 0 {
+			if lines == 0 {
+				return ConfidenceMedium, "first byte 0xFF of a tokenized MSX BASIC file without lines"
+			}
+			return ConfidenceHigh, fmt.Sprintf("first byte 0xFF and valid links of %d MSX BASIC lines", lines)
+		}
+		if offset+4 > len(data) {
+			break
+		}
+		next := link - 0x8000
+		line := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		if next <= offset+4 || next > len(data) || data[next-1] != 0 || line <= number {
+			return ConfidenceMedium, fmt.Sprintf("first byte 0xFF, but the link of line %d is broken", lines+1)
+		}
+		offset, number = next, line
+	}
+	return ConfidenceMedium, fmt.Sprintf("first byte 0xFF, but the program ends after %d lines without end link", lines)
+}
+
+// probeWBASS2 skips the tokenized lines up to the 0xFF end marker; the
+// label table after it holds 8 byte records with 7-bit names.
+func probeWBASS2(data []byte, extension string) (Confidence, string) {
+	if data[0] != 0xFD {
+		if extension == "WB2" {
+			return ConfidenceLow, "extension WB2 without 0xFD marker"
+		}
+		return ConfidenceNone, ""
+	}
+	offset := 1
+	for offset < len(data) && data[offset] != 0xFF {
+		offset += int(data[offset]&127) + 1
+	}
+	if offset >= len(data) {
+		return ConfidenceMedium, "first byte 0xFD, but the listing has no end marker"
+	}
+	table := data[offset+1:]
+	if len(table)%8 != 0 {
+		return ConfidenceMedium, fmt.Sprintf("first byte 0xFD, but the label table of %d bytes is not made of 8 byte records", len(table))
+	}
+	for i := 0; i < len(table); i += 8 {
+		if !labelName(table[i : i+6]) {
+			return ConfidenceMedium, fmt.Sprintf("first byte 0xFD, but label %d has no valid name", i/8)
+		}
+	}
+	return ConfidenceHigh, fmt.Sprintf("first byte 0xFD and a valid label table of %d bytes", len(table))
+}
+
+// labelName reports whether the first 6 bytes of a label record, without
+// the flags in bit 7, are a name padded with zeros.
+func labelName(record []byte) bool {
+	if record[0]&127 == 0 {
+		return false
+	}
+	for i, b := range record {
+		c := b & 127
+		if c == 0 {
+			// only padding may follow
+			for _, rest := range record[i:] {
+				if rest&127 != 0 {
+					return false
+				}
+			}
+			return true
+		}
+		if c <= ' ' || c == 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// stamps start with their size, followed by 4 pixels per byte
+func probeStamp(data []byte, extension string) (Confidence, string) {
+	if extension != "STP" {
+		return ConfidenceNone, ""
+	}
+	if len(data) >= 4 {
+		width := int(binary.LittleEndian.Uint16(data[0:2]))
+		height := int(binary.LittleEndian.Uint16(data[2:4]))
+		if width > 0 && height > 0 && len(data)-4 >= (width*height+3)/4 {
+			return ConfidenceMedium, fmt.Sprintf("stamp of %dx%d with extension STP", width, height)
+		}
+	}
+	return ConfidenceLow, "extension STP"
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"msxconverter/decoders"
+	"msxconverter/decoders/com"
+	"msxconverter/decoders/images"
+	"msxconverter/decoders/msxbasic"
+	"msxconverter/decoders/rom"
+	"msxconverter/decoders/wbass2"
+)
+
+type codec func(data []byte, config decoders.Config) (decoders.DecoderResult, error)
+
+// fileFormat describes a supported MSX file type. Encode is nil for formats
+// that can only be converted to PC formats.
+type fileFormat struct {
+	Type        string
+	Description string
+	Extensions  []string
+	Decode      codec
+	Encode      codec
+}
+
+var fileFormats = []fileFormat{
+	{"SC5", "MSX Screen 5 image", []string{"SC5", "GE5", "SR5"}, images.DecodeScreen5, images.EncodeScreen5},
+	{"SC7", "MSX Screen 7 image", []string{"SC7", "SR7"}, images.DecodeScreen7, images.EncodeScreen7},
+	{"SC8", "MSX Screen 8 image", []string{"SC8", "PIC", "SR8"}, images.DecodeScreen8, images.EncodeScreen8},
+	{"S10", "MSX2+ Screen 10 image (YJK with palette)", []string{"S10", "SCA"}, images.DecodeScreen10, nil},
+	{"S12", "MSX2+ Screen 12 image (YJK)", []string{"S12", "SCC", "SRS"}, images.DecodeScreen12, images.EncodeScreen12},
+	{"VRAM", "VRAM snapshot with VDP registers", []string{"VRM", "VRAM"}, images.DecodeVRAM, nil},
+	{"FNT", "MSX font (2 KB of 8x8 patterns)", []string{"FNT", "ALF"}, images.DecodeFont, images.EncodeFont},
+	{"STP", "Dynamic Publisher stamp", []string{"STP"}, images.DecodeSTP, nil},
+	{"WB2", "WBASS2 assembler source", []string{"WB2"}, decodeWBASS2, nil},
+	{"BAS", "Tokenized MSX BASIC program", []string{"BAS"}, decodeMSXBasic, msxbasic.EncodeMSXBasic},
+	{"ROM", "MSX cartridge ROM (header, mapper, BASIC and disassembly)", []string{"ROM", "MX1", "MX2"}, rom.DecodeROM, nil},
+	{"COM", "MSX-DOS executable (disassembly from 0100h)", []string{"COM"}, com.DecodeCOM, nil},
+}
+
+// lookupFormat returns the format with the given type, or nil.
+func lookupFormat(fileType string) *fileFormat {
+	for i := range fileFormats {
+		if fileFormats[i].Type == fileType {
+			return &fileFormats[i]
+		}
+	}
+	return nil
+}
+
+func formatTypes() []string {
+	types := make([]string, len(fileFormats))
+	for i, f := range fileFormats {
+		types[i] = f.Type
+	}
+	return types
+}
+
+func decodeMSXBasic(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	return msxbasic.DecodeMSXBasic(data)
+}
+
+func decodeWBASS2(data []byte, config decoders.Config) (decoders.DecoderResult, error) {
+	if config.Assemble != "" {
+		return wbass2.AssembleWBASS2(data, config)
+	}
+	if config.Symbols != "" {
+		return wbass2.DecodeWBASS2Symbols(data, config)
+	}
+	return wbass2.DecodeWBASS2(data)
+}
@@ -2,7 +2,10 @@ module msxconverter
 
 go 1.22
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/image v0.20.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"msxconverter/fileutils"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Index formats written after converting a disk or tape image.
+const (
+	indexHTML     = "html"
+	indexMarkdown = "md"
+	indexNone     = "none"
+)
+
+var indexFormats = []string{indexHTML, indexMarkdown, indexNone}
+
+// thumbnailExtensions are the outputs shown as thumbnails in an index.
+var thumbnailExtensions = []string{".png", ".gif", ".jpg", ".jpeg", ".bmp", ".webp"}
+
+// imageArguments tells whether the command line converts whole disk or tape
+// images: one or more images, or one image followed by an output directory.
+// It returns the images with their output directories.
+func imageArguments(args []string, root, outputDir string) (images, outputDirs []string, ok bool) {
+	if root != "" || len(args) == 0 || !fileutils.IsImage(args[0]) {
+		return nil, nil, false
+	}
+	if len(args) == 2 && outputDir == "" && !fileutils.IsImage(args[1]) {
+		return args[:1], args[1:], true
+	}
+	for _, arg := range args {
+		if !fileutils.IsImage(arg) {
+			return nil, nil, false
+		}
+		name := strings.TrimSuffix(arg, filepath.Ext(arg))
+		switch {
+		case outputDir == "":
+			outputDirs = append(outputDirs, name)
+		case len(args) == 1:
+			outputDirs = append(outputDirs, outputDir)
+		default:
+			outputDirs = append(outputDirs, filepath.Join(outputDir, filepath.Base(name)))
+		}
+	}
+	return args, outputDirs, true
+}
+
+// imageJobs returns a job for every file of a disk or tape image or archive,
+// with outputs below outputDir in the directories of the image. Files that
+// only differ in their extension keep it in the output name, e.g.
+// TITLE_SC7.png. Images in the image are scanned recursively; the returned
+// files are in the order of the jobs.
+func imageJobs(image, outputDir string) ([]batchJob, []fileutils.ImageFile, string, error) {
+	files, description, warnings, err := fileutils.ImageContents(image)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	for _, warning := range warnings {
+		log.Printf("Warning: %s: %s", image, warning)
+	}
+
+	bases := map[string]int{}
+	for _, file := range files {
+		bases[strings.ToUpper(strings.TrimSuffix(file.Name, path.Ext(file.Name)))]++
+	}
+	var jobs []batchJob
+	var jobFiles []fileutils.ImageFile
+	for _, file := range files {
+		output := strings.TrimSuffix(file.Name, path.Ext(file.Name))
+		if bases[strings.ToUpper(output)] > 1 {
+			output = strings.ReplaceAll(file.Name, ".", "_")
+		}
+		job := batchJob{input: image + ":" + file.Name, output: filepath.Join(outputDir, filepath.FromSlash(output))}
+
+		// disks and archives in archives are converted to a directory
+		if expandable(file.Name) {
+			nestedJobs, nestedFiles, _, err := imageJobs(job.input, job.output)
+			if err == nil {
+				for _, nested := range nestedFiles {
+					nested.Name = file.Name + ":" + nested.Name
+					jobFiles = append(jobFiles, nested)
+				}
+				jobs = append(jobs, nestedJobs...)
+				continue
+			}
+			log.Printf("Warning: %v", err)
+		}
+		jobs = append(jobs, job)
+		jobFiles = append(jobFiles, file)
+	}
+	return jobs, jobFiles, description, nil
+}
+
+// expandable tells whether batch mode converts the files of an image found
+// while scanning. WAV files are only read as tapes when given explicitly.
+func expandable(name string) bool {
+	return fileutils.IsImage(name) && strings.ToLower(filepath.Ext(name)) != ".wav"
+}
+
+// indexEntry is a file of an image in the index.
+type indexEntry struct {
+	name   string // path in the image
+	size   int
+	format string
+	output string // path relative to the index, empty when not converted
+	thumb  bool
+	reason string // why the file was not converted
+}
+
+// indexEntries describes the results of an image conversion for the index;
+// results are in the order of the files.
+func indexEntries(files []fileutils.ImageFile, results []batchResult, outputDir string) []indexEntry {
+	var entries []indexEntry
+	for i, result := range results {
+		entry := indexEntry{name: files[i].Name, size: files[i].Size, format: result.format}
+		switch {
+		case result.err != nil:
+			entry.reason = result.err.Error()
+		case result.skipped:
+			entry.reason = "unknown format"
+		default:
+			if rel, err := filepath.Rel(outputDir, result.output); err == nil {
+				entry.output = filepath.ToSlash(rel)
+			}
+			entry.thumb = slices.Contains(thumbnailExtensions, strings.ToLower(path.Ext(entry.output)))
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// writeIndex writes index.html or index.md to the output directory of an
+// image, with links to the converted files and the files that failed.
+func writeIndex(image, description string, files []fileutils.ImageFile, results []batchResult, outputDir, indexFormat string) (string, error) {
+	if indexFormat == indexNone {
+		return "", nil
+	}
+	entries := indexEntries(files, results, outputDir)
+	var index string
+	if indexFormat == indexMarkdown {
+		index = markdownIndex(filepath.Base(image), description, entries)
+	} else {
+		index = htmlIndex(filepath.Base(image), description, entries)
+	}
+	fileName := filepath.Join(outputDir, "index."+indexFormat)
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return "", err
+	}
+	return fileName, fileutils.WriteOutput(fileName, index)
+}
+
+func markdownIndex(title, description string, entries []indexEntry) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s\n\n", title)
+	fmt.Fprintf(&out, "%s.\n\n", description)
+	out.WriteString("| File | Size | Format | Output |\n|---|---:|---|---|\n")
+	for _, entry := range entries {
+		if entry.output == "" {
+			continue
+		}
+		target := pathURL(entry.output)
+		link := fmt.Sprintf("[%s](%s)", markdownCell(path.Base(entry.output)), target)
+		if entry.thumb {
+			link = fmt.Sprintf("[![%s](%s)](%s)", markdownCell(entry.name), target, target)
+		}
+		fmt.Fprintf(&out, "| %s | %d | %s | %s |\n", markdownCell(entry.name), entry.size, markdownCell(entry.format), link)
+	}
+
+	failed := false
+	for _, entry := range entries {
+		if entry.output != "" {
+			continue
+		}
+		if !failed {
+			out.WriteString("\n## Not converted\n\n")
+			failed = true
+		}
+		fmt.Fprintf(&out, "- %s (%d bytes): %s\n", entry.name, entry.size, entry.reason)
+	}
+	return out.String()
+}
+
+// pathURL escapes each segment of a slash separated path for use as a
+// relative URL.
+func pathURL(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// markdownCell escapes the pipes that would end a Markdown table cell.
+func markdownCell(text string) string {
+	return strings.ReplaceAll(text, "|", "\\|")
+}
+
+func htmlIndex(title, description string, entries []indexEntry) string {
+	var out strings.Builder
+	title = html.EscapeString(title)
+	fmt.Fprintf(&out, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", title)
+	out.WriteString("<style>\nbody { font-family: sans-serif; }\ntd { padding: 2px 8px; vertical-align: middle; }\n" +
+		"img { max-width: 256px; image-rendering: pixelated; }\n</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&out, "<h1>%s</h1>\n", title)
+	fmt.Fprintf(&out, "<p>%s.</p>\n", html.EscapeString(description))
+	out.WriteString("<table>\n<tr><th>File</th><th>Size</th><th>Format</th><th>Output</th></tr>\n")
+	for _, entry := range entries {
+		if entry.output == "" {
+			continue
+		}
+		link := html.EscapeString(pathURL(entry.output))
+		content := html.EscapeString(path.Base(entry.output))
+		if entry.thumb {
+			content = fmt.Sprintf("<img src=\"%s\" alt=\"%s\" loading=\"lazy\">", link, html.EscapeString(entry.name))
+		}
+		fmt.Fprintf(&out, "<tr><td>%s</td><td>%d</td><td>%s</td><td><a href=\"%s\">%s</a></td></tr>\n",
+			html.EscapeString(entry.name), entry.size, html.EscapeString(entry.format), link, content)
+	}
+	out.WriteString("</table>\n")
+
+	failed := false
+	for _, entry := range entries {
+		if entry.output != "" {
+			continue
+		}
+		if !failed {
+			out.WriteString("<h2>Not converted</h2>\n<ul>\n")
+			failed = true
+		}
+		fmt.Fprintf(&out, "<li>%s (%d bytes): %s</li>\n", html.EscapeString(entry.name), entry.size, html.EscapeString(entry.reason))
+	}
+	if failed {
+		out.WriteString("</ul>\n")
+	}
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
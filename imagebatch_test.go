@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndex_EscapesPaths(t *testing.T) {
+	entries := []indexEntry{
+		{name: "A|B.SC5", size: 7, format: "SC5", output: "my disk/50% #1/A|B.png", thumb: true},
+		{name: "C (1).BAS", size: 9, format: "BAS", output: "my disk/C (1).txt"},
+	}
+
+	markdown := markdownIndex("Disk", "2 files", entries)
+	assert.Contains(t, markdown, "| A\\|B.SC5 | 7 | SC5 | [![A\\|B.SC5](my%20disk/50%25%20%231/A%7CB.png)](my%20disk/50%25%20%231/A%7CB.png) |\n")
+	assert.Contains(t, markdown, "| C (1).BAS | 9 | BAS | [C (1).txt](my%20disk/C%20%281%29.txt) |\n")
+
+	index := htmlIndex("Disk", "2 files", entries)
+	assert.Contains(t, index, `<img src="my%20disk/50%25%20%231/A%7CB.png" alt="A|B.SC5" loading="lazy">`)
+	assert.Contains(t, index, `<a href="my%20disk/C%20%281%29.txt">C (1).txt</a>`)
+}
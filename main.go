@@ -6,49 +6,263 @@ import (
 	"log"
 	"msxconverter/decoders"
 	"msxconverter/decoders/images"
-	"msxconverter/decoders/msxbasic"
 	"msxconverter/decoders/wbass2"
 	"msxconverter/fileutils"
 	"msxconverter/format"
 	"os"
+	"runtime"
+	"slices"
 	"strings"
 )
 
-var validTypes = map[string]bool{
-	"SC5": true,
-	"SC7": true,
-	"SC8": true,
-	"S10": true,
-	"S12": true,
-	"STP": true,
-	"WB2": true,
-	"BAS": true,
+var validAssemble = map[string]bool{
+	"":    true,
+	"bin": true,
+	"raw": true,
+}
+
+// command is a subcommand of msxconverter.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"convert", "convert [options] inputfile(s) [outputfile]", "Convert MSX files to PC formats (default command)", runConvert},
+		{"info", "info [options] inputfile...", "Show the header and metadata of MSX files", runInfo},
+		{"detect", "detect [options] inputfile...", "Detect the format of MSX files", runDetect},
+		{"ls", "ls image.dsk[:DIR]|image.cas|image.wav|archive.zip|archive.lzh|archive.pma...", "List the files on MSX-DOS disk images, tape images and archives", runLs},
+		{"disk", "disk create|add|rm|date [options] image.dsk [files]", "Create MSX-DOS disk images and add, delete or date their files", runDisk},
+		{"tape", "tape [options] input.wav|input.cas [outputfile]", "Demodulate a tape recording to a .CAS image, or play a .CAS image as a WAV recording", runTape},
+		{"list-formats", "list-formats", "List the supported formats", runListFormats},
+		{"encode", "encode -t type [options] inputfile [outputfile]", "Convert PC files to MSX formats", runEncode},
+		{"palette", "palette [options] inputfile [outputfile]", "Export the palette of MSX files, or convert a PC palette to an MSX palette", runPalette},
+	}
 }
 
 func main() {
-	typeFlag := flag.String("t", "", "Specify the file type (e.g., BAS, WB2, SC5, SC7, SC8, S10, S12, STP)")
-	outputFormatFlag := flag.String("format", "png", "Specify the output format (e.g., png, jpg)")
-	doubleSizeFlag := flag.Bool("double", false, "Double the image size")
-	verboseFlag := flag.Bool("verbose", false, "Verbose output")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "help", "-h", "-help", "--help":
+			printUsage()
+			return
+		}
+		for _, cmd := range commands {
+			if cmd.name == os.Args[1] {
+				cmd.run(os.Args[2:])
+				return
+			}
+		}
+	}
+
+	// without a subcommand the arguments are passed to convert
+	runConvert(os.Args[1:])
+}
 
-	flag.Parse()
-	args := flag.Args()
+func printUsage() {
+	fmt.Println("Usage: msxconverter <command> [options] [arguments]")
+	fmt.Println("       msxconverter [options] inputfile(s) [outputfile]  (shortcut for convert)")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-13s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Println()
+	fmt.Println("Use msxconverter <command> -h for the options of a command.")
+}
+
+// newFlagSet creates the flag set of a command with its help text.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			flags.Usage = func() {
+				fmt.Println("Usage: msxconverter " + cmd.usage)
+				fmt.Println()
+				fmt.Println(cmd.description + ".")
+				fmt.Println()
+				flags.PrintDefaults()
+			}
+		}
+	}
+	return flags
+}
 
-	if len(args) == 0 || (len(*typeFlag) > 0 && !validTypes[*typeFlag]) {
+func setupLogging(verbose bool) {
+	if verbose {
+		log.SetFlags(log.LstdFlags | log.Lshortfile)
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+func runConvert(arguments []string) {
+	flags := newFlagSet("convert")
+	typeFlag := flags.String("t", "", "Specify the file type (e.g., "+strings.Join(formatTypes(), ", ")+")")
+	outputFormatFlag := flags.String("format", "png", "Specify the output format ("+strings.Join(images.OutputFormats(), ", ")+
+		", or "+strings.Join(images.FontFormats(), ", ")+" for fonts)")
+	qualityFlag := flags.Int("quality", images.DefaultJPEGQuality, "JPEG quality (1-100)")
+	scaleFlag := flags.Int("scale", 1, "Scale the image by an integer factor")
+	doubleSizeFlag := flags.Bool("double", false, "Double the image size (same as -scale 2)")
+	aspectFlag := flags.String("aspect", "", "Aspect correction ("+strings.Join(images.AspectModes(), ", ")+")")
+	filterFlag := flags.String("filter", "", "Comma separated TV filters ("+strings.Join(images.Filters(), ", ")+")")
+	scalerFlag := flags.String("scaler", images.ScalerNearest, "Scaling filter ("+strings.Join(images.Scalers(), ", ")+")")
+	color0Flag := flags.String("color0", "", "Colour 0 of paletted screens: opaque, transparent or a backdrop colour RGB (e.g. 007)")
+	profileFlag := flags.String("profile", "", "Colour profile ("+strings.Join(images.ColorProfiles(), ", ")+") or a JSON profile file")
+	spritesFlag := flags.String("sprites", "", "Render the sprites of a screen ("+strings.Join(images.SpriteModes(), ", ")+")")
+	spriteSizeFlag := flags.Int("sprite-size", 16, "Sprite size, 8 or 16")
+	vdpFlag := flags.String("vdp", "", "VDP registers of a VRAM snapshot, e.g. 0=0x06,1=0x60,23=10")
+	transparentFlag := flags.Bool("transparent", false, "Write colour 0 as transparent (same as -color0 transparent)")
+	verboseFlag := flags.Bool("verbose", false, "Verbose output")
+	assembleFlag := flags.String("assemble", "", "Assemble a WB2 file to a binary (bin for BSAVE, raw for plain code)")
+	symbolsFlag := flags.String("symbols", "", "Write the labels of a WB2 file (xref, openmsx, noice, sym)")
+	disassembleFlag := flags.Bool("disassemble", false, "Disassemble the code of ROM files")
+	recursiveFlag := flags.String("r", "", "Convert all files below a directory")
+	outputDirFlag := flags.String("o", "", "Output directory for batch conversion")
+	jobsFlag := flags.Int("j", runtime.NumCPU(), "Number of files converted in parallel")
+	indexFlag := flags.String("index", indexHTML, "Index written when converting a disk or tape image ("+strings.Join(indexFormats, ", ")+")")
+
+	flags.Parse(arguments)
+	args := flags.Args()
+
+	validType := len(*typeFlag) == 0 || lookupFormat(*typeFlag) != nil
+	validOutputFormat := images.IsOutputFormat(*outputFormatFlag) || images.IsFontFormat(*outputFormatFlag)
+	validQuality := *qualityFlag >= 1 && *qualityFlag <= 100
+	validScale := *scaleFlag >= 1 && *scaleFlag <= 16
+	validAspect := *aspectFlag == "" || slices.Contains(images.AspectModes(), *aspectFlag)
+	validScaler := slices.Contains(images.Scalers(), *scalerFlag)
+	filters, validFilter := parseFilters(*filterFlag)
+	validColor0 := images.IsColor0Mode(*color0Flag)
+	validSprites := (*spritesFlag == "" || slices.Contains(images.SpriteModes(), *spritesFlag)) &&
+		(*spriteSizeFlag == 8 || *spriteSizeFlag == 16)
+	_, vdpErr := images.ParseVDPRegisters(*vdpFlag)
+	validIndex := slices.Contains(indexFormats, *indexFlag)
+	if (len(args) == 0 && *recursiveFlag == "") || !validType || !validOutputFormat || !validQuality ||
+		!validScale || !validAspect || !validScaler || !validFilter || !validColor0 || !validSprites || vdpErr != nil || !validAssemble[*assembleFlag] || !validSymbols(*symbolsFlag) || !validIndex {
 		fmt.Println("Usage: msxconverter [options] inputfile(s) [outputfile]")
-		flag.PrintDefaults()
+		fmt.Println("       msxconverter [options] [-r dir] [-o outputdir] inputs...")
+		fmt.Println("       msxconverter [options] image.dsk|image.cas|image.wav|archive.zip|archive.lzh|archive.pma [outputdir]")
+		flags.PrintDefaults()
 
-		if len(*typeFlag) > 0 && !validTypes[*typeFlag] {
+		if !validType {
 			fmt.Println()
 			fmt.Println("Error: unsupported type passed:", *typeFlag)
 		}
+		if !validOutputFormat {
+			fmt.Println()
+			fmt.Println("Error: unsupported output format passed:", *outputFormatFlag)
+		}
+		if !validQuality {
+			fmt.Println()
+			fmt.Println("Error: quality must be between 1 and 100:", *qualityFlag)
+		}
+		if !validScale {
+			fmt.Println()
+			fmt.Println("Error: scale must be between 1 and 16:", *scaleFlag)
+		}
+		if !validAspect {
+			fmt.Println()
+			fmt.Println("Error: unsupported aspect mode passed:", *aspectFlag)
+		}
+		if !validScaler {
+			fmt.Println()
+			fmt.Println("Error: unsupported scaler passed:", *scalerFlag)
+		}
+		if !validFilter {
+			fmt.Println()
+			fmt.Println("Error: unsupported filter passed:", *filterFlag)
+		}
+		if !validColor0 {
+			fmt.Println()
+			fmt.Println("Error: unsupported colour 0 mode passed:", *color0Flag)
+		}
+		if !validSprites {
+			fmt.Println()
+			fmt.Println("Error: unsupported sprite mode or size passed:", *spritesFlag, *spriteSizeFlag)
+		}
+		if vdpErr != nil {
+			fmt.Println()
+			fmt.Println("Error:", vdpErr)
+		}
+		if !validAssemble[*assembleFlag] {
+			fmt.Println()
+			fmt.Println("Error: unsupported assemble output passed:", *assembleFlag)
+		}
+		if !validSymbols(*symbolsFlag) {
+			fmt.Println()
+			fmt.Println("Error: unsupported symbol format passed:", *symbolsFlag)
+		}
+		if !validIndex {
+			fmt.Println()
+			fmt.Println("Error: unsupported index format passed:", *indexFlag)
+		}
 		os.Exit(1)
 	}
 
-	if *verboseFlag {
-		log.SetFlags(log.LstdFlags | log.Lshortfile)
-	} else {
-		log.SetOutput(os.Stderr)
+	setupLogging(*verboseFlag)
+
+	profile, err := images.LoadColorProfile(*profileFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *doubleSizeFlag && *scaleFlag == 1 {
+		*scaleFlag = 2
+	}
+	if *transparentFlag && *color0Flag == "" {
+		*color0Flag = images.Color0Transparent
+	}
+
+	imageInputs, imageOutputDirs, imageMode := imageArguments(args, *recursiveFlag, *outputDirFlag)
+	if imageMode || isBatch(args, *recursiveFlag, *outputDirFlag) {
+		config := createDecoderConfig(*outputFormatFlag, *scaleFlag, *verboseFlag, nil)
+		config.Quality = *qualityFlag
+		config.Aspect = *aspectFlag
+		config.Scaler = *scalerFlag
+		config.Filters = filters
+		config.Color0 = *color0Flag
+		config.ColorProfile = *profileFlag
+		config.Sprites = *spritesFlag
+		config.SpriteSize = *spriteSizeFlag
+		config.VDPRegisters = *vdpFlag
+		config.Assemble = *assembleFlag
+		config.Symbols = *symbolsFlag
+		config.Disassemble = *disassembleFlag
+
+		var results []batchResult
+		if imageMode {
+			// every file of the disk or tape images is converted, followed
+			// by an index
+			for i, image := range imageInputs {
+				jobs, files, description, err := imageJobs(image, imageOutputDirs[i])
+				if err != nil {
+					log.Fatalf("Error reading image: %v", err)
+				}
+				imageResults := runBatch(jobs, *jobsFlag, *typeFlag, config)
+				index, err := writeIndex(image, description, files, imageResults, imageOutputDirs[i], *indexFlag)
+				if err != nil {
+					log.Fatalf("Error writing index: %v", err)
+				}
+				if index != "" {
+					fmt.Printf("Index of %s: %s\n", image, index)
+				}
+				results = append(results, imageResults...)
+			}
+		} else {
+			jobs, err := collectJobs(args, *recursiveFlag, *outputDirFlag)
+			if err != nil {
+				log.Fatalf("Error collecting input files: %v", err)
+			}
+			results = runBatch(jobs, *jobsFlag, *typeFlag, config)
+		}
+		if !printSummary(results) {
+			os.Exit(1)
+		}
+		return
 	}
 
 	var inputs []string = strings.Split(args[0], ",")
@@ -64,27 +278,52 @@ func main() {
 		os.Exit(1)
 	}
 
-	var palette []byte
-	if len(inputs) > 1 {
-		extra, err := fileutils.ReadInput(inputs[1])
-		if err != nil {
-			log.Fatalf("Error reading palette input: %v", err)
-		}
-		palette = extra
-	}
-
-	config := createDecoderConfig(*outputFormatFlag, *doubleSizeFlag, *verboseFlag, palette)
-
 	// Detect the format of the input file.
+	if *verboseFlag {
+		logDetections(inputs[0], data, *typeFlag)
+	}
 	format := format.DetectFormat(data, inputs[0], *typeFlag)
 	if format == "" {
 		log.Fatalf("Error: could not detect format of input file")
 	}
 
+	// The second input is a palette, or the VDP registers of a VRAM snapshot.
+	var extra []byte
+	if len(inputs) > 1 {
+		extra, err = fileutils.ReadInput(inputs[1])
+		if err != nil {
+			log.Fatalf("Error reading second input: %v", err)
+		}
+		if format != "VRAM" {
+			extra, err = images.PaletteData(extra, inputs[1], profile)
+			if err != nil {
+				log.Fatalf("Error reading palette input: %v", err)
+			}
+		}
+	}
+
+	config := createDecoderConfig(*outputFormatFlag, *scaleFlag, *verboseFlag, extra)
+	config.InputFileName = inputs[0]
+	config.Quality = *qualityFlag
+	config.Aspect = *aspectFlag
+	config.Scaler = *scalerFlag
+	config.Filters = filters
+	config.Color0 = *color0Flag
+	config.ColorProfile = *profileFlag
+	config.Sprites = *spritesFlag
+	config.SpriteSize = *spriteSizeFlag
+	config.VDPRegisters = *vdpFlag
+	config.Assemble = *assembleFlag
+	config.Symbols = *symbolsFlag
+	config.Disassemble = *disassembleFlag
+
 	decoded, err := decodeData(data, format, config)
 	if err != nil {
 		log.Fatalf("Error decoding data: %v", err)
 	}
+	for _, warning := range decoded.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
 
 	err = writeOutput(outputFileName, decoded, inputs[0])
 	if err != nil {
@@ -92,36 +331,38 @@ func main() {
 	}
 }
 
-func createDecoderConfig(outputFormat string, doubleSize, verbose bool, extraData []byte) decoders.Config {
+func validSymbols(symbols string) bool {
+	return symbols == "" || slices.Contains(wbass2.SymbolFormats(), symbols)
+}
+
+// parseFilters splits the comma separated -filter value.
+func parseFilters(value string) ([]string, bool) {
+	if value == "" {
+		return nil, true
+	}
+	filters := strings.Split(value, ",")
+	for _, filter := range filters {
+		if !slices.Contains(images.Filters(), filter) {
+			return nil, false
+		}
+	}
+	return filters, true
+}
+
+func createDecoderConfig(outputFormat string, scale int, verbose bool, extraData []byte) decoders.Config {
 	return decoders.Config{
-		OutputFormat:    outputFormat,
-		DoubleImageSize: doubleSize,
-		VerboseOutput:   verbose,
-		ExtraData:       extraData,
+		OutputFormat:  outputFormat,
+		Scale:         scale,
+		VerboseOutput: verbose,
+		ExtraData:     extraData,
 	}
 }
 
 func decodeData(data []byte, fileType string, config decoders.Config) (decoders.DecoderResult, error) {
-	switch fileType {
-	case "BAS":
-		return msxbasic.DecodeMSXBasic(data)
-	case "WB2":
-		return wbass2.DecodeWBASS2(data)
-	case "SC5":
-		return images.DecodeScreen5(data, config)
-	case "SC7":
-		return images.DecodeScreen7(data, config)
-	case "SC8":
-		return images.DecodeScreen8(data, config)
-	case "S10":
-		return images.DecodeScreen10(data, config)
-	case "S12":
-		return images.DecodeScreen12(data, config)
-	case "STP":
-		return images.DecodeSTP(data, config)
-	default:
-		return decoders.DecoderResult{}, fmt.Errorf("unknown file format: %s", fileType)
+	if f := lookupFormat(fileType); f != nil {
+		return f.Decode(data, config)
 	}
+	return decoders.DecoderResult{}, fmt.Errorf("unknown file format: %s", fileType)
 }
 
 func writeOutput(outputFileName string, decoded decoders.DecoderResult, inputFileName string) error {
@@ -136,7 +377,11 @@ func writeOutput(outputFileName string, decoded decoders.DecoderResult, inputFil
 	if decoded.IsText {
 		fmt.Print(decoded.Text)
 	} else {
-		outputFileName = fileutils.GenerateOutputFilename(inputFileName, ".png")
+		extension := decoded.Extension
+		if extension == "" {
+			extension = ".png"
+		}
+		outputFileName = fileutils.GenerateOutputFilename(inputFileName, extension)
 		return fileutils.WriteOutputBytes(outputFileName, decoded.Buffer.Bytes())
 	}
 	return nil